@@ -0,0 +1,79 @@
+package wasm_go
+
+import "fmt"
+
+// ModuleBuilder assembles a module from function bodies given as raw instruction bytes,
+// as they appear in the code section without the final end. A body is written with the
+// Opcode constants followed by their LEB128 immediates, like
+//
+//	[]byte{byte(OpcodeLocalGet), 0, byte(OpcodeLocalGet), 1, byte(OpcodeI32Add)}
+type ModuleBuilder struct {
+	m module
+}
+
+func NewModuleBuilder() *ModuleBuilder {
+	return &ModuleBuilder{}
+}
+
+// AddFunc adds a function, its index is the number of functions added before it.
+// The locals are declared after the params.
+func (b *ModuleBuilder) AddFunc(params, results []ValType, body []byte, localTypes ...ValType) *ModuleBuilder {
+	fn := function{typeIdx: b.m.typeIdx(funcType{params: params, results: results})}
+	for _, l := range localTypes {
+		if n := len(fn.locals); n > 0 && fn.locals[n-1].valType == l {
+			fn.locals[n-1].count++
+		} else {
+			fn.locals = append(fn.locals, locals{count: 1, valType: l})
+		}
+	}
+	fn.code = append(append([]byte{}, body...), byte(OpcodeEnd))
+	b.m.funcs = append(b.m.funcs, fn)
+	return b
+}
+
+// AddMemory adds a memory of min pages, max is -1 when the memory can grow without limit.
+func (b *ModuleBuilder) AddMemory(min uint32, max int32) *ModuleBuilder {
+	b.m.mems = append(b.m.mems, mem{memType{limits: limits{Min: min, Max: max}}})
+	return b
+}
+
+// Export exports the function at funcIdx as name.
+func (b *ModuleBuilder) Export(name string, funcIdx uint32) *ModuleBuilder {
+	b.m.exports = append(b.m.exports, export{name: name, kind: exportImportKindFunc, idx: funcIdx})
+	return b
+}
+
+// ExportMemory exports the memory at memIdx as name.
+func (b *ModuleBuilder) ExportMemory(name string, memIdx uint32) *ModuleBuilder {
+	b.m.exports = append(b.m.exports, export{name: name, kind: exportImportKindMem, idx: memIdx})
+	return b
+}
+
+// Build encodes the module, which is parsed and validated again so malformed bodies are
+// reported here rather than when the module is instantiated.
+func (b *ModuleBuilder) Build() ([]byte, error) {
+	bytes, err := b.m.encode()
+	if err != nil {
+		return nil, err
+	}
+	p := newParser(bytes)
+	m, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid module: %w", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid module: %w", err)
+	}
+	return bytes, nil
+}
+
+// typeIdx returns the index of t in the type section, adding it when missing.
+func (m *module) typeIdx(t funcType) uint32 {
+	for i, other := range m.types {
+		if other.equal(t) {
+			return uint32(i)
+		}
+	}
+	m.types = append(m.types, t)
+	return uint32(len(m.types) - 1)
+}
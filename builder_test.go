@@ -0,0 +1,55 @@
+package wasm_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleBuilder(t *testing.T) {
+	wasm, err := NewModuleBuilder().
+		AddFunc([]ValType{I32, I32}, []ValType{I32}, []byte{
+			byte(OpcodeLocalGet), 0,
+			byte(OpcodeLocalGet), 1,
+			byte(OpcodeI32Add),
+		}).
+		// the local is the sum of the first two calls of add
+		AddFunc([]ValType{I32}, []ValType{I32}, []byte{
+			byte(OpcodeLocalGet), 0,
+			byte(OpcodeLocalGet), 0,
+			byte(OpcodeCall), 0,
+			byte(OpcodeLocalTee), 1,
+			byte(OpcodeLocalGet), 1,
+			byte(OpcodeCall), 0,
+		}, I32).
+		AddMemory(1, -1).
+		Export("add", 0).
+		Export("quadruple", 1).
+		ExportMemory("memory", 0).
+		Build()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	i, err := NewInterpreter(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ret, err := invokeExport(t, &i, "add", ValueFromI32(1), ValueFromI32(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+	ret, err = invokeExport(t, &i, "quadruple", ValueFromI32(5))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(20)}, ret)
+
+	m, err := Parse(wasm)
+	assert.NoError(t, err)
+	// one type per distinct signature
+	assert.Len(t, m.Types(), 2)
+	assert.Equal(t, []Limits{{Min: 1, Max: -1}}, m.Memories())
+
+	_, err = NewModuleBuilder().AddFunc(nil, nil, nil).Export("f", 1).Build()
+	assert.EqualError(t, err, "invalid module: export f: unknown func 1")
+	_, err = NewModuleBuilder().AddFunc(nil, nil, []byte{byte(OpcodeBr), 1}).Build()
+	assert.EqualError(t, err, "invalid module: func 0: instr 0: unknown label 1")
+}
@@ -3,21 +3,18 @@ package main
 import (
 	"fmt"
 	"wasm_go"
-
-	"github.com/bytecodealliance/wasmtime-go/v9"
 )
 
 func main() {
-	wasm, err := wasmtime.Wat2Wasm(`
-		(module
-			(func (param i32) (param i32) (result i32)
-				local.get 0
-				local.get 1
-				i32.add
-			)
-			(export "add" (func 0))
-		)
-	`)
+	i32 := []wasm_go.ValType{wasm_go.I32}
+	wasm, err := wasm_go.NewModuleBuilder().
+		AddFunc(append(i32, i32...), i32, []byte{
+			byte(wasm_go.OpcodeLocalGet), 0,
+			byte(wasm_go.OpcodeLocalGet), 1,
+			byte(wasm_go.OpcodeI32Add),
+		}).
+		Export("add", 0).
+		Build()
 	if err != nil {
 		panic(err)
 	}
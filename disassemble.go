@@ -0,0 +1,497 @@
+package wasm_go
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Disassemble parses a module and prints its functions in a WAT-like text form.
+func Disassemble(bytes []byte) (string, error) {
+	p := newParser(bytes)
+	var raws [][][]byte
+	p.onCodeInstr = func(funcIdx int, raw []byte) {
+		for len(raws) <= funcIdx {
+			raws = append(raws, nil)
+		}
+		raws[funcIdx] = append(raws[funcIdx], raw)
+	}
+	m, err := p.parse()
+	if err != nil {
+		return "", err
+	}
+
+	importedFuncs := 0
+	for _, imp := range m.imports {
+		if imp.kind == exportImportKindFunc {
+			importedFuncs++
+		}
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("(module\n")
+	for i, f := range m.funcs {
+		fmt.Fprintf(&sb, "  (func (;%d;) (type %d)", importedFuncs+i, f.typeIdx)
+		if int(f.typeIdx) < len(m.types) {
+			writeTypes(&sb, "param", m.types[f.typeIdx].params)
+			writeTypes(&sb, "result", m.types[f.typeIdx].results)
+		}
+		sb.WriteString("\n")
+		for _, l := range f.locals {
+			for j := uint32(0); j < l.count; j++ {
+				fmt.Fprintf(&sb, "    (local %s)\n", typeName(l.valType))
+			}
+		}
+
+		depth := 2
+		for pc, instr := range f.body {
+			if pc == len(f.body)-1 {
+				// the end of the function body
+				break
+			}
+			switch instr.(type) {
+			case *opEnd, *opElse:
+				depth--
+			}
+			sb.WriteString(strings.Repeat("  ", depth))
+			sb.WriteString(instrText(raws[i][pc], instr))
+			sb.WriteString("\n")
+			switch instr.(type) {
+			case *opBlock, *opLoop, *opIf, *opElse:
+				depth++
+			}
+		}
+		sb.WriteString("  )\n")
+	}
+	sb.WriteString(")\n")
+	return sb.String(), nil
+}
+
+func writeTypes(sb *strings.Builder, kind string, types []type_) {
+	if len(types) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, " (%s", kind)
+	for _, t := range types {
+		sb.WriteString(" " + typeName(t))
+	}
+	sb.WriteString(")")
+}
+
+func typeName(t type_) string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case V128:
+		return "v128"
+	case FuncRef:
+		return "funcref"
+	case ExternRef:
+		return "externref"
+	}
+	return fmt.Sprintf("(unknown type 0x%02x)", uint8(t))
+}
+
+// instrOp identifies an instr by its opcode, and by the u32 kind following the
+// opcode of prefixed instrs.
+type instrOp struct {
+	code Opcode
+	kind uint32
+}
+
+func rawInstrOp(raw []byte) instrOp {
+	op := instrOp{code: Opcode(raw[0])}
+	switch op.code {
+	case OpcodeMemoryCopyOrFill, OpcodeSIMD, OpcodeAtomic:
+		r := leb128Reader{bytes: raw, pos: 1}
+		op.kind, _ = r.eatU32()
+	}
+	return op
+}
+
+// name returns the mnemonic of op, or "" when it has none.
+func (op instrOp) name() string {
+	switch op.code {
+	case OpcodeMemoryCopyOrFill:
+		return prefixedOpNames[op.kind]
+	case OpcodeSIMD:
+		return simdOpNames[op.kind]
+	case OpcodeAtomic:
+		return atomicOpNames[op.kind]
+	}
+	return opNames[op.code]
+}
+
+// instrName returns the mnemonic of the raw instr, or "" when it has none.
+func instrName(raw []byte) string {
+	return rawInstrOp(raw).name()
+}
+
+// instrText prints an instr, its name comes from the raw opcode and its
+// immediates from the decoded instr.
+func instrText(raw []byte, instr instr) string {
+	name := instrName(raw)
+	if name == "" {
+		return fmt.Sprintf("(unknown 0x%02x)", raw[0])
+	}
+
+	switch o := instr.(type) {
+	case *opBlock:
+		return name + blockTypeText(o.block)
+	case *opLoop:
+		return name + blockTypeText(o.block)
+	case *opIf:
+		return name + blockTypeText(o.block)
+	case *opBr:
+		return fmt.Sprintf("%s %d", name, o.level)
+	case *opBrIf:
+		return fmt.Sprintf("%s %d", name, o.level)
+	case *opBrTable:
+		sb := strings.Builder{}
+		sb.WriteString(name)
+		for _, level := range o.labelIdxArr {
+			fmt.Fprintf(&sb, " %d", level)
+		}
+		fmt.Fprintf(&sb, " %d", o.defaultIdx)
+		return sb.String()
+	case *opCall:
+		return fmt.Sprintf("%s %d", name, o.funcIdx)
+	case *opReturnCall:
+		return fmt.Sprintf("%s %d", name, o.funcIdx)
+	case *opCallIndirect:
+		return callIndirectText(name, o.typeIdx, o.tableIdx)
+	case *opReturnCallIndirect:
+		return callIndirectText(name, o.typeIdx, o.tableIdx)
+	case *opRefNull:
+		if o.refType == ExternRef {
+			return name + " extern"
+		}
+		return name + " func"
+	case *opRefFunc:
+		return fmt.Sprintf("%s %d", name, o.funcIdx)
+	case *opLocalGet:
+		return fmt.Sprintf("%s %d", name, o.localIdx)
+	case *opLocalSet:
+		return fmt.Sprintf("%s %d", name, o.localIdx)
+	case *opLocalTee:
+		return fmt.Sprintf("%s %d", name, o.localIdx)
+	case *opGlobalGet:
+		return fmt.Sprintf("%s %d", name, o.globalIdx)
+	case *opGlobalSet:
+		return fmt.Sprintf("%s %d", name, o.globalIdx)
+	case *opTableGet:
+		return fmt.Sprintf("%s %d", name, o.tableIdx)
+	case *opTableSet:
+		return fmt.Sprintf("%s %d", name, o.tableIdx)
+	case *opTableGrow:
+		return fmt.Sprintf("%s %d", name, o.tableIdx)
+	case *opTableSize:
+		return fmt.Sprintf("%s %d", name, o.tableIdx)
+	case *opTableFill:
+		return fmt.Sprintf("%s %d", name, o.tableIdx)
+	case *opMemoryInit:
+		return fmt.Sprintf("%s %d", name, o.dataIdx)
+	case *opDataDrop:
+		return fmt.Sprintf("%s %d", name, o.dataIdx)
+	case *opConst:
+		return name + " " + valueText(o.val)
+	case *opLoad:
+		return name + memArgText(o.memIdx, o.offset)
+	case *opStore:
+		return name + memArgText(o.memIdx, o.offset)
+	case *opAtomicLoad:
+		return name + memArgText(o.memIdx, o.offset)
+	case *opAtomicStore:
+		return name + memArgText(o.memIdx, o.offset)
+	}
+	return name
+}
+
+func callIndirectText(name string, typeIdx, tableIdx uint32) string {
+	if tableIdx != 0 {
+		return fmt.Sprintf("%s %d (type %d)", name, tableIdx, typeIdx)
+	}
+	return fmt.Sprintf("%s (type %d)", name, typeIdx)
+}
+
+func blockTypeText(b block) string {
+	switch b.blockType {
+	case blockTypeValue:
+		return " (result " + typeName(b.valType[0]) + ")"
+	case blockTypeIdx:
+		return fmt.Sprintf(" (type %d)", b.typeIdx)
+	}
+	return ""
+}
+
+func memArgText(memIdx uint32, offset uint64) string {
+	text := ""
+	if memIdx != 0 {
+		text = fmt.Sprintf(" %d", memIdx)
+	}
+	if offset != 0 {
+		text += fmt.Sprintf(" offset=%d", offset)
+	}
+	return text
+}
+
+func valueText(v Value) string {
+	switch v.ValType {
+	case I32:
+		return strconv.FormatInt(int64(v.I32()), 10)
+	case I64:
+		return strconv.FormatInt(v.I64(), 10)
+	case F32:
+		return strconv.FormatFloat(float64(v.F32()), 'g', -1, 32)
+	case F64:
+		return strconv.FormatFloat(v.F64(), 'g', -1, 64)
+	case V128:
+		b := v.V128()
+		return fmt.Sprintf("i32x4 0x%08x 0x%08x 0x%08x 0x%08x",
+			binary.LittleEndian.Uint32(b[0:]), binary.LittleEndian.Uint32(b[4:]),
+			binary.LittleEndian.Uint32(b[8:]), binary.LittleEndian.Uint32(b[12:]))
+	}
+	return fmt.Sprintf("(unknown value 0x%x)", v.raw)
+}
+
+// https://webassembly.github.io/spec/core/text/instructions.html
+var opNames = map[Opcode]string{
+	OpcodeUnreachable:       "unreachable",
+	OpcodeNop:               "nop",
+	OpcodeBlock:             "block",
+	OpcodeLoop:              "loop",
+	OpcodeIf:                "if",
+	OpcodeElse:              "else",
+	OpcodeEnd:               "end",
+	OpcodeBr:                "br",
+	OpcodeBrIf:              "br_if",
+	OpcodeBrTable:           "br_table",
+	OpcodeReturn:            "return",
+	OpcodeCall:              "call",
+	OpcodeCallIndirect:      "call_indirect",
+	OpcodeDrop:              "drop",
+	OpcodeSelect:            "select",
+	OpcodeRefNull:           "ref.null",
+	OpcodeRefIsNull:         "ref.is_null",
+	OpcodeRefFunc:           "ref.func",
+	OpcodeLocalGet:          "local.get",
+	OpcodeLocalSet:          "local.set",
+	OpcodeLocalTee:          "local.tee",
+	OpcodeGlobalGet:         "global.get",
+	OpcodeGlobalSet:         "global.set",
+	OpcodeTableGet:          "table.get",
+	OpcodeTableSet:          "table.set",
+	OpcodeI32Load:           "i32.load",
+	OpcodeI64Load:           "i64.load",
+	OpcodeF32Load:           "f32.load",
+	OpcodeF64Load:           "f64.load",
+	OpcodeI32Load8S:         "i32.load8_s",
+	OpcodeI32Load8U:         "i32.load8_u",
+	OpcodeI32Load16S:        "i32.load16_s",
+	OpcodeI32Load16U:        "i32.load16_u",
+	OpcodeI64Load8S:         "i64.load8_s",
+	OpcodeI64Load8U:         "i64.load8_u",
+	OpcodeI64Load16S:        "i64.load16_s",
+	OpcodeI64Load16U:        "i64.load16_u",
+	OpcodeI64Load32S:        "i64.load32_s",
+	OpcodeI64Load32U:        "i64.load32_u",
+	OpcodeI32Store:          "i32.store",
+	OpcodeI64Store:          "i64.store",
+	OpcodeF32Store:          "f32.store",
+	OpcodeF64Store:          "f64.store",
+	OpcodeI32Store8:         "i32.store8",
+	OpcodeI32Store16:        "i32.store16",
+	OpcodeI64Store8:         "i64.store8",
+	OpcodeI64Store16:        "i64.store16",
+	OpcodeI64Store32:        "i64.store32",
+	OpcodeMemorySize:        "memory.size",
+	OpcodeMemoryGrow:        "memory.grow",
+	OpcodeI32Const:          "i32.const",
+	OpcodeI64Const:          "i64.const",
+	OpcodeF32Const:          "f32.const",
+	OpcodeF64Const:          "f64.const",
+	OpcodeI32Eqz:            "i32.eqz",
+	OpcodeI32Eq:             "i32.eq",
+	OpcodeI32Ne:             "i32.ne",
+	OpcodeI32LtS:            "i32.lt_s",
+	OpcodeI32LtU:            "i32.lt_u",
+	OpcodeI32GtS:            "i32.gt_s",
+	OpcodeI32GtU:            "i32.gt_u",
+	OpcodeI32LeS:            "i32.le_s",
+	OpcodeI32LeU:            "i32.le_u",
+	OpcodeI32GeS:            "i32.ge_s",
+	OpcodeI32GeU:            "i32.ge_u",
+	OpcodeI64Eqz:            "i64.eqz",
+	OpcodeI64Eq:             "i64.eq",
+	OpcodeI64Ne:             "i64.ne",
+	OpcodeI64LtS:            "i64.lt_s",
+	OpcodeI64LtU:            "i64.lt_u",
+	OpcodeI64GtS:            "i64.gt_s",
+	OpcodeI64GtU:            "i64.gt_u",
+	OpcodeI64LeS:            "i64.le_s",
+	OpcodeI64LeU:            "i64.le_u",
+	OpcodeI64GeS:            "i64.ge_s",
+	OpcodeI64GeU:            "i64.ge_u",
+	OpcodeF32Eq:             "f32.eq",
+	OpcodeF32Ne:             "f32.ne",
+	OpcodeF32Lt:             "f32.lt",
+	OpcodeF32Gt:             "f32.gt",
+	OpcodeF32Le:             "f32.le",
+	OpcodeF32Ge:             "f32.ge",
+	OpcodeF64Eq:             "f64.eq",
+	OpcodeF64Ne:             "f64.ne",
+	OpcodeF64Lt:             "f64.lt",
+	OpcodeF64Gt:             "f64.gt",
+	OpcodeF64Le:             "f64.le",
+	OpcodeF64Ge:             "f64.ge",
+	OpcodeI32Clz:            "i32.clz",
+	OpcodeI32Ctz:            "i32.ctz",
+	OpcodeI32Popcnt:         "i32.popcnt",
+	OpcodeI32Add:            "i32.add",
+	OpcodeI32Sub:            "i32.sub",
+	OpcodeI32Mul:            "i32.mul",
+	OpcodeI32DivS:           "i32.div_s",
+	OpcodeI32DivU:           "i32.div_u",
+	OpcodeI32RemS:           "i32.rem_s",
+	OpcodeI32RemU:           "i32.rem_u",
+	OpcodeI32And:            "i32.and",
+	OpcodeI32Or:             "i32.or",
+	OpcodeI32Xor:            "i32.xor",
+	OpcodeI32ShL:            "i32.shl",
+	OpcodeI32ShrS:           "i32.shr_s",
+	OpcodeI32ShrU:           "i32.shr_u",
+	OpcodeI32RtoL:           "i32.rotl",
+	OpcodeI32RtoR:           "i32.rotr",
+	OpcodeI64Clz:            "i64.clz",
+	OpcodeI64Ctz:            "i64.ctz",
+	OpcodeI64Popcnt:         "i64.popcnt",
+	OpcodeI64Add:            "i64.add",
+	OpcodeI64Sub:            "i64.sub",
+	OpcodeI64Mul:            "i64.mul",
+	OpcodeI64DivS:           "i64.div_s",
+	OpcodeI64DivU:           "i64.div_u",
+	OpcodeI64RemS:           "i64.rem_s",
+	OpcodeI64RemU:           "i64.rem_u",
+	OpcodeI64And:            "i64.and",
+	OpcodeI64Or:             "i64.or",
+	OpcodeI64Xor:            "i64.xor",
+	OpcodeI64ShL:            "i64.shl",
+	OpcodeI64ShrS:           "i64.shr_s",
+	OpcodeI64ShrU:           "i64.shr_u",
+	OpcodeI64RtoL:           "i64.rotl",
+	OpcodeI64RtoR:           "i64.rotr",
+	OpcodeF32Abs:            "f32.abs",
+	OpcodeF32Neg:            "f32.neg",
+	OpcodeF32Ceil:           "f32.ceil",
+	OpcodeF32Floor:          "f32.floor",
+	OpcodeF32Trunc:          "f32.trunc",
+	OpcodeF32Nearest:        "f32.nearest",
+	OpcodeF32Sqrt:           "f32.sqrt",
+	OpcodeF32Add:            "f32.add",
+	OpcodeF32Sub:            "f32.sub",
+	OpcodeF32Mul:            "f32.mul",
+	OpcodeF32Div:            "f32.div",
+	OpcodeF32Min:            "f32.min",
+	OpcodeF32Max:            "f32.max",
+	OpcodeF32Copysign:       "f32.copysign",
+	OpcodeF64Abs:            "f64.abs",
+	OpcodeF64Neg:            "f64.neg",
+	OpcodeF64Ceil:           "f64.ceil",
+	OpcodeF64Floor:          "f64.floor",
+	OpcodeF64Trunc:          "f64.trunc",
+	OpcodeF64Nearest:        "f64.nearest",
+	OpcodeF64Sqrt:           "f64.sqrt",
+	OpcodeF64Add:            "f64.add",
+	OpcodeF64Sub:            "f64.sub",
+	OpcodeF64Mul:            "f64.mul",
+	OpcodeF64Div:            "f64.div",
+	OpcodeF64Min:            "f64.min",
+	OpcodeF64Max:            "f64.max",
+	OpcodeF64Copysign:       "f64.copysign",
+	OpcodeI32WrapI64:        "i32.wrap_i64",
+	OpcodeI32TruncF32S:      "i32.trunc_f32_s",
+	OpcodeI32TruncF32U:      "i32.trunc_f32_u",
+	OpcodeI32TruncF64S:      "i32.trunc_f64_s",
+	OpcodeI32TruncF64U:      "i32.trunc_f64_u",
+	OpcodeI64ExtendI32S:     "i64.extend_i32_s",
+	OpcodeI64ExtendI32U:     "i64.extend_i32_u",
+	OpcodeI64TruncF32S:      "i64.trunc_f32_s",
+	OpcodeI64TruncF32U:      "i64.trunc_f32_u",
+	OpcodeI64TruncF64S:      "i64.trunc_f64_s",
+	OpcodeI64TruncF64U:      "i64.trunc_f64_u",
+	OpcodeF32ConvertI32S:    "f32.convert_i32_s",
+	OpcodeF32ConvertI32U:    "f32.convert_i32_u",
+	OpcodeF32ConvertI64S:    "f32.convert_i64_s",
+	OpcodeF32ConvertI64U:    "f32.convert_i64_u",
+	OpcodeF32DemoteF64:      "f32.demote_f64",
+	OpcodeF64ConvertI32S:    "f64.convert_i32_s",
+	OpcodeF64ConvertI32U:    "f64.convert_i32_u",
+	OpcodeF64ConvertI64S:    "f64.convert_i64_s",
+	OpcodeF64ConvertI64U:    "f64.convert_i64_u",
+	OpcodeF64PromoteF32:     "f64.promote_f32",
+	OpcodeI32ReinterpretF32: "i32.reinterpret_f32",
+	OpcodeI64ReinterpretF64: "i64.reinterpret_f64",
+	OpcodeF32ReinterpretI32: "f32.reinterpret_i32",
+	OpcodeF64ReinterpretI64: "f64.reinterpret_i64",
+	OpcodeI32Extend8S:       "i32.extend8_s",
+	OpcodeI32Extend16S:      "i32.extend16_s",
+	OpcodeI64Extend8S:       "i64.extend8_s",
+	OpcodeI64Extend16S:      "i64.extend16_s",
+	OpcodeI64Extend32S:      "i64.extend32_s",
+
+	OpcodeReturnCall:         "return_call",
+	OpcodeReturnCallIndirect: "return_call_indirect",
+}
+
+// names of the 0xFC prefixed instrs by their u32 kind
+var prefixedOpNames = map[uint32]string{
+	0:  "i32.trunc_sat_f32_s",
+	1:  "i32.trunc_sat_f32_u",
+	2:  "i32.trunc_sat_f64_s",
+	3:  "i32.trunc_sat_f64_u",
+	4:  "i64.trunc_sat_f32_s",
+	5:  "i64.trunc_sat_f32_u",
+	6:  "i64.trunc_sat_f64_s",
+	7:  "i64.trunc_sat_f64_u",
+	8:  "memory.init",
+	9:  "data.drop",
+	10: "memory.copy",
+	11: "memory.fill",
+	15: "table.grow",
+	16: "table.size",
+	17: "table.fill",
+}
+
+// names of the 0xFD prefixed SIMD instrs by their u32 kind
+var simdOpNames = map[uint32]string{
+	0:   "v128.load",
+	11:  "v128.store",
+	12:  "v128.const",
+	110: "i8x16.add",
+	113: "i8x16.sub",
+	142: "i16x8.add",
+	145: "i16x8.sub",
+	149: "i16x8.mul",
+	174: "i32x4.add",
+	177: "i32x4.sub",
+	181: "i32x4.mul",
+	228: "f32x4.add",
+	229: "f32x4.sub",
+	230: "f32x4.mul",
+	231: "f32x4.div",
+}
+
+// names of the 0xFE prefixed atomic instrs by their u32 kind
+var atomicOpNames = map[uint32]string{
+	0x10: "i32.atomic.load",
+	0x11: "i64.atomic.load",
+	0x17: "i32.atomic.store",
+	0x18: "i64.atomic.store",
+}
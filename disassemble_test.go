@@ -0,0 +1,110 @@
+package wasm_go
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisassemble(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(memory 1)
+			(func (export "add") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.add
+			)
+			(func (param i32) (result f64)
+				(local i64)
+				(block (result f64)
+					f64.const 1.5
+					local.get 0
+					br_if 0
+					i32.const 8
+					f64.load offset=4
+				)
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	text, err := Disassemble(wasm)
+	assert.NoError(t, err)
+	assert.Equal(t, `(module
+  (func (;0;) (type 0) (param i32 i32) (result i32)
+    local.get 0
+    local.get 1
+    i32.add
+  )
+  (func (;1;) (type 1) (param i32) (result f64)
+    (local i64)
+    block (result f64)
+      f64.const 1.5
+      local.get 0
+      br_if 0
+      i32.const 8
+      f64.load offset=4
+    end
+  )
+)
+`, text)
+
+	// 0x06 isn't a core opcode
+	wasm = []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		byte(TypeSection), 0x04, 0x01, 0x60, 0x00, 0x00,
+		byte(FunctionSection), 0x02, 0x01, 0x00,
+		byte(CodeSection), 0x05, 0x01, 0x03, 0x00, 0x06, 0x0b,
+	}
+	text, err = Disassemble(wasm)
+	if assert.NoError(t, err) {
+		assert.Contains(t, text, "    (unknown 0x06)\n")
+	}
+}
+
+func TestDisassembleSIMD(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(memory 1)
+			(func (result v128)
+				i32.const 0
+				v128.load offset=16
+				v128.const i32x4 1 2 3 0xffffffff
+				i32x4.add
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	text, err := Disassemble(wasm)
+	assert.NoError(t, err)
+	assert.Equal(t, `(module
+  (func (;0;) (type 0) (result v128)
+    i32.const 0
+    v128.load offset=16
+    v128.const i32x4 0x00000001 0x00000002 0x00000003 0xffffffff
+    i32x4.add
+  )
+)
+`, text)
+}
+
+func TestOpcodeString(t *testing.T) {
+	cases := []struct {
+		op   Opcode
+		name string
+	}{
+		{OpcodeI32Add, "i32.add"},
+		{OpcodeBrIf, "br_if"},
+		{OpcodeLocalTee, "local.tee"},
+		{OpcodeF64ReinterpretI64, "f64.reinterpret_i64"},
+		{OpcodeI64Load32U, "i64.load32_u"},
+		{OpcodeMemoryGrow, "memory.grow"},
+		{OpcodeMemoryCopyOrFill, "Opcode(0xfc)"},
+		{Opcode(0xff), "Opcode(0xff)"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.name, c.op.String())
+		assert.Equal(t, c.name, fmt.Sprint(c.op))
+	}
+}
@@ -0,0 +1,129 @@
+package wasm_go
+
+import "fmt"
+
+// Encode parses a module and encodes it back to the binary format.
+// Only the type, function, memory, export and code sections are supported.
+func Encode(bytes []byte) ([]byte, error) {
+	p := newParser(bytes)
+	m, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return m.encode()
+}
+
+// https://webassembly.github.io/spec/core/binary/modules.html#binary-module
+func (m module) encode() ([]byte, error) {
+	switch {
+	case len(m.imports) > 0:
+		return nil, fmt.Errorf("encoding the import section is not supported")
+	case len(m.tables) > 0:
+		return nil, fmt.Errorf("encoding the table section is not supported")
+	case len(m.globals) > 0:
+		return nil, fmt.Errorf("encoding the global section is not supported")
+	case m.start != nil:
+		return nil, fmt.Errorf("encoding the start section is not supported")
+	case len(m.elems) > 0:
+		return nil, fmt.Errorf("encoding the element section is not supported")
+	case len(m.datas) > 0:
+		return nil, fmt.Errorf("encoding the data section is not supported")
+	}
+
+	w := leb128Writer{}
+	w.writeBytes([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00})
+
+	if len(m.types) > 0 {
+		s := leb128Writer{}
+		s.writeU32(uint32(len(m.types)))
+		for _, t := range m.types {
+			s.writeU8(0x60)
+			s.writeU32(uint32(len(t.params)))
+			for _, param := range t.params {
+				s.writeU8(uint8(param))
+			}
+			s.writeU32(uint32(len(t.results)))
+			for _, result := range t.results {
+				s.writeU8(uint8(result))
+			}
+		}
+		w.writeSection(TypeSection, s.bytes)
+	}
+
+	if len(m.funcs) > 0 {
+		s := leb128Writer{}
+		s.writeU32(uint32(len(m.funcs)))
+		for _, f := range m.funcs {
+			s.writeU32(f.typeIdx)
+		}
+		w.writeSection(FunctionSection, s.bytes)
+	}
+
+	if len(m.mems) > 0 {
+		s := leb128Writer{}
+		s.writeU32(uint32(len(m.mems)))
+		for _, mem := range m.mems {
+			s.writeLimits(mem.limits, mem.shared, mem.is64)
+		}
+		w.writeSection(MemorySection, s.bytes)
+	}
+
+	if len(m.exports) > 0 {
+		s := leb128Writer{}
+		s.writeU32(uint32(len(m.exports)))
+		for _, export := range m.exports {
+			s.writeU32(uint32(len(export.name)))
+			s.writeString(export.name)
+			s.writeU8(uint8(export.kind))
+			s.writeU32(export.idx)
+		}
+		w.writeSection(ExportSection, s.bytes)
+	}
+
+	if len(m.funcs) > 0 {
+		s := leb128Writer{}
+		s.writeU32(uint32(len(m.funcs)))
+		for i, f := range m.funcs {
+			if f.code == nil {
+				return nil, fmt.Errorf("func %d has no code to encode", i)
+			}
+			c := leb128Writer{}
+			c.writeU32(uint32(len(f.locals)))
+			for _, l := range f.locals {
+				c.writeU32(l.count)
+				c.writeU8(uint8(l.valType))
+			}
+			c.writeBytes(f.code)
+			s.writeU32(uint32(len(c.bytes)))
+			s.writeBytes(c.bytes)
+		}
+		w.writeSection(CodeSection, s.bytes)
+	}
+	return w.bytes, nil
+}
+
+func (w *leb128Writer) writeSection(id SectionID, content []byte) {
+	w.writeU8(uint8(id))
+	w.writeU32(uint32(len(content)))
+	w.writeBytes(content)
+}
+
+// https://webassembly.github.io/spec/core/binary/types.html#limits
+// the u64 limits of memory64 encode the same as u32 ones below 2^32
+func (w *leb128Writer) writeLimits(l limits, shared, is64 bool) {
+	flags := uint8(0x00)
+	if l.Max >= 0 {
+		flags |= 0x01
+	}
+	if shared {
+		flags |= 0x02
+	}
+	if is64 {
+		flags |= 0x04
+	}
+	w.writeU8(flags)
+	w.writeU32(l.Min)
+	if l.Max >= 0 {
+		w.writeU32(uint32(l.Max))
+	}
+}
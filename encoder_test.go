@@ -0,0 +1,63 @@
+package wasm_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(memory 1 2)
+			(func (export "add") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.add
+			)
+			(func (export "noop")
+				(local i32 i32 i64)
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	encoded, err := Encode(wasm)
+	assert.NoError(t, err)
+
+	p := newParser(wasm)
+	m, err := p.parse()
+	assert.NoError(t, err)
+	p = newParser(encoded)
+	encodedM, err := p.parse()
+	assert.NoError(t, err)
+
+	assert.Equal(t, m.types, encodedM.types)
+	assert.Equal(t, m.mems, encodedM.mems)
+	assert.Equal(t, m.exports, encodedM.exports)
+	if assert.Len(t, encodedM.funcs, len(m.funcs)) {
+		for i := range m.funcs {
+			assert.Equal(t, m.funcs[i].typeIdx, encodedM.funcs[i].typeIdx)
+			assert.Equal(t, m.funcs[i].locals, encodedM.funcs[i].locals)
+			assert.Equal(t, m.funcs[i].code, encodedM.funcs[i].code)
+			assert.Len(t, encodedM.funcs[i].body, len(m.funcs[i].body))
+		}
+	}
+
+	text, err := Disassemble(wasm)
+	assert.NoError(t, err)
+	encodedText, err := Disassemble(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, text, encodedText)
+
+	i, err := NewInterpreter(encoded)
+	assert.NoError(t, err)
+	ret, err := invokeExport(t, &i, "add", ValueFromI32(1), ValueFromI32(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+}
+
+func TestEncodeUnsupportedSection(t *testing.T) {
+	m := module{globals: []global{{}}}
+	_, err := m.encode()
+	assert.EqualError(t, err, "encoding the global section is not supported")
+}
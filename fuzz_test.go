@@ -0,0 +1,88 @@
+package wasm_go
+
+import (
+	"testing"
+)
+
+// FuzzParse checks malformed modules are rejected with an error rather than a panic,
+// by the parser or by validate, crashing inputs are kept in testdata/fuzz/FuzzParse.
+func FuzzParse(f *testing.F) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	f.Add(header)
+	for _, wat := range []string{
+		`(module
+			(memory (export "memory") 1 2)
+			(func $add (export "add") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.add
+			)
+			(func (export "loop") (param i64) (result i64)
+				(local i64)
+				block
+					loop
+						local.get 0
+						i64.eqz
+						br_if 1
+						local.get 0
+						i64.const -1
+						i64.add
+						local.set 0
+						br 0
+					end
+				end
+				local.get 1
+			)
+			(func (param i32) (result i32)
+				local.get 0
+				i32.load offset=4
+				local.get 0
+				call $add
+				block (param i32) (result i32)
+				end
+				br_table 0 0
+			)
+		)`,
+	} {
+		wasm, err := wat2wasm(wat)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(wasm)
+	}
+	// crafted malformed inputs
+	f.Add(header[:6])
+	f.Add(append(append([]byte{}, header...), byte(TypeSection), 0x05, 0xff, 0xff, 0xff, 0xff, 0x0f))
+	f.Add(append(append([]byte{}, header...), byte(CodeSection), 0xff, 0xff, 0xff, 0xff, 0x0f))
+	f.Add(append(append([]byte{}, header...), byte(CustomSection), 0x01, 0x80))
+	f.Add(append(append([]byte{}, header...), byte(TypeSection), 0x06, 0x01, 0x60, 0x80, 0x80, 0x80, 0x80))
+
+	// opcodes the parser doesn't decode, 0x1c is the typed select
+	for _, op := range []byte{0x06, 0x1c} {
+		b := NewModuleBuilder().AddFunc(nil, nil, []byte{byte(OpcodeI32Const), 0, op, byte(OpcodeDrop)})
+		wasm, err := b.m.encode()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(wasm)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := newParser(data)
+		m, err := p.parse()
+		if err != nil {
+			return
+		}
+		if err := m.validate(); err != nil {
+			return
+		}
+		// the interpreter runs valid modules as they are, every instr must be decoded
+		for i, fn := range m.funcs {
+			for pc, instr := range fn.body {
+				if instr == nil {
+					t.Fatalf("func %d: instr %d: valid module with an undecoded instr", i, pc)
+				}
+			}
+		}
+	})
+}
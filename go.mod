@@ -2,10 +2,7 @@ module wasm_go
 
 go 1.20
 
-require (
-	github.com/bytecodealliance/wasmtime-go/v9 v9.0.0
-	github.com/stretchr/testify v1.8.0
-)
+require github.com/stretchr/testify v1.8.0
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
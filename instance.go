@@ -1,13 +1,12 @@
 package wasm_go
 
 import (
-	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"math"
 )
 
-var errOutOfBounds = errors.New("out of bounds memory access")
+var errOutOfBounds = newTrap(TrapCodeOutOfBoundsMemory, "out of bounds memory access")
 
 const DEFAULT_MEM_ADDR_IDX = 0
 
@@ -46,8 +45,21 @@ type internalFuncInst struct {
 	code   function
 }
 
+// HostFunc is a Go function that can be imported by a wasm module.
+type HostFunc func(args []Value) ([]Value, error)
+
+// hostMemFunc is a host function that also gets the default memory of the module importing it.
+type hostMemFunc func(mem []byte, args []Value) ([]Value, error)
+
 type externalFuncInst struct {
-	// TODO:
+	module string
+	name   string
+	// nil until the host registers the function
+	fn HostFunc
+	// set instead of fn for host functions defined with Linker.defineMemFunc
+	memFn hostMemFunc
+	// the module instance importing the function
+	importer *moduleInst
 }
 
 // https://webassembly.github.io/spec/core/exec/runtime.html#table-instances
@@ -56,6 +68,9 @@ type tableInst struct {
 	elems []ref
 }
 
+// tables without a max grow up to this many elements, the limit browsers apply
+const maxTableElems = 10_000_000
+
 const PAGE_SIZE int = 65536
 
 type memInst struct {
@@ -71,79 +86,112 @@ func (m *memInst) pages() int {
 	return int(m.size() / PAGE_SIZE)
 }
 
+// a 32 bit address space holds at most 65536 pages
+const maxPages = 65536
+
+// newMemInst allocates the initial pages of a memory after checking its limits,
+// i is only used in the error messages.
+// https://webassembly.github.io/spec/core/valid/types.html#memory-types
+func newMemInst(i int, t memType) (memInst, error) {
+	if t.limits.Max >= 0 && t.limits.Min > uint32(t.limits.Max) {
+		return memInst{}, fmt.Errorf("memory %d: min %d exceeds max %d", i, t.limits.Min, t.limits.Max)
+	}
+	if t.limits.Min > maxPages || t.limits.Max > maxPages {
+		return memInst{}, fmt.Errorf("memory %d: size exceeds %d pages", i, maxPages)
+	}
+	return memInst{memType: t, data: make([]byte, int(t.limits.Min)*PAGE_SIZE)}, nil
+}
+
 func (m *memInst) grow(n int) error {
 	toPages := m.pages() + n
+	if n < 0 || toPages > maxPages {
+		return fmt.Errorf("memory page is overflow. max is %d, grow size is %d", maxPages, toPages)
+	}
 	if m.memType.limits.Max >= 0 && toPages > int(m.memType.limits.Max) {
-		return fmt.Errorf("memory page is overflow. max is %d, grow size is %d", toPages, m.memType.limits.Max)
+		return fmt.Errorf("memory page is overflow. max is %d, grow size is %d", m.memType.limits.Max, toPages)
 	}
-	data := make([]byte, toPages*PAGE_SIZE)
-	copy(data, m.data)
-	m.data = data
+	// append reuses spare capacity and grows it geometrically, so growing page by page
+	// doesn't copy the whole memory every time. The new pages are copied from a zeroed
+	// slice, whatever the spare capacity held before is overwritten.
+	m.data = append(m.data, make([]byte, n*PAGE_SIZE)...)
 	return nil
 }
 
-func (m *memInst) load8(addr, align int32) (uint8, error) {
-	if addr < 0 || addr+1 > int32(len(m.data)) {
+func (m *memInst) load8(addr uint64, align int32) (uint8, error) {
+	if addr+1 > uint64(len(m.data)) {
 		return 0, errOutOfBounds
 	}
-	var v uint8
-	err := binary.Read(bytes.NewBuffer(m.data[addr:]), binary.LittleEndian, &v)
-	return v, err
+	return m.data[addr], nil
 }
 
-func (m *memInst) load16(addr, align int32) (uint16, error) {
-	if addr < 0 || addr+2 > int32(len(m.data)) {
+func (m *memInst) load16(addr uint64, align int32) (uint16, error) {
+	if addr+2 > uint64(len(m.data)) {
 		return 0, errOutOfBounds
 	}
-	var v uint16
-	err := binary.Read(bytes.NewBuffer(m.data[addr:]), binary.LittleEndian, &v)
-	return v, err
+	return binary.LittleEndian.Uint16(m.data[addr:]), nil
 }
 
-func (m *memInst) load32(addr, align int32) (uint32, error) {
-	if addr < 0 || addr+4 > int32(len(m.data)) {
+func (m *memInst) load32(addr uint64, align int32) (uint32, error) {
+	if addr+4 > uint64(len(m.data)) {
 		return 0, errOutOfBounds
 	}
-	var v uint32
-	err := binary.Read(bytes.NewBuffer(m.data[addr:]), binary.LittleEndian, &v)
-	return v, err
+	return binary.LittleEndian.Uint32(m.data[addr:]), nil
 }
 
-func (m *memInst) load64(addr, align int32) (uint64, error) {
-	if addr < 0 || addr+8 > int32(len(m.data)) {
+func (m *memInst) load64(addr uint64, align int32) (uint64, error) {
+	if addr+8 > uint64(len(m.data)) {
 		return 0, errOutOfBounds
 	}
-	var v uint64
-	err := binary.Read(bytes.NewBuffer(m.data[addr:]), binary.LittleEndian, &v)
-	return v, err
+	return binary.LittleEndian.Uint64(m.data[addr:]), nil
+}
+
+func (m *memInst) load128(addr uint64, align int32) (lo, hi uint64, err error) {
+	if addr+16 > uint64(len(m.data)) {
+		return 0, 0, errOutOfBounds
+	}
+	return binary.LittleEndian.Uint64(m.data[addr:]), binary.LittleEndian.Uint64(m.data[addr+8:]), nil
 }
 
-func (m *memInst) store8(addr, align int32, v uint8) error {
-	if addr < 0 || addr+1 > int32(len(m.data)) {
+func (m *memInst) store8(addr uint64, align int32, v uint8) error {
+	if addr+1 > uint64(len(m.data)) {
 		return errOutOfBounds
 	}
-	return binary.Write(bytes.NewBuffer(m.data[addr:]), binary.LittleEndian, v)
+	m.data[addr] = v
+	return nil
+}
+
+func (m *memInst) store16(addr uint64, align int32, v uint16) error {
+	if addr+2 > uint64(len(m.data)) {
+		return errOutOfBounds
+	}
+	binary.LittleEndian.PutUint16(m.data[addr:], v)
+	return nil
 }
 
-func (m *memInst) store16(addr, align int32, v uint16) error {
-	if addr < 0 || addr+2 > int32(len(m.data)) {
+func (m *memInst) store32(addr uint64, align int32, v uint32) error {
+	if addr+4 > uint64(len(m.data)) {
 		return errOutOfBounds
 	}
-	return binary.Write(bytes.NewBuffer(m.data[addr:]), binary.LittleEndian, v)
+	binary.LittleEndian.PutUint32(m.data[addr:], v)
+	return nil
 }
 
-func (m *memInst) store32(addr, align int32, v uint32) error {
-	if addr < 0 || addr+4 > int32(len(m.data)) {
+func (m *memInst) store64(addr uint64, align int32, v uint64) error {
+	if addr+8 > uint64(len(m.data)) {
 		return errOutOfBounds
 	}
-	return binary.Write(bytes.NewBuffer(m.data[addr:]), binary.LittleEndian, v)
+	binary.LittleEndian.PutUint64(m.data[addr:], v)
+	return nil
 }
 
-func (m *memInst) store64(addr, align int32, v uint64) error {
-	if addr < 0 || addr+8 > int32(len(m.data)) {
+// store128 checks the whole access before writing, a v128 is never partially stored.
+func (m *memInst) store128(addr uint64, align int32, lo, hi uint64) error {
+	if addr+16 > uint64(len(m.data)) {
 		return errOutOfBounds
 	}
-	return binary.Write(bytes.NewBuffer(m.data[addr:]), binary.LittleEndian, v)
+	binary.LittleEndian.PutUint64(m.data[addr:], lo)
+	binary.LittleEndian.PutUint64(m.data[addr+8:], hi)
+	return nil
 }
 
 type globalInst struct {
@@ -167,77 +215,125 @@ type exportInst struct {
 	value externalVal
 }
 
+// Value holds the raw bits of a number, 32-bit values use the low half.
+// A v128 keeps its low 64 bits in raw and its high 64 bits in hi.
 type Value struct {
 	ValType type_
-	data    []byte
+	raw     uint64
+	hi      uint64
 }
 
+// ValueFrom returns a value of type t holding the bits of v. An int32 is sign extended
+// for an i64, and a float of the other width is converted to the width of t.
 func ValueFrom(v any, t type_) Value {
-	buffer := new(bytes.Buffer)
-	binary.Write(buffer, binary.LittleEndian, v)
-	return Value{
-		ValType: t,
-		data:    buffer.Bytes(),
+	switch v := v.(type) {
+	case int32:
+		if t == I64 {
+			return Value{ValType: t, raw: uint64(int64(v))}
+		}
+		return Value{ValType: t, raw: uint64(uint32(v))}
+	case uint32:
+		return Value{ValType: t, raw: uint64(v)}
+	case int64:
+		return Value{ValType: t, raw: uint64(v)}
+	case uint64:
+		return Value{ValType: t, raw: v}
+	case float32:
+		if t == F64 {
+			return Value{ValType: t, raw: math.Float64bits(float64(v))}
+		}
+		return Value{ValType: t, raw: uint64(math.Float32bits(v))}
+	case float64:
+		if t == F32 {
+			return Value{ValType: t, raw: uint64(math.Float32bits(float32(v)))}
+		}
+		return Value{ValType: t, raw: math.Float64bits(v)}
 	}
+	panic(fmt.Sprintf("unsupported value %T", v))
 }
 
 func ValueFromI32(v int32) Value {
-	buffer := new(bytes.Buffer)
-	binary.Write(buffer, binary.LittleEndian, v)
-	return Value{
-		ValType: I32,
-		data:    buffer.Bytes(),
-	}
+	return Value{ValType: I32, raw: uint64(uint32(v))}
 }
 
 func ValueFromI64(v int64) Value {
-	buffer := new(bytes.Buffer)
-	binary.Write(buffer, binary.LittleEndian, v)
-	return Value{
-		ValType: I64,
-		data:    buffer.Bytes(),
-	}
+	return Value{ValType: I64, raw: uint64(v)}
 }
 
 func ValueFromF32(v float32) Value {
-	buffer := new(bytes.Buffer)
-	binary.Write(buffer, binary.LittleEndian, v)
-	return Value{
-		ValType: F32,
-		data:    buffer.Bytes(),
-	}
+	return Value{ValType: F32, raw: uint64(math.Float32bits(v))}
 }
 
 func ValueFromF64(v float64) Value {
-	buffer := new(bytes.Buffer)
-	binary.Write(buffer, binary.LittleEndian, v)
-	return Value{
-		ValType: F64,
-		data:    buffer.Bytes(),
-	}
+	return Value{ValType: F64, raw: math.Float64bits(v)}
+}
+
+// ValueFromV128 takes the 16 bytes of a v128 in little endian order, lane 0 first.
+func ValueFromV128(b [16]byte) Value {
+	return Value{ValType: V128, raw: binary.LittleEndian.Uint64(b[:8]), hi: binary.LittleEndian.Uint64(b[8:])}
+}
+
+func zeroValue(t type_) Value {
+	return Value{ValType: t}
 }
 
 func (v *Value) F32() float32 {
-	var f float32
-	binary.Read(bytes.NewReader(v.data), binary.LittleEndian, &f)
-	return f
+	return math.Float32frombits(uint32(v.raw))
 }
 
 func (v *Value) F64() float64 {
-	var u float64
-	binary.Read(bytes.NewReader(v.data), binary.LittleEndian, &u)
-	return u
+	return math.Float64frombits(v.raw)
 }
 
 func (v *Value) I32() int32 {
-	var i int32
-	binary.Read(bytes.NewReader(v.data), binary.LittleEndian, &i)
-	return i
+	return int32(uint32(v.raw))
 }
+
 func (v *Value) I64() int64 {
-	var i int64
-	binary.Read(bytes.NewReader(v.data), binary.LittleEndian, &i)
-	return i
+	return int64(v.raw)
+}
+
+func (v *Value) V128() [16]byte {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], v.raw)
+	binary.LittleEndian.PutUint64(b[8:], v.hi)
+	return b
+}
+
+// refs keep addr+1 in raw, so the zero Value of a ref type is null
+func valueFromRef(t type_, r ref) Value {
+	v := Value{ValType: t}
+	if !r.isNull() {
+		v.raw = uint64(r.addr) + 1
+	}
+	return v
+}
+
+func (v *Value) ref() ref {
+	if v.raw == 0 {
+		return ref{kind: refNull}
+	}
+	kind := refFunc
+	if v.ValType == ExternRef {
+		kind = refExtern
+	}
+	return ref{addr: int(v.raw - 1), kind: kind}
+}
+
+// Equal reports whether v and other have the same type and value.
+// Floats compare by their bits, so a NaN equals a NaN with the same bits
+// and 0 differs from -0, which is what comparing wasm results needs.
+func (v Value) Equal(other Value) bool {
+	if v.ValType != other.ValType {
+		return false
+	}
+	switch v.ValType {
+	case I32, F32:
+		return uint32(v.raw) == uint32(other.raw)
+	case V128:
+		return v.raw == other.raw && v.hi == other.hi
+	}
+	return v.raw == other.raw
 }
 
 func (v *Value) Bool() bool {
@@ -262,8 +358,18 @@ type ref struct {
 	kind refKind
 }
 
+// address 0 is a valid func address, so only the kind tells a null ref apart
 func (r *ref) isNull() bool {
-	return r.addr == 0
+	return r.kind == refNull
+}
+
+// nullRefs returns n null references, used for fresh table slots.
+func nullRefs(n int) []ref {
+	refs := make([]ref, n)
+	for i := range refs {
+		refs[i] = ref{kind: refNull}
+	}
+	return refs
 }
 
 type externalVal struct {
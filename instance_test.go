@@ -0,0 +1,61 @@
+package wasm_go
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueEqual(t *testing.T) {
+	lowHalf := ValueFrom(int64(-1), I32)
+	nan := math.Float32frombits(0x7fc00000)
+	otherNaN := math.Float32frombits(0x7fc00001)
+	cases := []struct {
+		a, b  Value
+		equal bool
+	}{
+		{ValueFromI32(1), ValueFromI32(1), true},
+		{ValueFromI32(1), ValueFromI32(2), false},
+		{ValueFromI32(-1), ValueFromI64(-1), false},
+		// same bits, different types
+		{ValueFromI32(int32(math.Float32bits(1.5))), ValueFromF32(1.5), false},
+		{ValueFromI64(int64(math.Float64bits(1.5))), ValueFromF64(1.5), false},
+		{ValueFromF64(1.5), ValueFromF64(1.5), true},
+		{ValueFromF32(0), ValueFromF32(float32(math.Copysign(0, -1))), false},
+		{ValueFromF32(nan), ValueFromF32(nan), true},
+		{ValueFromF32(nan), ValueFromF32(otherNaN), false},
+		{ValueFromF64(math.NaN()), ValueFromF64(math.NaN()), true},
+		// only the low half of a 32-bit value counts
+		{lowHalf, ValueFromI32(-1), true},
+		{zeroValue(FuncRef), zeroValue(FuncRef), true},
+		{zeroValue(FuncRef), zeroValue(ExternRef), false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.equal, c.a.Equal(c.b), "%v %v", c.a, c.b)
+		assert.Equal(t, c.equal, c.b.Equal(c.a), "%v %v", c.b, c.a)
+	}
+}
+
+func TestValueFrom(t *testing.T) {
+	cases := []struct {
+		v        any
+		t        type_
+		expected Value
+	}{
+		{int32(-1), I32, ValueFromI32(-1)},
+		{int32(-1), I64, ValueFromI64(-1)},
+		{uint32(0x3fc00000), F32, ValueFromF32(1.5)},
+		{int64(-1), I64, ValueFromI64(-1)},
+		{math.Float64bits(1.5), F64, ValueFromF64(1.5)},
+		{float32(1.5), F32, ValueFromF32(1.5)},
+		{1.5, F64, ValueFromF64(1.5)},
+		// floats of the other width are converted
+		{1.5, F32, ValueFromF32(1.5)},
+		{float32(1.5), F64, ValueFromF64(1.5)},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, ValueFrom(c.v, c.t), "%T %s", c.v, typeName(c.t))
+	}
+	assert.Panics(t, func() { ValueFrom("1", I32) })
+}
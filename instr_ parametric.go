@@ -4,14 +4,16 @@ type opSelect struct{}
 
 func (o *opSelect) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
+	// https://webassembly.github.io/spec/core/exec/instructions.html#exec-select
+	// val1 is pushed first, so it comes off the stack last
 	c, _ := valueStack.Pop()
-	v1, _ := valueStack.Pop()
-	v2, _ := valueStack.Pop()
+	val2, _ := valueStack.Pop()
+	val1, _ := valueStack.Pop()
 
-	if c.I32() == 0 {
-		valueStack.Push(v1)
+	if c.I32() != 0 {
+		valueStack.Push(val1)
 	} else {
-		valueStack.Push(v2)
+		valueStack.Push(val2)
 	}
 
 	frame.NextStep()
@@ -0,0 +1,34 @@
+package wasm_go
+
+var errUnalignedAtomic = newTrap(TrapCodeUnalignedAtomic, "unaligned atomic")
+
+// https://github.com/WebAssembly/threads/blob/main/proposals/threads/Overview.md#atomic-memory-accesses
+// The interpreter runs a single thread, so atomic accesses are plain accesses,
+// except that their effective address must be a multiple of the access size.
+type opAtomicLoad struct {
+	opLoad
+	size uint64
+}
+
+func (o *opAtomicLoad) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	baseAddr, _ := valueStack.Top()
+	// an address out of bounds is reported by the load
+	if addr, ok := effectiveAddr(*baseAddr, o.offset); ok && addr%o.size != 0 {
+		return errUnalignedAtomic
+	}
+	return o.opLoad.exec(frameStack, valueStack, store)
+}
+
+type opAtomicStore struct {
+	opStore
+	size uint64
+}
+
+func (o *opAtomicStore) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	// the value to store is on top of the address
+	baseAddr, _ := valueStack.Peek(1)
+	if addr, ok := effectiveAddr(*baseAddr, o.offset); ok && addr%o.size != 0 {
+		return errUnalignedAtomic
+	}
+	return o.opStore.exec(frameStack, valueStack, store)
+}
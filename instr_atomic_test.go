@@ -0,0 +1,54 @@
+package wasm_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAtomicLoadStore(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(func (export "i32") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.atomic.store
+				local.get 0
+				i32.atomic.load
+			)
+			(func (export "i64") (param i32 i64) (result i64)
+				local.get 0
+				local.get 1
+				i64.atomic.store offset=8
+				local.get 0
+				i64.atomic.load offset=8
+			)
+			(func (export "load_i64") (param i32) (result i64)
+				local.get 0
+				i64.atomic.load
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "i32", ValueFromI32(4), ValueFromI32(-7))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(-7)}, ret)
+	ret, err = invokeExport(t, &i, "i64", ValueFromI32(16), ValueFromI64(1<<40))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(1 << 40)}, ret)
+
+	_, err = invokeExport(t, &i, "i32", ValueFromI32(2), ValueFromI32(1))
+	assert.EqualError(t, err, "unaligned atomic")
+	// the offset counts towards the alignment
+	_, err = invokeExport(t, &i, "i64", ValueFromI32(4), ValueFromI64(1))
+	assert.ErrorIs(t, err, errUnalignedAtomic)
+	_, err = invokeExport(t, &i, "load_i64", ValueFromI32(12))
+	assert.ErrorIs(t, err, errUnalignedAtomic)
+	// bounds are still checked for aligned accesses
+	_, err = invokeExport(t, &i, "load_i64", ValueFromI32(65536))
+	assert.ErrorIs(t, err, errOutOfBounds)
+
+	mem, err := i.ReadMemory(4, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xf9, 0xff, 0xff, 0xff}, mem)
+}
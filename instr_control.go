@@ -2,6 +2,14 @@ package wasm_go
 
 import "fmt"
 
+var (
+	errUndefinedElement         = newTrap(TrapCodeUndefinedElement, "undefined element")
+	errUninitializedElement     = newTrap(TrapCodeUninitializedElement, "uninitialized element")
+	errIndirectCallTypeMismatch = newTrap(TrapCodeIndirectCallTypeMismatch, "indirect call type mismatch")
+	errCallStackExhausted       = newTrap(TrapCodeStackOverflow, "call stack exhausted")
+	errUnreachable              = newTrap(TrapCodeUnreachable, "unreachable")
+)
+
 type labelKind uint8
 
 const (
@@ -14,12 +22,18 @@ type label struct {
 	kind    labelKind
 	startPc int
 	endPc   int
+	// value stack height when entering the block, not counting its params
+	height int
+	// number of block results
+	arity int
+	// number of block params, a branch to a loop carries these
+	params int
 }
 
 type opUnreachable struct{}
 
 func (o *opUnreachable) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
-	return fmt.Errorf("unreachable")
+	return errUnreachable
 }
 
 type opNop struct{}
@@ -32,65 +46,70 @@ func (o *opNop) exec(frameStack *stack[frame], valueStack *stack[Value], store *
 
 type opIf struct {
 	block block
+	// address of the else instr, or of the end instr when there is no else
+	elsePc int
+	endPc  int
 }
 
 func (o *opIf) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	cond, _ := valueStack.Pop()
 	frame, _ := frameStack.Top()
+	startPc := frame.pc
 
-	nextPc, err := nextEndAddr(frame.pc+1, frame.insts)
-	if err != nil {
-		return err
-	}
-
-	if !cond.Bool() {
-		// condition is false, skip the if block
-		addr, err := nextElseOrEndAddr(frame.pc+1, frame.insts)
-		if err != nil {
-			return err
-		}
-		frame.pc = addr
+	if cond.Bool() {
+		// run the then block, the else instr jumps over the else block
+		frame.NextStep()
+	} else if o.elsePc < o.endPc {
+		// condition is false, run the else block
+		frame.pc = o.elsePc + 1
+	} else {
+		// no else block, the end instr pops the label
+		frame.pc = o.endPc
 	}
 	frame.labels.Push(label{
 		kind:    LabelKindIf,
-		startPc: frame.pc,
-		endPc:   nextPc,
+		startPc: startPc,
+		endPc:   o.endPc,
+		height:  valueStack.Len() - o.block.paramArity(),
+		arity:   o.block.resultArity(),
+		params:  o.block.paramArity(),
 	})
 	return nil
 }
 
 type opLoop struct {
 	block block
+	endPc int
 }
 
 func (o *opLoop) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
-	nextPc, err := nextEndAddr(frame.pc+1, frame.insts)
-	if err != nil {
-		return err
-	}
 	frame.labels.Push(label{
 		kind:    LabelKindLoop,
 		startPc: frame.pc,
-		endPc:   nextPc,
+		endPc:   o.endPc,
+		height:  valueStack.Len() - o.block.paramArity(),
+		arity:   o.block.resultArity(),
+		params:  o.block.paramArity(),
 	})
+	frame.NextStep()
 	return nil
 }
 
 type opBlock struct {
 	block block
+	endPc int
 }
 
 func (o *opBlock) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
-	nextPc, err := nextEndAddr(frame.pc+1, frame.insts)
-	if err != nil {
-		return err
-	}
 	frame.labels.Push(label{
 		kind:    LabelKindBlock,
 		startPc: frame.pc,
-		endPc:   nextPc,
+		endPc:   o.endPc,
+		height:  valueStack.Len() - o.block.paramArity(),
+		arity:   o.block.resultArity(),
+		params:  o.block.paramArity(),
 	})
 	frame.NextStep()
 	return nil
@@ -104,6 +123,7 @@ func (o *opElse) exec(frameStack *stack[frame], valueStack *stack[Value], store
 	if !ok {
 		return fmt.Errorf("no label found when else instr")
 	}
+	valueStack.Unwind(label.height, label.arity)
 	frame.pc = label.endPc + 1
 	return nil
 }
@@ -114,13 +134,14 @@ func (o *opEnd) exec(frameStack *stack[frame], valueStack *stack[Value], store *
 	frame, _ := frameStack.Top()
 	label, ok := frame.labels.Pop()
 	if !ok {
-		// end func
+		// end func, leave the results on top of the caller's operands
+		valueStack.Unwind(frame.sp, frame.arity)
 		frameStack.Pop()
 	} else {
-		// end label
+		// end label, only the block results are kept
+		valueStack.Unwind(label.height, label.arity)
 		frame.pc = label.endPc + 1
 	}
-	// TODO: restore stack
 	return nil
 }
 
@@ -131,7 +152,7 @@ type opBr struct {
 func (o *opBr) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
 	var err error
-	frame.pc, err = br(&frame.labels, valueStack, int(o.level))
+	frame.pc, err = br(frame, valueStack, int(o.level))
 	return err
 }
 
@@ -145,7 +166,7 @@ func (o *opBrIf) exec(frameStack *stack[frame], valueStack *stack[Value], store
 
 	if cond.Bool() {
 		var err error
-		frame.pc, err = br(&frame.labels, valueStack, int(o.level))
+		frame.pc, err = br(frame, valueStack, int(o.level))
 		return err
 	}
 	frame.NextStep()
@@ -160,52 +181,258 @@ type opBrTable struct {
 func (o *opBrTable) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	idxValue, _ := valueStack.Pop()
 	frame, _ := frameStack.Top()
-	idx := int(idxValue.I32())
+	idx := uint32(idxValue.I32())
 
 	level := o.defaultIdx
-	if idx < len(o.labelIdxArr) {
+	if idx < uint32(len(o.labelIdxArr)) {
 		level = o.labelIdxArr[idx]
 	}
 
 	var err error
-	frame.pc, err = br(&frame.labels, valueStack, level)
+	frame.pc, err = br(frame, valueStack, level)
 	return err
 }
 
 type opReturn struct{}
 
 func (o *opReturn) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	// the labels of the current function are discarded along with its frame
+	valueStack.Unwind(frame.sp, frame.arity)
+	frameStack.Pop()
 	return nil
 }
 
-type opCall struct{}
+type opCall struct {
+	funcIdx uint32
+}
 
 func (o *opCall) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
-	return nil
+	frame, _ := frameStack.Top()
+	fn, err := directFunc(frame, store, o.funcIdx)
+	if err != nil {
+		return err
+	}
+	// the callee returns to the next instruction
+	frame.NextStep()
+	return call(frameStack, valueStack, store, fn)
 }
 
-type opCallIndirect struct{}
+type opCallIndirect struct {
+	typeIdx  uint32
+	tableIdx uint32
+}
 
 func (o *opCallIndirect) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	fn, err := indirectFunc(frame, valueStack, store, o.typeIdx, o.tableIdx)
+	if err != nil {
+		return err
+	}
+	// the callee returns to the next instruction
+	frame.NextStep()
+	return call(frameStack, valueStack, store, fn)
+}
+
+// opReturnCall is a call that replaces the frame of the caller, so tail recursion runs in constant space.
+type opReturnCall struct {
+	funcIdx uint32
+}
+
+func (o *opReturnCall) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	fn, err := directFunc(frame, store, o.funcIdx)
+	if err != nil {
+		return err
+	}
+	return returnCall(frameStack, valueStack, store, fn)
+}
+
+type opReturnCallIndirect struct {
+	typeIdx  uint32
+	tableIdx uint32
+}
+
+func (o *opReturnCallIndirect) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	fn, err := indirectFunc(frame, valueStack, store, o.typeIdx, o.tableIdx)
+	if err != nil {
+		return err
+	}
+	return returnCall(frameStack, valueStack, store, fn)
+}
+
+func directFunc(frame *frame, store *store, funcIdx uint32) (*funcInst, error) {
+	if int(funcIdx) >= len(frame.mod.funcAddrs) {
+		return nil, fmt.Errorf("unknown function %d", funcIdx)
+	}
+	return &store.funcs[frame.mod.funcAddrs[funcIdx]], nil
+}
+
+// indirectFunc pops the element index and returns the func it refers to in the table.
+func indirectFunc(frame *frame, valueStack *stack[Value], store *store, typeIdx, tableIdx uint32) (*funcInst, error) {
+	elemIdx, _ := valueStack.Pop()
+	table := &store.tables[frame.mod.tableAddrs[tableIdx]]
+	idx := uint32(elemIdx.I32())
+	if idx >= uint32(len(table.elems)) {
+		return nil, errUndefinedElement
+	}
+	ref := table.elems[idx]
+	if ref.isNull() {
+		return nil, errUninitializedElement
+	}
+	// externrefs hold host values, not func addrs
+	if ref.kind != refFunc {
+		return nil, errIndirectCallTypeMismatch
+	}
+	fn := &store.funcs[ref.addr]
+	if !fn.funcType.equal(frame.mod.signatures[typeIdx]) {
+		return nil, errIndirectCallTypeMismatch
+	}
+	return fn, nil
+}
+
+// returnCall drops the frame on top along with its locals and operands, only the
+// arguments of fn are kept, then calls fn. Its results go straight to the caller's caller.
+func returnCall(frameStack *stack[frame], valueStack *stack[Value], store *store, fn *funcInst) error {
+	frame, _ := frameStack.Top()
+	params := len(fn.funcType.params)
+	if valueStack.Len()-frame.sp < params {
+		return fmt.Errorf("call expects %d arguments, got %d", params, valueStack.Len()-frame.sp)
+	}
+	valueStack.Unwind(frame.sp, params)
+	frameStack.Pop()
+	return call(frameStack, valueStack, store, fn)
+}
+
+// call pushes a new frame for fn, the arguments on top of valueStack become the first locals of the callee.
+func call(frameStack *stack[frame], valueStack *stack[Value], store *store, fn *funcInst) error {
+	params := fn.funcType.params
+	if valueStack.Len() < len(params) {
+		return fmt.Errorf("call expects %d arguments, got %d", len(params), valueStack.Len())
+	}
+	sp := valueStack.Len() - len(params)
+	for x, param := range params {
+		arg, _ := valueStack.Get(sp, x)
+		if arg.ValType != param {
+			return fmt.Errorf("argument %d type mismatch", x)
+		}
+	}
+	if fn.kind == externalFunc {
+		return callHost(valueStack, store, fn, sp)
+	}
+	if frameStack.Len() >= store.maxCallDepth {
+		return errCallStackExhausted
+	}
+	// declared locals follow the arguments
+	for _, l := range fn.internalFunc.code.locals {
+		for x := uint32(0); x < l.count; x++ {
+			valueStack.Push(zeroValue(l.valType))
+		}
+	}
+	frameStack.Push(frame{
+		pc:     0,
+		sp:     sp,
+		arity:  len(fn.funcType.results),
+		locals: valueStack.Len() - sp,
+		insts:  fn.internalFunc.code.body,
+		ops:    fn.internalFunc.code.ops,
+		mod:    fn.internalFunc.module,
+	})
+	return nil
+}
+
+// callHost runs an imported Go function, the arguments from sp are replaced by its results.
+func callHost(valueStack *stack[Value], store *store, fn *funcInst, sp int) error {
+	host := fn.externalFunc
+	if host.fn == nil && host.memFn == nil {
+		return fmt.Errorf("imported func %s.%s is not registered", host.module, host.name)
+	}
+	args := make([]Value, valueStack.Len()-sp)
+	for x := range args {
+		arg, _ := valueStack.Get(sp, x)
+		args[x] = *arg
+	}
+	valueStack.Unwind(sp, 0)
+
+	var results []Value
+	var err error
+	if host.memFn != nil {
+		if len(host.importer.memAddrs) == 0 {
+			return fmt.Errorf("imported func %s.%s needs a memory", host.module, host.name)
+		}
+		results, err = host.memFn(store.mems[host.importer.defaultMemAddr()].data, args)
+	} else {
+		results, err = host.fn(args)
+	}
+	if err != nil {
+		return err
+	}
+	if len(results) != len(fn.funcType.results) {
+		return fmt.Errorf("imported func %s.%s returned %d results, expected %d", host.module, host.name, len(results), len(fn.funcType.results))
+	}
+	for x, result := range results {
+		if result.ValType != fn.funcType.results[x] {
+			return fmt.Errorf("imported func %s.%s result %d type mismatch", host.module, host.name, x)
+		}
+		valueStack.Push(result)
+	}
 	return nil
 }
 
-func br(labels *stack[label], valueStack *stack[Value], level int) (int, error) {
+func br(frame *frame, valueStack *stack[Value], level int) (int, error) {
+	labels := &frame.labels
+	if level < 0 || level > labels.Len() {
+		return 0, fmt.Errorf("unknown label %d", level)
+	}
+	if level == labels.Len() {
+		// the function body is the outermost label, its end instr returns
+		labels.Unwind(0, 0)
+		return len(frame.insts) - 1, nil
+	}
 	label, ok := labels.Peek(level)
 	if !ok {
-		return 0, fmt.Errorf("no label found level: %d", level)
+		return 0, fmt.Errorf("unknown label %d", level)
 	}
 	var nextPc int
 	if label.kind == LabelKindLoop {
-		// jump start of loop
-		nextPc = label.startPc
+		// continue after the loop instr, its label stays pushed
+		nextPc = label.startPc + 1
+		valueStack.Unwind(label.height, label.params)
 	} else {
+		// the end instr will pop the target label
 		nextPc = label.endPc
+		valueStack.Unwind(label.height, label.arity)
+	}
+	for ; level > 0; level-- {
+		labels.Pop()
 	}
-	// TODO: restore stack
 	return nextPc, nil
 }
 
+// resolveJumpAddrs stores the else and end addresses on every block, loop and if
+// of a function body, so they aren't searched for each time the instr runs.
+func resolveJumpAddrs(insts []instr) error {
+	for pc, instr := range insts {
+		var err error
+		switch o := instr.(type) {
+		case *opBlock:
+			o.endPc, err = nextEndAddr(pc+1, insts)
+		case *opLoop:
+			o.endPc, err = nextEndAddr(pc+1, insts)
+		case *opIf:
+			o.endPc, err = nextEndAddr(pc+1, insts)
+			if err == nil {
+				o.elsePc, err = nextElseOrEndAddr(pc+1, insts)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // nextEndAddr finds the next end address of a block of instructions given the current program counter `pc` and the list of instructions `insts`.
 //
 // pc: The current program counter.
@@ -217,9 +444,7 @@ func nextEndAddr(pc int, insts []instr) (int, error) {
 	for ; pc < len(insts); pc++ {
 		instr := insts[pc]
 		switch instr.(type) {
-		case *opIf:
-		case *opLoop:
-		case *opBlock:
+		case *opIf, *opLoop, *opBlock:
 			depth += 1
 		case *opEnd:
 			if depth == 0 {
@@ -242,7 +467,7 @@ func nextElseOrEndAddr(pc int, insts []instr) (int, error) {
 	for ; pc < len(insts); pc++ {
 		instr := insts[pc]
 		switch instr.(type) {
-		case *opIf:
+		case *opIf, *opLoop, *opBlock:
 			depth += 1
 		case *opElse:
 			if depth == 0 {
@@ -1,9 +1,229 @@
 package wasm_go
 
+import "math"
+
+var errInvalidConversionToInteger = newTrap(TrapCodeInvalidConversion, "invalid conversion to integer")
+
 // wrap ∣ extend ∣ trunc ∣ convert ∣ demote ∣ promote ∣ reinterpret
 type opCut struct {
+	cutFn func(v Value) (Value, error)
 }
 
 func (o *opCut) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	v, _ := valueStack.Pop()
+	ret, err := o.cutFn(v)
+	if err != nil {
+		return err
+	}
+	// only demote and promote can produce a NaN
+	if store.canonicalNaN {
+		ret = canonicalizeNaN(ret)
+	}
+	valueStack.Push(ret)
+	frame, _ := frameStack.Top()
+	frame.NextStep()
+	return nil
+}
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-reinterpret
+// Value keeps the raw bits, so reinterpret only changes the type of the top value.
+type opReinterpret struct {
+	valType type_
+}
+
+func (o *opReinterpret) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	v, _ := valueStack.Top()
+	v.ValType = o.valType
+	frame, _ := frameStack.Top()
+	frame.NextStep()
 	return nil
 }
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-wrap
+func i32WrapI64(v Value) (Value, error) {
+	return ValueFromI32(int32(uint32(v.I64()))), nil
+}
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-extend-s
+func i64ExtendI32S(v Value) (Value, error) {
+	return ValueFromI64(int64(v.I32())), nil
+}
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-extend-u
+func i64ExtendI32U(v Value) (Value, error) {
+	return ValueFromI64(int64(uint32(v.I32()))), nil
+}
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-trunc-u
+// trunc truncates x and checks the result lies in [min, max).
+func trunc(x, min, max float64) (float64, error) {
+	if math.IsNaN(x) {
+		return 0, errInvalidConversionToInteger
+	}
+	t := math.Trunc(x)
+	if t < min || t >= max {
+		return 0, errIntegerOverflow
+	}
+	return t, nil
+}
+
+func i32TruncF32S(v Value) (Value, error) {
+	t, err := trunc(float64(v.F32()), math.MinInt32, -math.MinInt32)
+	return ValueFromI32(int32(t)), err
+}
+
+func i32TruncF32U(v Value) (Value, error) {
+	t, err := trunc(float64(v.F32()), 0, math.MaxUint32+1)
+	return ValueFromI32(int32(uint32(t))), err
+}
+
+func i32TruncF64S(v Value) (Value, error) {
+	t, err := trunc(v.F64(), math.MinInt32, -math.MinInt32)
+	return ValueFromI32(int32(t)), err
+}
+
+func i32TruncF64U(v Value) (Value, error) {
+	t, err := trunc(v.F64(), 0, math.MaxUint32+1)
+	return ValueFromI32(int32(uint32(t))), err
+}
+
+func i64TruncF32S(v Value) (Value, error) {
+	t, err := trunc(float64(v.F32()), math.MinInt64, -math.MinInt64)
+	return ValueFromI64(int64(t)), err
+}
+
+func i64TruncF32U(v Value) (Value, error) {
+	t, err := trunc(float64(v.F32()), 0, math.MaxUint64+1)
+	return ValueFromI64(int64(uint64(t))), err
+}
+
+func i64TruncF64S(v Value) (Value, error) {
+	t, err := trunc(v.F64(), math.MinInt64, -math.MinInt64)
+	return ValueFromI64(int64(t)), err
+}
+
+func i64TruncF64U(v Value) (Value, error) {
+	t, err := trunc(v.F64(), 0, math.MaxUint64+1)
+	return ValueFromI64(int64(uint64(t))), err
+}
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-trunc-sat-u
+// The saturating truncations never trap, NaN becomes 0 and out of range values are clamped.
+func i32TruncSatF32S(v Value) (Value, error) {
+	return ValueFromI32(satI32(float64(v.F32()))), nil
+}
+
+func i32TruncSatF32U(v Value) (Value, error) {
+	return ValueFromI32(int32(satU32(float64(v.F32())))), nil
+}
+
+func i32TruncSatF64S(v Value) (Value, error) {
+	return ValueFromI32(satI32(v.F64())), nil
+}
+
+func i32TruncSatF64U(v Value) (Value, error) {
+	return ValueFromI32(int32(satU32(v.F64()))), nil
+}
+
+func i64TruncSatF32S(v Value) (Value, error) {
+	return ValueFromI64(satI64(float64(v.F32()))), nil
+}
+
+func i64TruncSatF32U(v Value) (Value, error) {
+	return ValueFromI64(int64(satU64(float64(v.F32())))), nil
+}
+
+func i64TruncSatF64S(v Value) (Value, error) {
+	return ValueFromI64(satI64(v.F64())), nil
+}
+
+func i64TruncSatF64U(v Value) (Value, error) {
+	return ValueFromI64(int64(satU64(v.F64()))), nil
+}
+
+func satI32(x float64) int32 {
+	switch {
+	case math.IsNaN(x):
+		return 0
+	case x <= math.MinInt32:
+		return math.MinInt32
+	case x >= -math.MinInt32:
+		return math.MaxInt32
+	}
+	return int32(x)
+}
+
+func satU32(x float64) uint32 {
+	switch {
+	case math.IsNaN(x), x <= 0:
+		return 0
+	case x >= math.MaxUint32+1:
+		return math.MaxUint32
+	}
+	return uint32(x)
+}
+
+func satI64(x float64) int64 {
+	switch {
+	case math.IsNaN(x):
+		return 0
+	case x <= math.MinInt64:
+		return math.MinInt64
+	case x >= -math.MinInt64:
+		return math.MaxInt64
+	}
+	return int64(x)
+}
+
+func satU64(x float64) uint64 {
+	switch {
+	case math.IsNaN(x), x <= 0:
+		return 0
+	case x >= math.MaxUint64+1:
+		return math.MaxUint64
+	}
+	return uint64(x)
+}
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-convert-u
+func f32ConvertI32S(v Value) (Value, error) {
+	return ValueFromF32(float32(v.I32())), nil
+}
+
+func f32ConvertI32U(v Value) (Value, error) {
+	return ValueFromF32(float32(uint32(v.I32()))), nil
+}
+
+func f32ConvertI64S(v Value) (Value, error) {
+	return ValueFromF32(float32(v.I64())), nil
+}
+
+func f32ConvertI64U(v Value) (Value, error) {
+	return ValueFromF32(float32(uint64(v.I64()))), nil
+}
+
+func f64ConvertI32S(v Value) (Value, error) {
+	return ValueFromF64(float64(v.I32())), nil
+}
+
+func f64ConvertI32U(v Value) (Value, error) {
+	return ValueFromF64(float64(uint32(v.I32()))), nil
+}
+
+func f64ConvertI64S(v Value) (Value, error) {
+	return ValueFromF64(float64(v.I64())), nil
+}
+
+func f64ConvertI64U(v Value) (Value, error) {
+	return ValueFromF64(float64(uint64(v.I64()))), nil
+}
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-demote
+func f32DemoteF64(v Value) (Value, error) {
+	return ValueFromF32(float32(v.F64())), nil
+}
+
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-promote
+func f64PromoteF32(v Value) (Value, error) {
+	return ValueFromF64(float64(v.F32())), nil
+}
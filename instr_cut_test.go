@@ -0,0 +1,223 @@
+package wasm_go
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrunc(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "i32.trunc_f32_s") (param f32) (result i32)
+				local.get 0
+				i32.trunc_f32_s
+			)
+			(func (export "i32.trunc_f64_u") (param f64) (result i32)
+				local.get 0
+				i32.trunc_f64_u
+			)
+			(func (export "i64.trunc_f64_s") (param f64) (result i64)
+				local.get 0
+				i64.trunc_f64_s
+			)
+			(func (export "i64.trunc_f32_u") (param f32) (result i64)
+				local.get 0
+				i64.trunc_f32_u
+			)
+		)
+	`)
+	cases := []struct {
+		fn       string
+		arg      Value
+		expected Value
+		err      string
+	}{
+		{"i32.trunc_f32_s", ValueFromF32(-3.9), ValueFromI32(-3), ""},
+		{"i32.trunc_f32_s", ValueFromF32(float32(math.NaN())), Value{}, "invalid conversion to integer"},
+		{"i32.trunc_f32_s", ValueFromF32(2147483648), Value{}, "integer overflow"},
+		{"i32.trunc_f64_u", ValueFromF64(4294967295.5), ValueFromI32(-1), ""},
+		{"i32.trunc_f64_u", ValueFromF64(-0.9), ValueFromI32(0), ""},
+		{"i32.trunc_f64_u", ValueFromF64(-1), Value{}, "integer overflow"},
+		{"i32.trunc_f64_u", ValueFromF64(math.NaN()), Value{}, "invalid conversion to integer"},
+		{"i64.trunc_f64_s", ValueFromF64(-9223372036854775808), ValueFromI64(math.MinInt64), ""},
+		{"i64.trunc_f64_s", ValueFromF64(9223372036854775808), Value{}, "integer overflow"},
+		{"i64.trunc_f32_u", ValueFromF32(9223372036854775808), ValueFromI64(math.MinInt64), ""},
+		{"i64.trunc_f32_u", ValueFromF32(float32(math.Inf(1))), Value{}, "integer overflow"},
+	}
+	for _, c := range cases {
+		ret, err := invokeExport(t, &i, c.fn, c.arg)
+		if c.err != "" {
+			assert.EqualError(t, err, c.err, c.fn)
+		} else if assert.NoError(t, err, c.fn) {
+			assert.Equal(t, []Value{c.expected}, ret, c.fn)
+		}
+	}
+}
+
+func TestWrapExtend(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "i32.wrap_i64") (param i64) (result i32)
+				local.get 0
+				i32.wrap_i64
+			)
+			(func (export "i64.extend_i32_s") (param i32) (result i64)
+				local.get 0
+				i64.extend_i32_s
+			)
+			(func (export "i64.extend_i32_u") (param i32) (result i64)
+				local.get 0
+				i64.extend_i32_u
+			)
+		)
+	`)
+	cases := []struct {
+		fn       string
+		arg      Value
+		expected Value
+	}{
+		{"i32.wrap_i64", ValueFromI64(0x1_2345_6789), ValueFromI32(0x2345_6789)},
+		{"i32.wrap_i64", ValueFromI64(-1), ValueFromI32(-1)},
+		{"i32.wrap_i64", ValueFromI64(-1 << 32), ValueFromI32(0)},
+		{"i64.extend_i32_s", ValueFromI32(-2), ValueFromI64(-2)},
+		{"i64.extend_i32_s", ValueFromI32(math.MaxInt32), ValueFromI64(math.MaxInt32)},
+		{"i64.extend_i32_u", ValueFromI32(-2), ValueFromI64(0xFFFF_FFFE)},
+		{"i64.extend_i32_u", ValueFromI32(7), ValueFromI64(7)},
+	}
+	for _, c := range cases {
+		ret, err := invokeExport(t, &i, c.fn, c.arg)
+		if assert.NoError(t, err, c.fn) {
+			assert.Equal(t, []Value{c.expected}, ret, c.fn)
+		}
+	}
+}
+
+func TestTruncSat(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "i32.trunc_sat_f32_s") (param f32) (result i32)
+				local.get 0
+				i32.trunc_sat_f32_s
+			)
+			(func (export "i32.trunc_sat_f64_u") (param f64) (result i32)
+				local.get 0
+				i32.trunc_sat_f64_u
+			)
+			(func (export "i64.trunc_sat_f64_s") (param f64) (result i64)
+				local.get 0
+				i64.trunc_sat_f64_s
+			)
+			(func (export "i64.trunc_sat_f32_u") (param f32) (result i64)
+				local.get 0
+				i64.trunc_sat_f32_u
+			)
+		)
+	`)
+	inf := math.Inf(1)
+	cases := []struct {
+		fn       string
+		arg      Value
+		expected Value
+	}{
+		{"i32.trunc_sat_f32_s", ValueFromF32(-3.9), ValueFromI32(-3)},
+		{"i32.trunc_sat_f32_s", ValueFromF32(float32(inf)), ValueFromI32(math.MaxInt32)},
+		{"i32.trunc_sat_f32_s", ValueFromF32(float32(-inf)), ValueFromI32(math.MinInt32)},
+		{"i32.trunc_sat_f32_s", ValueFromF32(float32(math.NaN())), ValueFromI32(0)},
+		{"i32.trunc_sat_f64_u", ValueFromF64(inf), ValueFromI32(-1)},
+		{"i32.trunc_sat_f64_u", ValueFromF64(-1), ValueFromI32(0)},
+		{"i32.trunc_sat_f64_u", ValueFromF64(math.NaN()), ValueFromI32(0)},
+		{"i64.trunc_sat_f64_s", ValueFromF64(inf), ValueFromI64(math.MaxInt64)},
+		{"i64.trunc_sat_f64_s", ValueFromF64(-1e300), ValueFromI64(math.MinInt64)},
+		{"i64.trunc_sat_f64_s", ValueFromF64(math.NaN()), ValueFromI64(0)},
+		{"i64.trunc_sat_f32_u", ValueFromF32(float32(inf)), ValueFromI64(-1)},
+		{"i64.trunc_sat_f32_u", ValueFromF32(42.5), ValueFromI64(42)},
+	}
+	for _, c := range cases {
+		ret, err := invokeExport(t, &i, c.fn, c.arg)
+		if assert.NoError(t, err, c.fn) {
+			assert.Equal(t, []Value{c.expected}, ret, c.fn)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "f32.convert_i32_s") (param i32) (result f32)
+				local.get 0
+				f32.convert_i32_s
+			)
+			(func (export "f32.convert_i32_u") (param i32) (result f32)
+				local.get 0
+				f32.convert_i32_u
+			)
+			(func (export "f64.convert_i64_s") (param i64) (result f64)
+				local.get 0
+				f64.convert_i64_s
+			)
+			(func (export "f64.convert_i64_u") (param i64) (result f64)
+				local.get 0
+				f64.convert_i64_u
+			)
+		)
+	`)
+	cases := []struct {
+		fn       string
+		arg      Value
+		expected Value
+	}{
+		{"f32.convert_i32_s", ValueFromI32(-1), ValueFromF32(-1)},
+		{"f32.convert_i32_u", ValueFromI32(-1), ValueFromF32(4294967296)},
+		{"f32.convert_i32_u", ValueFromI32(math.MinInt32), ValueFromF32(2147483648)},
+		{"f64.convert_i64_s", ValueFromI64(-1), ValueFromF64(-1)},
+		{"f64.convert_i64_u", ValueFromI64(-1), ValueFromF64(18446744073709551616)},
+		{"f64.convert_i64_u", ValueFromI64(math.MinInt64), ValueFromF64(9223372036854775808)},
+	}
+	for _, c := range cases {
+		ret, err := invokeExport(t, &i, c.fn, c.arg)
+		if assert.NoError(t, err, c.fn) {
+			assert.Equal(t, []Value{c.expected}, ret, c.fn)
+		}
+	}
+}
+
+func TestReinterpret(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "i32.reinterpret_f32") (param f32) (result i32)
+				local.get 0
+				i32.reinterpret_f32
+			)
+			(func (export "f32.reinterpret_i32") (param i32) (result f32)
+				local.get 0
+				f32.reinterpret_i32
+			)
+			(func (export "i64.reinterpret_f64") (param f64) (result i64)
+				local.get 0
+				i64.reinterpret_f64
+			)
+			(func (export "f64.reinterpret_i64") (param i64) (result f64)
+				local.get 0
+				f64.reinterpret_i64
+			)
+		)
+	`)
+	cases := []struct {
+		fn       string
+		arg      Value
+		expected Value
+	}{
+		{"i32.reinterpret_f32", ValueFromF32(1), ValueFromI32(0x3F800000)},
+		{"f32.reinterpret_i32", ValueFromI32(0x3F800000), ValueFromF32(1)},
+		{"i64.reinterpret_f64", ValueFromF64(1), ValueFromI64(0x3FF0000000000000)},
+		{"f64.reinterpret_i64", ValueFromI64(0x3FF0000000000000), ValueFromF64(1)},
+	}
+	for _, c := range cases {
+		ret, err := invokeExport(t, &i, c.fn, c.arg)
+		if assert.NoError(t, err, c.fn) {
+			assert.Equal(t, []Value{c.expected}, ret, c.fn)
+		}
+	}
+}
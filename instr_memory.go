@@ -1,69 +1,81 @@
 package wasm_go
 
+import (
+	"math"
+	"math/bits"
+)
+
 // https://webassembly.github.io/spec/core/exec/instructions.html#exec-storen
 type opStore struct {
-	offset  int32
-	align   int32
-	storeFn func(m *memInst, addr, align int32, v Value)
+	offset  uint64
+	align   uint32
+	memIdx  uint32
+	storeFn func(m *memInst, addr uint64, align int32, v Value) error
 }
 
 func (o *opStore) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
-	mem := store.mems[frame.mod.defaultMemAddr()]
+	mem := store.mems[frame.mod.memAddrs[o.memIdx]]
 	value, _ := valueStack.Pop()
-	addr := value.I32() + o.offset
-	o.storeFn(&mem, addr, o.align, value)
+	baseAddr, _ := valueStack.Pop()
+	addr, ok := effectiveAddr(baseAddr, o.offset)
+	if !ok || addr > uint64(mem.size()) {
+		return errOutOfBounds
+	}
+	if err := o.storeFn(&mem, addr, int32(o.align), value); err != nil {
+		return err
+	}
 	frame.NextStep()
 	return nil
 }
 
-func i32store(m *memInst, addr, align int32, v Value) {
-	m.store32(addr, align, uint32(v.I32()))
+func i32store(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store32(addr, align, uint32(v.I32()))
 }
-func i64store(m *memInst, addr, align int32, v Value) {
-	m.store64(addr, align, uint64(v.I64()))
+func i64store(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store64(addr, align, uint64(v.I64()))
 }
 
-func f32store(m *memInst, addr, align int32, v Value) {
-	m.store32(addr, align, uint32(v.F32()))
+func f32store(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store32(addr, align, math.Float32bits(v.F32()))
 }
 
-func f64store(m *memInst, addr, align int32, v Value) {
-	m.store64(addr, align, uint64(v.F64()))
+func f64store(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store64(addr, align, math.Float64bits(v.F64()))
 }
-func i32store8(m *memInst, addr, align int32, v Value) {
-	m.store8(addr, align, uint8(v.I32()))
+func i32store8(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store8(addr, align, uint8(v.I32()))
 }
-func i32store16(m *memInst, addr, align int32, v Value) {
-	m.store16(addr, align, uint16(v.I32()))
+func i32store16(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store16(addr, align, uint16(v.I32()))
 }
-func i64store8(m *memInst, addr, align int32, v Value) {
-	m.store8(addr, align, uint8(v.I64()))
+func i64store8(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store8(addr, align, uint8(v.I64()))
 }
-func i64store16(m *memInst, addr, align int32, v Value) {
-	m.store16(addr, align, uint16(v.I64()))
+func i64store16(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store16(addr, align, uint16(v.I64()))
 }
-func i64store32(m *memInst, addr, align int32, v Value) {
-	m.store32(addr, align, uint32(v.I64()))
+func i64store32(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store32(addr, align, uint32(v.I64()))
 }
 
 // https://webassembly.github.io/spec/core/exec/instructions.html#exec-loadn
 type opLoad struct {
-	align  int32
-	offset int32
-	loadFn func(m *memInst, addr, align int32) (Value, error)
+	align  uint32
+	offset uint64
+	memIdx uint32
+	loadFn func(m *memInst, addr uint64, align int32) (Value, error)
 }
 
 func (o *opLoad) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
-	mem := store.mems[frame.mod.defaultMemAddr()]
+	mem := store.mems[frame.mod.memAddrs[o.memIdx]]
 	baseAddr, _ := valueStack.Pop()
-	baseAddrI32 := baseAddr.I32()
-	if baseAddrI32 < 0 || o.offset < 0 {
+	addr, ok := effectiveAddr(baseAddr, o.offset)
+	if !ok || addr > uint64(mem.size()) {
 		return errOutOfBounds
 	}
-	addr := baseAddrI32 + o.offset
-	value, err := o.loadFn(&mem, addr, o.align)
+	value, err := o.loadFn(&mem, addr, int32(o.align))
 	if err != nil {
 		return err
 	}
@@ -72,72 +84,83 @@ func (o *opLoad) exec(frameStack *stack[frame], valueStack *stack[Value], store
 	return nil
 }
 
-func i32load(m *memInst, addr, align int32) (Value, error) {
+// effectiveAddr adds the memarg offset to the unsigned base address, an i32 one
+// or an i64 one for memory64. The sum doesn't fit in 64 bits when ok is false.
+// Callers check addr against the memory size before adding the access size to it.
+func effectiveAddr(baseAddr Value, offset uint64) (addr uint64, ok bool) {
+	if baseAddr.ValType != I64 {
+		return uint64(uint32(baseAddr.I32())) + offset, offset <= math.MaxUint64-math.MaxUint32
+	}
+	addr, carry := bits.Add64(uint64(baseAddr.I64()), offset, 0)
+	return addr, carry == 0
+}
+
+func i32load(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load32(addr, align)
 	return ValueFromI32(int32(v)), err
 }
 
-func i64load(m *memInst, addr, align int32) (Value, error) {
+func i64load(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load64(addr, align)
 	return ValueFromI64(int64(v)), err
 }
 
-func f32load(m *memInst, addr, align int32) (Value, error) {
+func f32load(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load32(addr, align)
-	return ValueFrom(v, F32), err
+	return ValueFromF32(math.Float32frombits(v)), err
 }
 
-func f64load(m *memInst, addr, align int32) (Value, error) {
+func f64load(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load64(addr, align)
-	return ValueFrom(v, F64), err
+	return ValueFromF64(math.Float64frombits(v)), err
 }
 
-func i32load8S(m *memInst, addr, align int32) (Value, error) {
+func i32load8S(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load8(addr, align)
 	return ValueFromI32(extendS8_32(int32(v))), err
 }
 
-func i32load8U(m *memInst, addr, align int32) (Value, error) {
+func i32load8U(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load8(addr, align)
 	return ValueFromI32(int32(v)), err
 }
 
-func i32load16S(m *memInst, addr, align int32) (Value, error) {
+func i32load16S(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load16(addr, align)
 	return ValueFromI32(extendS16_32(int32(v))), err
 }
 
-func i32load16U(m *memInst, addr, align int32) (Value, error) {
+func i32load16U(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load16(addr, align)
 	return ValueFromI32(int32(v)), err
 }
 
-func i64Load8S(m *memInst, addr, align int32) (Value, error) {
+func i64Load8S(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load8(addr, align)
 	return ValueFromI64(extendS8_64(int64(v))), err
 }
 
-func i64Load8U(m *memInst, addr, align int32) (Value, error) {
+func i64Load8U(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load8(addr, align)
 	return ValueFromI64(int64(v)), err
 }
 
-func i64load16S(m *memInst, addr, align int32) (Value, error) {
+func i64load16S(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load16(addr, align)
 	return ValueFromI64(extendS16_64(int64(v))), err
 }
 
-func i64load16U(m *memInst, addr, align int32) (Value, error) {
+func i64load16U(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load16(addr, align)
 	return ValueFromI64(int64(v)), err
 }
 
-func i64load32S(m *memInst, addr, align int32) (Value, error) {
+func i64load32S(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load32(addr, align)
 	return ValueFromI64(extendS32_64(int64(v))), err
 }
 
-func i64load32U(m *memInst, addr, align int32) (Value, error) {
+func i64load32U(m *memInst, addr uint64, align int32) (Value, error) {
 	v, err := m.load32(addr, align)
 	return ValueFromI64(int64(v)), err
 }
@@ -146,8 +169,12 @@ type opMemorySize struct{}
 
 func (o *opMemorySize) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
-	mem := store.mems[frame.mod.defaultMemAddr()]
-	valueStack.Push(ValueFrom(int32(mem.size()), I32))
+	mem := &store.mems[frame.mod.defaultMemAddr()]
+	if mem.memType.is64 {
+		valueStack.Push(ValueFromI64(int64(mem.pages())))
+	} else {
+		valueStack.Push(ValueFromI32(int32(mem.pages())))
+	}
 	frame.NextStep()
 	return nil
 }
@@ -156,16 +183,23 @@ type opMemoryGrow struct{}
 
 func (o *opMemoryGrow) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
-	mem := store.mems[frame.mod.defaultMemAddr()]
+	mem := &store.mems[frame.mod.defaultMemAddr()]
 
 	v, _ := valueStack.Pop()
 	currentPages := mem.pages()
-	pagesWant := int(v.I32())
-	err := mem.grow(pagesWant)
-	if err != nil {
-		valueStack.Push(ValueFrom(-1, I32))
+	pagesWant := int64(v.I32())
+	if mem.memType.is64 {
+		pagesWant = v.I64()
+	}
+	result := int64(currentPages)
+	// grow fails on negative deltas, huge i64 ones are cut short so adding them can't overflow
+	if pagesWant > maxPages || mem.grow(int(pagesWant)) != nil {
+		result = -1
+	}
+	if mem.memType.is64 {
+		valueStack.Push(ValueFromI64(result))
 	} else {
-		valueStack.Push(ValueFrom(currentPages, I32))
+		valueStack.Push(ValueFromI32(int32(result)))
 	}
 	frame.NextStep()
 	return nil
@@ -175,12 +209,53 @@ type opMemoryCopy struct {
 }
 
 func (o *opMemoryCopy) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
-	len, _ := valueStack.Pop()
+	n, _ := valueStack.Pop()
 	src, _ := valueStack.Pop()
 	dst, _ := valueStack.Pop()
 	frame, _ := frameStack.Top()
 	mem := store.mems[frame.mod.defaultMemAddr()]
-	copy(mem.data[dst.I32():], mem.data[src.I32():src.I32()+len.I32()])
+	size := uint64(uint32(n.I32()))
+	srcAddr := uint64(uint32(src.I32()))
+	dstAddr := uint64(uint32(dst.I32()))
+	if srcAddr+size > uint64(mem.size()) || dstAddr+size > uint64(mem.size()) {
+		return errOutOfBounds
+	}
+	// copy handles overlapping regions
+	copy(mem.data[dstAddr:], mem.data[srcAddr:srcAddr+size])
+	frame.NextStep()
+	return nil
+}
+
+// https://webassembly.github.io/spec/core/exec/instructions.html#xref-syntax-instructions-syntax-instr-memory-mathsf-memory-init-x
+type opMemoryInit struct {
+	dataIdx uint32
+}
+
+func (o *opMemoryInit) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	n, _ := valueStack.Pop()
+	src, _ := valueStack.Pop()
+	dst, _ := valueStack.Pop()
+	frame, _ := frameStack.Top()
+	mem := &store.mems[frame.mod.defaultMemAddr()]
+	data := store.datas[frame.mod.dataAddrs[o.dataIdx]].data
+	size := uint64(uint32(n.I32()))
+	srcAddr := uint64(uint32(src.I32()))
+	dstAddr := uint64(uint32(dst.I32()))
+	if srcAddr+size > uint64(len(data)) || dstAddr+size > uint64(mem.size()) {
+		return errOutOfBounds
+	}
+	copy(mem.data[dstAddr:], data[srcAddr:srcAddr+size])
+	frame.NextStep()
+	return nil
+}
+
+type opDataDrop struct {
+	dataIdx uint32
+}
+
+func (o *opDataDrop) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	store.datas[frame.mod.dataAddrs[o.dataIdx]].data = nil
 	frame.NextStep()
 	return nil
 }
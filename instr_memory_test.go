@@ -0,0 +1,553 @@
+package wasm_go
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorePopsAddress(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(func (export "store") (param i32 i32)
+				local.get 0
+				local.get 1
+				i32.store offset=4
+			)
+		)
+	`)
+	_, err := invokeExport(t, &i, "store", ValueFromI32(0), ValueFromI32(70000))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, i.valueStack.Len())
+
+	_, err = invokeExport(t, &i, "store", ValueFromI32(65532), ValueFromI32(1))
+	assert.EqualError(t, err, "out of bounds memory access")
+}
+
+func TestMemInstStore(t *testing.T) {
+	m := memInst{data: make([]byte, 16)}
+	assert.NoError(t, m.store8(0, 0, 0x01))
+	assert.NoError(t, m.store16(1, 0, 0x0302))
+	assert.NoError(t, m.store32(3, 0, 0x07060504))
+	assert.NoError(t, m.store64(7, 0, 0x0f0e0d0c0b0a0908))
+	assert.Equal(t, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x00}, m.data)
+
+	v, err := m.load32(3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x07060504), v)
+	assert.ErrorIs(t, m.store64(9, 0, 0), errOutOfBounds)
+}
+
+func TestMemoryGrowZeroFill(t *testing.T) {
+	// spare capacity holding stale bytes must not show up in the new pages
+	data := make([]byte, 3*PAGE_SIZE)
+	for x := range data {
+		data[x] = 0xff
+	}
+	m := memInst{memType: memType{limits: limits{Max: 3}}, data: data[:PAGE_SIZE]}
+	for x := range m.data {
+		m.data[x] = byte(x)
+	}
+	assert.NoError(t, m.grow(2))
+	assert.Equal(t, 3, m.pages())
+	for x := 0; x < PAGE_SIZE; x++ {
+		if m.data[x] != byte(x) {
+			t.Fatalf("old byte %d changed to 0x%02x", x, m.data[x])
+		}
+	}
+	assert.Equal(t, make([]byte, 2*PAGE_SIZE), m.data[PAGE_SIZE:])
+	assert.Error(t, m.grow(1))
+
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(func (export "run") (result i64)
+				i32.const 65528
+				i64.const -1
+				i64.store
+				i32.const 1
+				memory.grow
+				drop
+				i32.const 65536
+				i64.load
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "run")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(0)}, ret)
+	mem, err := i.ReadMemory(65528, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, mem)
+}
+
+func TestMemoryGrowToMax(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1 3)
+			(func (export "grow") (param i32) (result i32)
+				local.get 0
+				memory.grow
+			)
+			(func (export "size") (result i32)
+				memory.size
+			)
+		)
+	`)
+	// growing exactly to the max succeeds and returns the old size
+	ret, err := invokeExport(t, &i, "grow", ValueFromI32(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1)}, ret)
+	ret, err = invokeExport(t, &i, "grow", ValueFromI32(0))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+
+	ret, err = invokeExport(t, &i, "grow", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(-1)}, ret)
+	ret, err = invokeExport(t, &i, "size")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+
+	// without a declared max the address space is the limit
+	m := memInst{memType: memType{limits: limits{Max: -1}}}
+	assert.Error(t, m.grow(maxPages+1))
+	assert.Error(t, m.grow(-1))
+	assert.Equal(t, 0, m.pages())
+}
+
+func TestGrowMemoryFromHost(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1 3)
+			(func (export "size") (result i32)
+				memory.size
+			)
+		)
+	`)
+	previous, err := i.GrowMemory(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), previous)
+	ret, err := invokeExport(t, &i, "size")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(2)}, ret)
+	// the new page is usable right away
+	assert.NoError(t, i.WriteMemory(uint32(2*PAGE_SIZE-4), []byte{1, 2, 3, 4}))
+
+	// the declared max holds for the host too
+	previous, err = i.GrowMemory(2)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(-1), previous)
+	previous, err = i.GrowMemory(math.MaxUint32)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(-1), previous)
+	previous, err = i.GrowMemory(0)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), previous)
+	ret, err = invokeExport(t, &i, "size")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(2)}, ret)
+
+	i = newInterpreterFromWat(t, `(module)`)
+	previous, err = i.GrowMemory(1)
+	assert.EqualError(t, err, "module has no memory")
+	assert.Equal(t, int32(-1), previous)
+}
+
+func TestStoreLoadRoundTrip(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(func (export "roundtrip") (param i32 i64) (result i64)
+				local.get 0
+				local.get 1
+				i64.store offset=8
+				local.get 0
+				i64.load offset=8
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "roundtrip", ValueFromI32(16), ValueFromI64(-42))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(-42)}, ret)
+}
+
+func TestMemorySize(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(func (export "size") (result i32)
+				memory.size
+			)
+			(func (export "grow") (param i32) (result i32)
+				local.get 0
+				memory.grow
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "size")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1)}, ret)
+
+	ret, err = invokeExport(t, &i, "grow", ValueFromI32(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1)}, ret)
+
+	ret, err = invokeExport(t, &i, "size")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+}
+
+func TestFloatStoreLoadRoundTrip(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(func (export "f32") (param f32) (result f32)
+				i32.const 0
+				local.get 0
+				f32.store
+				i32.const 0
+				f32.load
+			)
+			(func (export "f32bits") (result i32)
+				i32.const 0
+				i32.load
+			)
+			(func (export "f64") (param f64) (result f64)
+				i32.const 8
+				local.get 0
+				f64.store
+				i32.const 8
+				f64.load
+			)
+			(func (export "f64bits") (result i64)
+				i32.const 8
+				i64.load
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "f32", ValueFromF32(-1.5))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromF32(-1.5)}, ret)
+	ret, err = invokeExport(t, &i, "f32bits")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(int32(math.Float32bits(-1.5)))}, ret)
+
+	ret, err = invokeExport(t, &i, "f64", ValueFromF64(3.25))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromF64(3.25)}, ret)
+	ret, err = invokeExport(t, &i, "f64bits")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(int64(math.Float64bits(3.25)))}, ret)
+}
+
+func TestMemoryArgsUnsigned(t *testing.T) {
+	wat := `
+		(module
+			(memory 1)
+			(data (i32.const 64) "\2a")
+			(func (export "load64") (result i32)
+				i32.const 0
+				i32.load8_u offset=64
+			)
+			(func (export "loadHigh") (result i32)
+				i32.const 0
+				i32.load offset=0x80000000
+			)
+		)
+	`
+	m := parseWat(t, wat)
+	load, ok := m.funcs[1].body[1].(*opLoad)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint64(0x80000000), load.offset)
+	}
+
+	i := newInterpreterFromWat(t, wat)
+	ret, err := invokeExport(t, &i, "load64")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+	_, err = invokeExport(t, &i, "loadHigh")
+	assert.EqualError(t, err, "out of bounds memory access")
+}
+
+func TestLoadOffsetOverflow(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(data (i32.const 0) "\01\02\03\04")
+			(func (export "maxOffset") (param i32) (result i32)
+				local.get 0
+				i32.load offset=0xfffffffc
+			)
+			(func (export "store") (param i32)
+				local.get 0
+				i32.const 1
+				i32.store offset=0x80000000
+			)
+			(func (export "i64") (param i32) (result i64)
+				local.get 0
+				i64.load offset=0x7ffffffc
+			)
+		)
+	`)
+	// base + offset wraps to 0 in 32 bits
+	_, err := invokeExport(t, &i, "maxOffset", ValueFromI32(4))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	_, err = invokeExport(t, &i, "maxOffset", ValueFromI32(-1))
+	assert.ErrorIs(t, err, errOutOfBounds)
+
+	// base + offset crosses the 2GiB boundary
+	_, err = invokeExport(t, &i, "store", ValueFromI32(0x7fffffff))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	_, err = invokeExport(t, &i, "i64", ValueFromI32(2))
+	assert.ErrorIs(t, err, errOutOfBounds)
+
+	mem, err := i.Memory()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4}, mem[:4])
+
+	m := memInst{data: make([]byte, PAGE_SIZE)}
+	_, err = m.load64(uint64(PAGE_SIZE-7), 0)
+	assert.ErrorIs(t, err, errOutOfBounds)
+	_, err = m.load64(math.MaxUint32, 0)
+	assert.ErrorIs(t, err, errOutOfBounds)
+}
+
+func TestMemoryCopy(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(data (i32.const 0) "\01\02\03\04\05\06")
+			(func (export "copy") (param i32 i32 i32)
+				local.get 0
+				local.get 1
+				local.get 2
+				memory.copy
+			)
+		)
+	`)
+	mem := i.store.mems[0].data
+
+	// overlapping forward copy
+	_, err := invokeExport(t, &i, "copy", ValueFromI32(2), ValueFromI32(0), ValueFromI32(4))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 1, 2, 3, 4, 0}, mem[:7])
+
+	// overlapping backward copy
+	_, err = invokeExport(t, &i, "copy", ValueFromI32(0), ValueFromI32(2), ValueFromI32(4))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4, 3, 4, 0}, mem[:7])
+
+	_, err = invokeExport(t, &i, "copy", ValueFromI32(0), ValueFromI32(65535), ValueFromI32(2))
+	assert.EqualError(t, err, "out of bounds memory access")
+	_, err = invokeExport(t, &i, "copy", ValueFromI32(-1), ValueFromI32(0), ValueFromI32(1))
+	assert.EqualError(t, err, "out of bounds memory access")
+}
+
+func TestMemoryInitDataDrop(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(data "hello")
+			(func (export "init") (param i32 i32 i32)
+				local.get 0
+				local.get 1
+				local.get 2
+				memory.init 0
+			)
+			(func (export "drop")
+				data.drop 0
+			)
+		)
+	`)
+	_, err := invokeExport(t, &i, "init", ValueFromI32(8), ValueFromI32(1), ValueFromI32(3))
+	assert.NoError(t, err)
+	data, err := i.ReadMemory(8, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ell"), data)
+
+	// past the end of the segment
+	_, err = invokeExport(t, &i, "init", ValueFromI32(0), ValueFromI32(3), ValueFromI32(3))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	// past the end of memory
+	_, err = invokeExport(t, &i, "init", ValueFromI32(int32(PAGE_SIZE)-1), ValueFromI32(0), ValueFromI32(2))
+	assert.ErrorIs(t, err, errOutOfBounds)
+
+	_, err = invokeExport(t, &i, "drop")
+	assert.NoError(t, err)
+	_, err = invokeExport(t, &i, "init", ValueFromI32(0), ValueFromI32(0), ValueFromI32(1))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	// an empty init is fine even after the drop
+	_, err = invokeExport(t, &i, "init", ValueFromI32(0), ValueFromI32(0), ValueFromI32(0))
+	assert.NoError(t, err)
+}
+
+func TestMultiMemoryLoadStore(t *testing.T) {
+	wat := `
+		(module
+			(memory 1)
+			(memory 1)
+			(data (memory 1) (i32.const 0) "\2a")
+			(func (export "load") (result i32)
+				i32.const 0
+				i32.load8_u 1
+			)
+			(func (export "store")
+				i32.const 0
+				i32.const 7
+				i32.store 1 offset=4
+			)
+		)
+	`
+	m := parseWat(t, wat)
+	load, ok := m.funcs[0].body[1].(*opLoad)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint32(1), load.memIdx)
+		assert.Equal(t, uint32(0), load.align)
+	}
+	store, ok := m.funcs[1].body[2].(*opStore)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint32(1), store.memIdx)
+		assert.Equal(t, uint32(2), store.align)
+		assert.Equal(t, uint64(4), store.offset)
+	}
+
+	i := newInterpreterFromWat(t, wat)
+	ret, err := invokeExport(t, &i, "load")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+
+	_, err = invokeExport(t, &i, "store")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x2a, 0, 0, 0, 7}, i.store.mems[i.mod.memAddrs[1]].data[:5])
+	assert.Equal(t, make([]byte, 8), i.store.mems[i.mod.memAddrs[0]].data[:8])
+}
+
+func BenchmarkMemoryGrow(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		m := memInst{memType: memType{limits: limits{Max: -1}}}
+		for p := 0; p < 256; p++ {
+			if err := m.grow(1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestMultiMemoryUnsupported(t *testing.T) {
+	cases := []struct {
+		instr string
+		err   string
+	}{
+		{instr: "i32.const 0 memory.size 1 drop", err: "memory.size on memory 1 is not supported"},
+		{instr: "i32.const 1 memory.grow 1 drop", err: "memory.grow on memory 1 is not supported"},
+		{instr: "i32.const 0 i32.const 0 i32.const 0 memory.fill 1", err: "memory.fill on memory 1 is not supported"},
+		{instr: "i32.const 0 i32.const 0 i32.const 0 memory.copy 1 0", err: "memory.copy on memory 1 is not supported"},
+		{instr: "i32.const 0 i32.const 0 i32.const 0 memory.init 1 0", err: "memory.init on memory 1 is not supported"},
+	}
+	for _, c := range cases {
+		wasm, err := wat2wasm(fmt.Sprintf(`
+			(module
+				(memory 1)
+				(memory 1)
+				(data "")
+				(func %s)
+			)
+		`, c.instr))
+		if !assert.NoError(t, err, c.instr) {
+			continue
+		}
+		_, err = NewInterpreter(wasm)
+		assert.EqualError(t, err, c.err, c.instr)
+	}
+}
+
+func TestMemory64(t *testing.T) {
+	wat := `
+		(module
+			(memory i64 1 2)
+			(data (i64.const 8) "\01\02\03\04")
+			(func (export "load") (param i64) (result i32)
+				local.get 0
+				i32.load offset=4
+			)
+			(func (export "store") (param i64 i64)
+				local.get 0
+				local.get 1
+				i64.store
+			)
+			(func (export "maxOffset") (param i64) (result i32)
+				local.get 0
+				i32.load8_u offset=0xffffffffffffffff
+			)
+			(func (export "grow") (param i64) (result i64)
+				local.get 0
+				memory.grow
+			)
+			(func (export "size") (result i64)
+				memory.size
+			)
+		)
+	`
+	m := parseWat(t, wat)
+	if assert.Len(t, m.mems, 1) {
+		assert.True(t, m.mems[0].is64)
+		assert.Equal(t, limits{Min: 1, Max: 2}, m.mems[0].limits)
+	}
+	load, ok := m.funcs[2].body[1].(*opLoad)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint64(math.MaxUint64), load.offset)
+	}
+
+	i := newInterpreterFromWat(t, wat)
+	ret, err := invokeExport(t, &i, "load", ValueFromI64(4))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(0x04030201)}, ret)
+
+	_, err = invokeExport(t, &i, "store", ValueFromI64(int64(PAGE_SIZE-8)), ValueFromI64(-1))
+	assert.NoError(t, err)
+	ret, err = invokeExport(t, &i, "load", ValueFromI64(int64(PAGE_SIZE-8)))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(-1)}, ret)
+
+	// addresses past 4GiB don't wrap around
+	_, err = invokeExport(t, &i, "load", ValueFromI64(1<<32+8))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	_, err = invokeExport(t, &i, "store", ValueFromI64(1<<32), ValueFromI64(0))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	// base + offset overflows 64 bits
+	_, err = invokeExport(t, &i, "maxOffset", ValueFromI64(1))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	_, err = invokeExport(t, &i, "maxOffset", ValueFromI64(0))
+	assert.ErrorIs(t, err, errOutOfBounds)
+
+	ret, err = invokeExport(t, &i, "grow", ValueFromI64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(1)}, ret)
+	ret, err = invokeExport(t, &i, "grow", ValueFromI64(-1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(-1)}, ret)
+	ret, err = invokeExport(t, &i, "size")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(2)}, ret)
+
+	// bulk memory instrs only take i32 operands so far
+	wasm, err := wat2wasm(`
+		(module
+			(memory i64 1)
+			(func
+				i64.const 0
+				i32.const 0
+				i64.const 0
+				memory.fill
+			)
+		)
+	`)
+	if assert.NoError(t, err) {
+		_, err = NewInterpreter(wasm)
+		assert.EqualError(t, err, "memory.fill on a 64-bit memory is not supported")
+	}
+}
@@ -1,92 +1,118 @@
 package wasm_go
 
 import (
-	"errors"
 	"math"
 	"math/bits"
 )
 
 var (
-	errIntegerDivideByZero = errors.New("integer divide by zero")
-	errIntegerOverflow     = errors.New("integer overflow")
+	errIntegerDivideByZero = newTrap(TrapCodeDivByZero, "integer divide by zero")
+	errIntegerOverflow     = newTrap(TrapCodeIntegerOverflow, "integer overflow")
 )
 
 // clz | ctz | popcnt
 // abs ∣ neg ∣ sqrt ∣ ceil ∣ floor ∣ trunc ∣ nearest
 type opUn struct {
 	unOpFn func(v Value) Value
+	// abs and neg only touch the sign bit, their NaN results are never canonicalized
+	bitwise bool
 }
 
 func (o *opUn) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	v, _ := valueStack.Pop()
-	valueStack.Push(o.unOpFn(v))
+	ret := o.unOpFn(v)
+	if store.canonicalNaN && !o.bitwise {
+		ret = canonicalizeNaN(ret)
+	}
+	valueStack.Push(ret)
 	frame, _ := frameStack.Top()
 	frame.NextStep()
 	return nil
 }
 
+// https://webassembly.github.io/spec/core/exec/numerics.html#nan-propagation
+const (
+	canonicalNaN32 uint32 = 0x7fc00000
+	canonicalNaN64 uint64 = 0x7ff8000000000000
+)
+
+// canonicalizeNaN replaces any float NaN by the positive canonical NaN.
+func canonicalizeNaN(v Value) Value {
+	switch v.ValType {
+	case F32:
+		if f := v.F32(); f != f {
+			return Value{ValType: F32, raw: uint64(canonicalNaN32)}
+		}
+	case F64:
+		if f := v.F64(); f != f {
+			return Value{ValType: F64, raw: canonicalNaN64}
+		}
+	}
+	return v
+}
+
 // https://webassembly.github.io/spec/core/exec/numerics.html#op-iclz
 func i32Clz(v Value) Value {
-	return ValueFrom(int32(bits.LeadingZeros32(uint32(v.I32()))), I32)
+	return ValueFromI32(int32(bits.LeadingZeros32(uint32(v.I32()))))
 }
 func i64Clz(v Value) Value {
-	return ValueFrom(int64(bits.LeadingZeros64(uint64(v.I64()))), I64)
+	return ValueFromI64(int64(bits.LeadingZeros64(uint64(v.I64()))))
 }
 
 // https://webassembly.github.io/spec/core/exec/numerics.html#op-ictz
 func i32Ctz(v Value) Value {
-	return ValueFrom(int32(bits.TrailingZeros32(uint32(v.I32()))), I32)
+	return ValueFromI32(int32(bits.TrailingZeros32(uint32(v.I32()))))
 }
 func i64Ctz(v Value) Value {
-	return ValueFrom(int64(bits.TrailingZeros64(uint64(v.I64()))), I64)
+	return ValueFromI64(int64(bits.TrailingZeros64(uint64(v.I64()))))
 }
 
 // https://webassembly.github.io/spec/core/exec/numerics.html#xref-exec-numerics-op-ipopcnt-mathrm-ipopcnt-n-i
 func i32Popcnt(v Value) Value {
-	return ValueFrom(int32(bits.OnesCount32(uint32(v.I32()))), I32)
+	return ValueFromI32(int32(bits.OnesCount32(uint32(v.I32()))))
 }
 func i64Popcnt(v Value) Value {
-	return ValueFrom(int64(bits.OnesCount64(uint64(v.I64()))), I64)
+	return ValueFromI64(int64(bits.OnesCount64(uint64(v.I64()))))
 }
 func f32Abs(v Value) Value {
-	return ValueFrom(float32(math.Abs(float64(v.F32()))), F32)
+	return ValueFromF32(float32(math.Abs(float64(v.F32()))))
 }
 func f64Abs(v Value) Value {
-	return ValueFrom(math.Abs(float64(v.F64())), F64)
+	return ValueFromF64(math.Abs(float64(v.F64())))
 }
 
 func f32Neg(v Value) Value {
-	return ValueFrom(-v.F32(), F32)
+	return ValueFromF32(-v.F32())
 }
 func f64Neg(v Value) Value {
-	return ValueFrom(-v.F64(), F64)
+	return ValueFromF64(-v.F64())
 }
 
 func f32Sqrt(v Value) Value {
-	return ValueFrom(float32(math.Sqrt(float64(v.F32()))), F32)
+	return ValueFromF32(float32(math.Sqrt(float64(v.F32()))))
 }
 func f64Sqrt(v Value) Value {
-	return ValueFrom(math.Sqrt(float64(v.F64())), F64)
+	return ValueFromF64(math.Sqrt(float64(v.F64())))
 }
 
 func f32Ceil(v Value) Value {
-	return ValueFrom(float32(math.Ceil(float64(v.F32()))), F32)
+	return ValueFromF32(float32(math.Ceil(float64(v.F32()))))
 }
 func f64Ceil(v Value) Value {
-	return ValueFrom(math.Ceil(float64(v.F64())), F64)
+	return ValueFromF64(math.Ceil(float64(v.F64())))
 }
 func f32Floor(v Value) Value {
-	return ValueFrom(float32(math.Floor(float64(v.F32()))), F32)
+	return ValueFromF32(float32(math.Floor(float64(v.F32()))))
 }
 func f64Floor(v Value) Value {
-	return ValueFrom(math.Floor(float64(v.F64())), F64)
+	return ValueFromF64(math.Floor(float64(v.F64())))
 }
 
 func f32Trunc(v Value) Value {
-	return ValueFrom(float32(math.Trunc(float64(v.F32()))), F32)
+	return ValueFromF32(float32(math.Trunc(float64(v.F32()))))
 }
 func f64Trunc(v Value) Value {
-	return ValueFrom(math.Trunc(float64(v.F64())), F64)
+	return ValueFromF64(math.Trunc(float64(v.F64())))
 }
 
 func nearest(x float64) float64 {
@@ -97,30 +123,30 @@ func nearest(x float64) float64 {
 	return t
 }
 func f32Nearest(v Value) Value {
-	return ValueFrom(float32(nearest(float64(v.F32()))), F32)
+	return ValueFromF32(float32(nearest(float64(v.F32()))))
 }
 
 func f64Nearest(v Value) Value {
-	return ValueFrom(nearest(v.F64()), F64)
+	return ValueFromF64(nearest(v.F64()))
 }
 
 func i32Extend8S(v Value) Value {
-	return ValueFrom(extendS8_32(v.I32()), I32)
+	return ValueFromI32(extendS8_32(v.I32()))
 }
 
 func i32Extend16S(v Value) Value {
-	return ValueFrom(extendS16_32(v.I32()), I32)
+	return ValueFromI32(extendS16_32(v.I32()))
 }
 
 func i64Extend8S(v Value) Value {
-	return ValueFrom(extendS8_64(v.I64()), I64)
+	return ValueFromI64(extendS8_64(v.I64()))
 }
 func i64Extend16S(v Value) Value {
-	return ValueFrom(extendS16_64(v.I64()), I64)
+	return ValueFromI64(extendS16_64(v.I64()))
 }
 
 func i64Extend32S(v Value) Value {
-	return ValueFrom(extendS32_64(v.I64()), I64)
+	return ValueFromI64(extendS32_64(v.I64()))
 }
 
 // add ∣ sub ∣ mul ∣ div_u | div_s ∣ rem_u | rem_s
@@ -128,16 +154,25 @@ func i64Extend32S(v Value) Value {
 // div ∣ min ∣ max ∣ copysign
 type opBin struct {
 	binFn func(a, b Value) (Value, error)
+	// copysign only touches the sign bit, its NaN results are never canonicalized
+	bitwise bool
+	// set for f32x4 instrs, their NaN lanes are canonicalized one by one
+	f32x4 bool
 }
 
 func (o *opBin) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
-	a, _ := valueStack.Pop()
 	b, _ := valueStack.Pop()
+	a, _ := valueStack.Pop()
 
 	ret, err := o.binFn(a, b)
 	if err != nil {
 		return err
 	}
+	if store.canonicalNaN && o.f32x4 {
+		ret = canonicalizeF32Lanes(ret)
+	} else if store.canonicalNaN && !o.bitwise {
+		ret = canonicalizeNaN(ret)
+	}
 	valueStack.Push(ret)
 	frame, _ := frameStack.Top()
 	frame.NextStep()
@@ -145,7 +180,7 @@ func (o *opBin) exec(frameStack *stack[frame], valueStack *stack[Value], store *
 }
 
 func i32Add(a, b Value) (Value, error) {
-	return ValueFrom(a.I32()+b.I32(), I32), nil
+	return ValueFromI32(a.I32() + b.I32()), nil
 }
 
 func i64Add(a, b Value) (Value, error) {
@@ -153,50 +188,50 @@ func i64Add(a, b Value) (Value, error) {
 }
 
 func f32Add(a, b Value) (Value, error) {
-	return ValueFrom(a.F32()+b.F32(), F32), nil
+	return ValueFromF32(a.F32() + b.F32()), nil
 }
 
 func f64Sub(a, b Value) (Value, error) {
-	return ValueFrom(a.F64()-b.F64(), F64), nil
+	return ValueFromF64(a.F64() - b.F64()), nil
 }
 func i32Sub(a, b Value) (Value, error) {
-	return ValueFrom(a.I32()-b.I32(), I32), nil
+	return ValueFromI32(a.I32() - b.I32()), nil
 }
 
 func i64Sub(a, b Value) (Value, error) {
-	return ValueFrom(a.I64()-b.I64(), I64), nil
+	return ValueFromI64(a.I64() - b.I64()), nil
 }
 
 func f32Sub(a, b Value) (Value, error) {
-	return ValueFrom(a.F32()-b.F32(), F32), nil
+	return ValueFromF32(a.F32() - b.F32()), nil
 }
 
 func f64Add(a, b Value) (Value, error) {
-	return ValueFrom(a.F64()+b.F64(), F64), nil
+	return ValueFromF64(a.F64() + b.F64()), nil
 }
 
 func f32Mul(a, b Value) (Value, error) {
-	return ValueFrom(a.F32()*b.F32(), F32), nil
+	return ValueFromF32(a.F32() * b.F32()), nil
 }
 
 func f64Mul(a, b Value) (Value, error) {
-	return ValueFrom(a.F64()*b.F64(), F64), nil
+	return ValueFromF64(a.F64() * b.F64()), nil
 }
 
 func i32Mul(a, b Value) (Value, error) {
-	return ValueFrom(a.I32()*b.I32(), I32), nil
+	return ValueFromI32(a.I32() * b.I32()), nil
 }
 
 func i64Mul(a, b Value) (Value, error) {
-	return ValueFrom(a.I64()*b.I64(), I64), nil
+	return ValueFromI64(a.I64() * b.I64()), nil
 }
 
 func f32Div(a, b Value) (Value, error) {
-	return ValueFrom(a.F32()/b.F32(), F32), nil
+	return ValueFromF32(a.F32() / b.F32()), nil
 }
 
 func f64Div(a, b Value) (Value, error) {
-	return ValueFrom(a.F64()/b.F64(), F64), nil
+	return ValueFromF64(a.F64() / b.F64()), nil
 }
 
 func i32DivU(a, b Value) (Value, error) {
@@ -205,7 +240,7 @@ func i32DivU(a, b Value) (Value, error) {
 	if bI32 == 0 {
 		return Value{}, errIntegerDivideByZero
 	}
-	return ValueFrom(uint32(aI32)/uint32(bI32), I32), nil
+	return ValueFromI32(int32(uint32(aI32) / uint32(bI32))), nil
 }
 func i32DivS(a, b Value) (Value, error) {
 	aI32 := a.I32()
@@ -216,7 +251,7 @@ func i32DivS(a, b Value) (Value, error) {
 	if aI32 == math.MinInt32 && bI32 == -1 {
 		return Value{}, errIntegerOverflow
 	}
-	return ValueFrom(aI32/bI32, I32), nil
+	return ValueFromI32(aI32 / bI32), nil
 }
 
 func i64DivU(a, b Value) (Value, error) {
@@ -225,7 +260,7 @@ func i64DivU(a, b Value) (Value, error) {
 	if bI64 == 0 {
 		return Value{}, errIntegerDivideByZero
 	}
-	return ValueFrom(uint64(aI64)/uint64(bI64), I64), nil
+	return ValueFromI64(int64(uint64(aI64) / uint64(bI64))), nil
 }
 
 func i64DivS(a, b Value) (Value, error) {
@@ -237,7 +272,7 @@ func i64DivS(a, b Value) (Value, error) {
 	if aI64 == math.MinInt64 && bI64 == -1 {
 		return Value{}, errIntegerOverflow
 	}
-	return ValueFrom(aI64/bI64, I64), nil
+	return ValueFromI64(aI64 / bI64), nil
 }
 
 func i32RemU(a, b Value) (Value, error) {
@@ -246,7 +281,7 @@ func i32RemU(a, b Value) (Value, error) {
 	if bI32 == 0 {
 		return Value{}, errIntegerDivideByZero
 	}
-	return ValueFrom(uint32(aI32)%uint32(bI32), I32), nil
+	return ValueFromI32(int32(uint32(aI32) % uint32(bI32))), nil
 }
 func i32RemS(a, b Value) (Value, error) {
 	aI32 := a.I32()
@@ -254,7 +289,7 @@ func i32RemS(a, b Value) (Value, error) {
 	if bI32 == 0 {
 		return Value{}, errIntegerDivideByZero
 	}
-	return ValueFrom(aI32%bI32, I32), nil
+	return ValueFromI32(aI32 % bI32), nil
 }
 
 func i64RemU(a, b Value) (Value, error) {
@@ -263,7 +298,7 @@ func i64RemU(a, b Value) (Value, error) {
 	if bI64 == 0 {
 		return Value{}, errIntegerDivideByZero
 	}
-	return ValueFrom(uint64(aI64)%uint64(bI64), I64), nil
+	return ValueFromI64(int64(uint64(aI64) % uint64(bI64))), nil
 }
 
 func i64RemS(a, b Value) (Value, error) {
@@ -272,115 +307,156 @@ func i64RemS(a, b Value) (Value, error) {
 	if bI64 == 0 {
 		return Value{}, errIntegerDivideByZero
 	}
-	return ValueFrom(aI64%bI64, I64), nil
+	return ValueFromI64(aI64 % bI64), nil
 }
 
 func i32And(a, b Value) (Value, error) {
-	return ValueFrom(a.I32()&b.I32(), I32), nil
+	return ValueFromI32(a.I32() & b.I32()), nil
 }
 
 func i64And(a, b Value) (Value, error) {
-	return ValueFrom(a.I64()&b.I64(), I64), nil
+	return ValueFromI64(a.I64() & b.I64()), nil
 }
 
 func i32Or(a, b Value) (Value, error) {
-	return ValueFrom(a.I32()|b.I32(), I32), nil
+	return ValueFromI32(a.I32() | b.I32()), nil
 }
 
 func i64Or(a, b Value) (Value, error) {
-	return ValueFrom(a.I64()|b.I64(), I64), nil
+	return ValueFromI64(a.I64() | b.I64()), nil
 }
 
 func i32Xor(a, b Value) (Value, error) {
-	return ValueFrom(a.I32()^b.I32(), I32), nil
+	return ValueFromI32(a.I32() ^ b.I32()), nil
 }
 
 func i64Xor(a, b Value) (Value, error) {
-	return ValueFrom(a.I64()^b.I64(), I64), nil
+	return ValueFromI64(a.I64() ^ b.I64()), nil
 }
 
 func i32Shl(a, b Value) (Value, error) {
-	return ValueFrom(a.I32()<<(uint32(b.I32())%32), I32), nil
+	return ValueFromI32(a.I32() << (uint32(b.I32()) % 32)), nil
 }
 
 func i64Shl(a, b Value) (Value, error) {
-	return ValueFrom(a.I64()<<(uint64(b.I64())%64), I64), nil
+	return ValueFromI64(a.I64() << (uint64(b.I64()) % 64)), nil
 }
 
 func i32ShrS(a, b Value) (Value, error) {
-	return ValueFrom(a.I32()>>(uint32(b.I32())%32), I32), nil
+	return ValueFromI32(a.I32() >> (uint32(b.I32()) % 32)), nil
 }
 
 func i32ShrU(a, b Value) (Value, error) {
-	return ValueFrom(uint32(a.I32())>>(uint32(b.I32())%32), I32), nil
+	return ValueFromI32(int32(uint32(a.I32()) >> (uint32(b.I32()) % 32))), nil
 }
 
 func i64ShrS(a, b Value) (Value, error) {
-	return ValueFrom(a.I64()>>(uint64(b.I64())%64), I64), nil
+	return ValueFromI64(a.I64() >> (uint64(b.I64()) % 64)), nil
 }
 
 func i64ShrU(a, b Value) (Value, error) {
-	return ValueFrom(uint64(a.I64())>>(uint64(b.I64())%64), I64), nil
+	return ValueFromI64(int64(uint64(a.I64()) >> (uint64(b.I64()) % 64))), nil
 }
 
 func i32RotL(a, b Value) (Value, error) {
-	return ValueFrom(bits.RotateLeft32(uint32(a.I32()), int(b.I32())), I32), nil
+	return ValueFromI32(int32(bits.RotateLeft32(uint32(a.I32()), int(b.I32())))), nil
 }
 
 func i64RotL(a, b Value) (Value, error) {
-	return ValueFrom(bits.RotateLeft64(uint64(a.I64()), int(b.I64())), I64), nil
+	return ValueFromI64(int64(bits.RotateLeft64(uint64(a.I64()), int(b.I64())))), nil
 }
 
 func i32RotR(a, b Value) (Value, error) {
-	return ValueFrom(rotateRight32(uint32(a.I32()), int(b.I32())), I32), nil
+	return ValueFromI32(int32(rotateRight32(uint32(a.I32()), int(b.I32())))), nil
 }
 
 func i64RotR(a, b Value) (Value, error) {
-	return ValueFrom(rotateRight64(uint64(a.I64()), int(b.I64())), I64), nil
+	return ValueFromI64(int64(rotateRight64(uint64(a.I64()), int(b.I64())))), nil
 }
 
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-fmin
+// math.Min and math.Max order the signed zeros like wasm, but a NaN operand gives
+// math.NaN(), not the canonical NaN, so the comparison is done here.
 func f32Min(a, b Value) (Value, error) {
-	aF32 := a.F32()
-	bF32 := b.F32()
-	if math.IsNaN(float64(aF32)) || math.IsNaN(float64(bF32)) {
-		return ValueFrom(float32(math.NaN()), F32), nil
+	x, y := a.F32(), b.F32()
+	if x != x || y != y {
+		return ValueFromF32(math.Float32frombits(canonicalNaN32)), nil
+	}
+	if x == y {
+		// only differs for zeros, keep the negative one
+		if math.Signbit(float64(x)) {
+			return a, nil
+		}
+		return b, nil
 	}
-	return ValueFrom(float32(math.Min(float64(aF32), float64(bF32))), F32), nil
+	if x < y {
+		return a, nil
+	}
+	return b, nil
 }
 
 func f64Min(a, b Value) (Value, error) {
-	aF64 := a.F64()
-	bF64 := b.F64()
-	if math.IsNaN(aF64) || math.IsNaN(bF64) {
-		return ValueFrom(math.NaN(), F64), nil
+	x, y := a.F64(), b.F64()
+	if x != x || y != y {
+		return ValueFromF64(math.Float64frombits(canonicalNaN64)), nil
+	}
+	if x == y {
+		if math.Signbit(x) {
+			return a, nil
+		}
+		return b, nil
+	}
+	if x < y {
+		return a, nil
 	}
-	return ValueFrom(math.Min(aF64, bF64), F64), nil
+	return b, nil
 }
 
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-fmax
 func f32Max(a, b Value) (Value, error) {
-	aF32 := a.F32()
-	bF32 := b.F32()
-	if math.IsNaN(float64(aF32)) || math.IsNaN(float64(bF32)) {
-		return ValueFrom(float32(math.NaN()), F32), nil
+	x, y := a.F32(), b.F32()
+	if x != x || y != y {
+		return ValueFromF32(math.Float32frombits(canonicalNaN32)), nil
+	}
+	if x == y {
+		// only differs for zeros, keep the positive one
+		if math.Signbit(float64(x)) {
+			return b, nil
+		}
+		return a, nil
+	}
+	if x > y {
+		return a, nil
 	}
-	return ValueFrom(float32(math.Max(float64(aF32), float64(bF32))), F32), nil
+	return b, nil
 }
 
 func f64Max(a, b Value) (Value, error) {
-	aF64 := a.F64()
-	bF64 := b.F64()
-	if math.IsNaN(aF64) || math.IsNaN(bF64) {
-		return ValueFrom(math.NaN(), F64), nil
+	x, y := a.F64(), b.F64()
+	if x != x || y != y {
+		return ValueFromF64(math.Float64frombits(canonicalNaN64)), nil
 	}
-	return ValueFrom(math.Max(aF64, bF64), F64), nil
+	if x == y {
+		if math.Signbit(x) {
+			return b, nil
+		}
+		return a, nil
+	}
+	if x > y {
+		return a, nil
+	}
+	return b, nil
 }
 
 func f32Copysign(a, b Value) (Value, error) {
-	return ValueFrom(math.Copysign(float64(a.F32()), float64(b.F32())), F32), nil
+	// only the sign bit changes, a NaN keeps its payload
+	const sign = 1 << 31
+	bits := math.Float32bits(a.F32())&^sign | math.Float32bits(b.F32())&sign
+	return ValueFromF32(math.Float32frombits(bits)), nil
 }
 
 func f64Copysign(a, b Value) (Value, error) {
-	return ValueFrom(math.Copysign(a.F64(), b.F64()), F64), nil
+	return ValueFromF64(math.Copysign(a.F64(), b.F64())), nil
 }
 
 // https://webassembly.github.io/spec/core/exec/instructions.html#t-mathsf-xref-syntax-instructions-syntax-instr-numeric-mathsf-const-c
@@ -403,8 +479,8 @@ type opRel struct {
 }
 
 func (o *opRel) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
-	a, _ := valueStack.Pop()
 	b, _ := valueStack.Pop()
+	a, _ := valueStack.Pop()
 
 	valueStack.Push(numericBool(o.relFn(a, b)))
 
@@ -419,6 +495,10 @@ func i32Eq(a, b Value) bool {
 func i64Eq(a, b Value) bool {
 	return a.I64() == b.I64()
 }
+
+// Go compares floats like IEEE 754 and the spec do: any comparison with a NaN
+// is false except ne, and +0 equals -0.
+// https://webassembly.github.io/spec/core/exec/numerics.html#op-feq
 func f32Eq(a, b Value) bool {
 	return a.F32() == b.F32()
 }
@@ -556,5 +636,5 @@ func numericBool(b bool) Value {
 	if b {
 		v = int32(1)
 	}
-	return ValueFrom(v, I32)
+	return ValueFromI32(v)
 }
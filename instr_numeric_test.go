@@ -0,0 +1,231 @@
+package wasm_go
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeterministicNaN(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "div32") (result f32)
+				f32.const 0
+				f32.const 0
+				f32.div
+			)
+			(func (export "div64") (result f64)
+				f64.const 0
+				f64.const 0
+				f64.div
+			)
+			(func (export "add_payload") (result f32)
+				f32.const -nan:0x200001
+				f32.const 1
+				f32.add
+			)
+			(func (export "neg") (result f32)
+				f32.const nan
+				f32.neg
+			)
+		)
+	`)
+	i.SetDeterministic(true)
+
+	ret, err := invokeExport(t, &i, "div32")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x7fc00000), math.Float32bits(ret[0].F32()))
+
+	ret, err = invokeExport(t, &i, "div64")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x7ff8000000000000), math.Float64bits(ret[0].F64()))
+
+	ret, err = invokeExport(t, &i, "add_payload")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x7fc00000), math.Float32bits(ret[0].F32()))
+
+	// neg only flips the sign bit
+	ret, err = invokeExport(t, &i, "neg")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0xffc00000), math.Float32bits(ret[0].F32()))
+
+	i.SetDeterministic(false)
+	ret, err = invokeExport(t, &i, "div32")
+	assert.NoError(t, err)
+	assert.True(t, math.IsNaN(float64(ret[0].F32())))
+}
+
+func TestFloatMinMax(t *testing.T) {
+	posZero32, negZero32 := ValueFromF32(0), ValueFromF32(float32(math.Copysign(0, -1)))
+	posZero64, negZero64 := ValueFromF64(0), ValueFromF64(math.Copysign(0, -1))
+	nan32, nan64 := ValueFromF32(float32(math.NaN())), ValueFromF64(math.NaN())
+
+	cases := []struct {
+		name     string
+		fn       func(a, b Value) (Value, error)
+		a, b     Value
+		expected Value
+	}{
+		{"f32.min(+0, -0)", f32Min, posZero32, negZero32, negZero32},
+		{"f32.min(-0, +0)", f32Min, negZero32, posZero32, negZero32},
+		{"f32.max(+0, -0)", f32Max, posZero32, negZero32, posZero32},
+		{"f32.max(-0, +0)", f32Max, negZero32, posZero32, posZero32},
+		{"f32.min(1, 2)", f32Min, ValueFromF32(1), ValueFromF32(2), ValueFromF32(1)},
+		{"f32.max(1, 2)", f32Max, ValueFromF32(1), ValueFromF32(2), ValueFromF32(2)},
+		{"f32.min(nan, 1)", f32Min, nan32, ValueFromF32(1), ValueFromF32(math.Float32frombits(0x7fc00000))},
+		{"f32.max(1, nan)", f32Max, ValueFromF32(1), nan32, ValueFromF32(math.Float32frombits(0x7fc00000))},
+		{"f64.min(+0, -0)", f64Min, posZero64, negZero64, negZero64},
+		{"f64.min(-0, +0)", f64Min, negZero64, posZero64, negZero64},
+		{"f64.max(+0, -0)", f64Max, posZero64, negZero64, posZero64},
+		{"f64.max(-0, +0)", f64Max, negZero64, posZero64, posZero64},
+		{"f64.min(-1, 2)", f64Min, ValueFromF64(-1), ValueFromF64(2), ValueFromF64(-1)},
+		{"f64.max(-1, 2)", f64Max, ValueFromF64(-1), ValueFromF64(2), ValueFromF64(2)},
+		{"f64.min(nan, 1)", f64Min, nan64, ValueFromF64(1), ValueFromF64(math.Float64frombits(0x7ff8000000000000))},
+		{"f64.max(1, nan)", f64Max, ValueFromF64(1), nan64, ValueFromF64(math.Float64frombits(0x7ff8000000000000))},
+	}
+	for _, c := range cases {
+		ret, err := c.fn(c.a, c.b)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, ret, c.name)
+	}
+}
+
+func TestFloatCopysign(t *testing.T) {
+	negNaN32 := math.Float32frombits(0xffc00001)
+	cases := []struct {
+		name     string
+		fn       func(a, b Value) (Value, error)
+		a, b     Value
+		expected Value
+	}{
+		{"f32.copysign(1.5, -1)", f32Copysign, ValueFromF32(1.5), ValueFromF32(-1), ValueFromF32(-1.5)},
+		{"f32.copysign(-1.5, 1)", f32Copysign, ValueFromF32(-1.5), ValueFromF32(1), ValueFromF32(1.5)},
+		{"f32.copysign(2, -0)", f32Copysign, ValueFromF32(2), ValueFromF32(float32(math.Copysign(0, -1))), ValueFromF32(-2)},
+		// the payload of a NaN is kept
+		{"f32.copysign(-nan:0x400001, 1)", f32Copysign, ValueFromF32(negNaN32), ValueFromF32(1), ValueFromF32(math.Float32frombits(0x7fc00001))},
+		{"f64.copysign(1.5, -1)", f64Copysign, ValueFromF64(1.5), ValueFromF64(-1), ValueFromF64(-1.5)},
+	}
+	for _, c := range cases {
+		ret, err := c.fn(c.a, c.b)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, ret, c.name)
+	}
+}
+
+func TestFloatRelNaNAndZero(t *testing.T) {
+	var funcs strings.Builder
+	for _, typ := range []string{"f32", "f64"} {
+		for _, op := range []string{"eq", "ne", "lt", "gt", "le", "ge"} {
+			fmt.Fprintf(&funcs, `
+				(func (export "%[1]s.%[2]s") (param %[1]s %[1]s) (result i32)
+					local.get 0
+					local.get 1
+					%[1]s.%[2]s
+				)`, typ, op)
+		}
+	}
+	i := newInterpreterFromWat(t, "(module "+funcs.String()+")")
+
+	nan, negZero := math.NaN(), math.Copysign(0, -1)
+	// expected results of eq, ne, lt, gt, le, ge
+	cases := []struct {
+		a, b float64
+		want [6]int32
+	}{
+		// every comparison with a NaN is false, except ne
+		{nan, 1, [6]int32{0, 1, 0, 0, 0, 0}},
+		{1, nan, [6]int32{0, 1, 0, 0, 0, 0}},
+		{nan, nan, [6]int32{0, 1, 0, 0, 0, 0}},
+		{nan, math.Inf(1), [6]int32{0, 1, 0, 0, 0, 0}},
+		{math.Inf(-1), nan, [6]int32{0, 1, 0, 0, 0, 0}},
+		{nan, 0, [6]int32{0, 1, 0, 0, 0, 0}},
+		{-nan, -nan, [6]int32{0, 1, 0, 0, 0, 0}},
+		// zeros are equal whatever their sign
+		{0, negZero, [6]int32{1, 0, 0, 0, 1, 1}},
+		{negZero, 0, [6]int32{1, 0, 0, 0, 1, 1}},
+		{negZero, negZero, [6]int32{1, 0, 0, 0, 1, 1}},
+		{negZero, 1, [6]int32{0, 1, 1, 0, 1, 0}},
+		{math.Inf(-1), math.Inf(1), [6]int32{0, 1, 1, 0, 1, 0}},
+	}
+	for _, c := range cases {
+		for x, op := range []string{"eq", "ne", "lt", "gt", "le", "ge"} {
+			ret, err := invokeExport(t, &i, "f32."+op, ValueFromF32(float32(c.a)), ValueFromF32(float32(c.b)))
+			assert.NoError(t, err)
+			assert.Equal(t, []Value{ValueFromI32(c.want[x])}, ret, "f32.%s %v %v", op, c.a, c.b)
+
+			ret, err = invokeExport(t, &i, "f64."+op, ValueFromF64(c.a), ValueFromF64(c.b))
+			assert.NoError(t, err)
+			assert.Equal(t, []Value{ValueFromI32(c.want[x])}, ret, "f64.%s %v %v", op, c.a, c.b)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "add") (param f32) (result f32)
+				local.get 0
+				f32.const 1
+				f32.add
+			)
+			(func (export "demote") (param f64) (result f32)
+				local.get 0
+				f32.demote_f64
+			)
+			(func (export "promote") (param f32) (result f64)
+				local.get 0
+				f64.promote_f32
+			)
+			(func (export "lanes") (param v128) (result v128)
+				local.get 0
+				v128.const f32x4 2 2 2 2
+				f32x4.mul
+			)
+		)
+	`)
+	payload32 := ValueFromF32(math.Float32frombits(0xff800001))
+	payload64 := ValueFromF64(math.Float64frombits(0xfff0000000000001))
+	lanes := f32x4(1, payload32.F32(), 3, 4)
+	run := func() (add, demote uint32, promote uint64) {
+		ret, err := invokeExport(t, &i, "add", payload32)
+		assert.NoError(t, err)
+		add = math.Float32bits(ret[0].F32())
+		ret, err = invokeExport(t, &i, "demote", payload64)
+		assert.NoError(t, err)
+		demote = math.Float32bits(ret[0].F32())
+		ret, err = invokeExport(t, &i, "promote", payload32)
+		assert.NoError(t, err)
+		promote = math.Float64bits(ret[0].F64())
+		return
+	}
+
+	ret, err := invokeExport(t, &i, "demote", ValueFromF64(1.5))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromF32(1.5)}, ret)
+	ret, err = invokeExport(t, &i, "promote", ValueFromF32(-0.25))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromF64(-0.25)}, ret)
+
+	// without it, the sign and payload of the NaN operands are up to the host
+	add, _, _ := run()
+	assert.NotEqual(t, canonicalNaN32, add)
+
+	i.SetDeterministic(true)
+	for n := 0; n < 2; n++ {
+		add, demote, promote := run()
+		assert.Equal(t, canonicalNaN32, add)
+		assert.Equal(t, canonicalNaN32, demote)
+		assert.Equal(t, canonicalNaN64, promote)
+	}
+	// only the NaN lane changes
+	ret, err = invokeExport(t, &i, "lanes", lanes)
+	assert.NoError(t, err)
+	assert.True(t, f32x4(2, math.Float32frombits(canonicalNaN32), 6, 8).Equal(ret[0]), "%x", ret[0].V128())
+	// the setting survives Reset
+	assert.NoError(t, i.Reset())
+	add, _, _ = run()
+	assert.Equal(t, canonicalNaN32, add)
+}
@@ -1 +1,40 @@
 package wasm_go
+
+// https://webassembly.github.io/spec/core/exec/instructions.html#reference-instructions
+type opRefNull struct {
+	refType type_
+}
+
+func (o *opRefNull) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	valueStack.Push(zeroValue(o.refType))
+	frame.NextStep()
+	return nil
+}
+
+type opRefIsNull struct{}
+
+func (o *opRefIsNull) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	v, _ := valueStack.Pop()
+	r := v.ref()
+	if r.isNull() {
+		valueStack.Push(ValueFromI32(1))
+	} else {
+		valueStack.Push(ValueFromI32(0))
+	}
+	frame.NextStep()
+	return nil
+}
+
+type opRefFunc struct {
+	funcIdx uint32
+}
+
+func (o *opRefFunc) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	addr := frame.mod.funcAddrs[o.funcIdx]
+	valueStack.Push(valueFromRef(FuncRef, ref{addr: int(addr), kind: refFunc}))
+	frame.NextStep()
+	return nil
+}
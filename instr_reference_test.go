@@ -0,0 +1,55 @@
+package wasm_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefInstrs(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func $f)
+			(elem declare func $f)
+			(func (export "null") (result funcref)
+				ref.null func
+			)
+			(func (export "func") (result funcref)
+				ref.func $f
+			)
+			(func (export "null_is_null") (result i32)
+				ref.null func
+				ref.is_null
+			)
+			(func (export "func_is_null") (result i32)
+				ref.func $f
+				ref.is_null
+			)
+			(func (export "local_is_null") (result i32)
+				(local funcref)
+				local.get 0
+				ref.is_null
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "null")
+	assert.NoError(t, err)
+	if assert.Len(t, ret, 1) {
+		assert.Equal(t, FuncRef, ret[0].ValType)
+		assert.Equal(t, ref{kind: refNull}, ret[0].ref())
+	}
+
+	ret, err = invokeExport(t, &i, "func")
+	assert.NoError(t, err)
+	if assert.Len(t, ret, 1) {
+		assert.Equal(t, FuncRef, ret[0].ValType)
+		assert.Equal(t, ref{addr: 0, kind: refFunc}, ret[0].ref())
+	}
+
+	cases := map[string]int32{"null_is_null": 1, "func_is_null": 0, "local_is_null": 1}
+	for name, expected := range cases {
+		ret, err := invokeExport(t, &i, name)
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{ValueFromI32(expected)}, ret, name)
+	}
+}
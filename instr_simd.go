@@ -0,0 +1,103 @@
+package wasm_go
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// https://webassembly.github.io/spec/core/exec/instructions.html#vector-instructions
+// Only v128.load, v128.store, v128.const and the lane-wise add, sub, mul and div are implemented so far.
+
+func v128load(m *memInst, addr uint64, align int32) (Value, error) {
+	lo, hi, err := m.load128(addr, align)
+	return Value{ValType: V128, raw: lo, hi: hi}, err
+}
+
+func v128store(m *memInst, addr uint64, align int32, v Value) error {
+	return m.store128(addr, align, v.raw, v.hi)
+}
+
+// i8x16Lanes applies fn to each pair of i8 lanes.
+func i8x16Lanes(fn func(a, b uint8) uint8) func(a, b Value) (Value, error) {
+	return func(a, b Value) (Value, error) {
+		x, y := a.V128(), b.V128()
+		for l := range x {
+			x[l] = fn(x[l], y[l])
+		}
+		return ValueFromV128(x), nil
+	}
+}
+
+// i16x8Lanes applies fn to each pair of i16 lanes.
+func i16x8Lanes(fn func(a, b uint16) uint16) func(a, b Value) (Value, error) {
+	return func(a, b Value) (Value, error) {
+		x, y := a.V128(), b.V128()
+		for l := 0; l < 16; l += 2 {
+			binary.LittleEndian.PutUint16(x[l:], fn(binary.LittleEndian.Uint16(x[l:]), binary.LittleEndian.Uint16(y[l:])))
+		}
+		return ValueFromV128(x), nil
+	}
+}
+
+// i32x4Lanes applies fn to each pair of i32 lanes.
+func i32x4Lanes(fn func(a, b uint32) uint32) func(a, b Value) (Value, error) {
+	return func(a, b Value) (Value, error) {
+		x, y := a.V128(), b.V128()
+		for l := 0; l < 16; l += 4 {
+			binary.LittleEndian.PutUint32(x[l:], fn(binary.LittleEndian.Uint32(x[l:]), binary.LittleEndian.Uint32(y[l:])))
+		}
+		return ValueFromV128(x), nil
+	}
+}
+
+// f32x4Lanes applies fn to each pair of f32 lanes.
+func f32x4Lanes(fn func(a, b float32) float32) func(a, b Value) (Value, error) {
+	return i32x4Lanes(func(a, b uint32) uint32 {
+		return math.Float32bits(fn(math.Float32frombits(a), math.Float32frombits(b)))
+	})
+}
+
+// canonicalizeF32Lanes replaces the NaN lanes of an f32x4 by the positive canonical NaN.
+func canonicalizeF32Lanes(v Value) Value {
+	b := v.V128()
+	for l := 0; l < 16; l += 4 {
+		if f := math.Float32frombits(binary.LittleEndian.Uint32(b[l:])); f != f {
+			binary.LittleEndian.PutUint32(b[l:], canonicalNaN32)
+		}
+	}
+	return ValueFromV128(b)
+}
+
+var (
+	i8x16Add = i8x16Lanes(func(a, b uint8) uint8 { return a + b })
+	i8x16Sub = i8x16Lanes(func(a, b uint8) uint8 { return a - b })
+
+	i16x8Add = i16x8Lanes(func(a, b uint16) uint16 { return a + b })
+	i16x8Sub = i16x8Lanes(func(a, b uint16) uint16 { return a - b })
+	i16x8Mul = i16x8Lanes(func(a, b uint16) uint16 { return a * b })
+
+	i32x4Add = i32x4Lanes(func(a, b uint32) uint32 { return a + b })
+	i32x4Sub = i32x4Lanes(func(a, b uint32) uint32 { return a - b })
+	i32x4Mul = i32x4Lanes(func(a, b uint32) uint32 { return a * b })
+
+	f32x4Add = f32x4Lanes(func(a, b float32) float32 { return a + b })
+	f32x4Sub = f32x4Lanes(func(a, b float32) float32 { return a - b })
+	f32x4Mul = f32x4Lanes(func(a, b float32) float32 { return a * b })
+	f32x4Div = f32x4Lanes(func(a, b float32) float32 { return a / b })
+)
+
+// lane-wise binary instrs by their 0xFD kind, i8x16 has no mul
+var simdBinFns = map[uint32]func(a, b Value) (Value, error){
+	110: i8x16Add,
+	113: i8x16Sub,
+	142: i16x8Add,
+	145: i16x8Sub,
+	149: i16x8Mul,
+	174: i32x4Add,
+	177: i32x4Sub,
+	181: i32x4Mul,
+	228: f32x4Add,
+	229: f32x4Sub,
+	230: f32x4Mul,
+	231: f32x4Div,
+}
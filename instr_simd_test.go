@@ -0,0 +1,160 @@
+package wasm_go
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func i32x4(lanes ...uint32) Value {
+	var b [16]byte
+	for x, lane := range lanes {
+		binary.LittleEndian.PutUint32(b[x*4:], lane)
+	}
+	return ValueFromV128(b)
+}
+
+func TestSIMD(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(func (export "add") (result v128)
+				v128.const i32x4 1 2 3 4
+				v128.const i32x4 10 20 30 0xffffffff
+				i32x4.add
+			)
+			(func (export "copy") (param i32 i32)
+				local.get 1
+				local.get 0
+				v128.load offset=16
+				v128.store
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "add")
+	assert.NoError(t, err)
+	if assert.Len(t, ret, 1) {
+		// the last lane wraps around
+		assert.True(t, i32x4(11, 22, 33, 3).Equal(ret[0]))
+	}
+
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	assert.NoError(t, i.WriteMemory(16, data))
+	_, err = invokeExport(t, &i, "copy", ValueFromI32(0), ValueFromI32(100))
+	assert.NoError(t, err)
+	mem, err := i.ReadMemory(100, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, data, mem)
+
+	// the load ends one byte past the memory
+	_, err = invokeExport(t, &i, "copy", ValueFromI32(int32(PAGE_SIZE-31)), ValueFromI32(0))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	// a store out of bounds writes nothing
+	_, err = invokeExport(t, &i, "copy", ValueFromI32(0), ValueFromI32(int32(PAGE_SIZE-8)))
+	assert.ErrorIs(t, err, errOutOfBounds)
+	mem, err = i.ReadMemory(uint32(PAGE_SIZE-8), 8)
+	assert.NoError(t, err)
+	assert.Equal(t, make([]byte, 8), mem)
+}
+
+func TestValueV128(t *testing.T) {
+	v := i32x4(1, 2, 3, 4)
+	assert.Equal(t, V128, v.ValType)
+	b := v.V128()
+	assert.Equal(t, uint32(3), binary.LittleEndian.Uint32(b[8:]))
+	assert.True(t, v.Equal(i32x4(1, 2, 3, 4)))
+	// lanes in the high half count
+	assert.False(t, v.Equal(i32x4(1, 2, 3, 5)))
+}
+
+func f32x4(lanes ...float32) Value {
+	var b [16]byte
+	for x, lane := range lanes {
+		binary.LittleEndian.PutUint32(b[x*4:], math.Float32bits(lane))
+	}
+	return ValueFromV128(b)
+}
+
+func TestSIMDLanes(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "i32x4.add") (param v128 v128) (result v128)
+				local.get 0
+				local.get 1
+				i32x4.add
+			)
+			(func (export "f32x4.mul") (param v128 v128) (result v128)
+				local.get 0
+				local.get 1
+				f32x4.mul
+			)
+			(func (export "i32x4.sub") (result v128)
+				v128.const i32x4 0 5 0 0
+				v128.const i32x4 1 2 0 0
+				i32x4.sub
+			)
+			(func (export "i16x8.mul") (result v128)
+				v128.const i16x8 2 0x100 3 0 0 0 0 0xffff
+				v128.const i16x8 3 0x100 0 0 0 0 0 2
+				i16x8.mul
+			)
+			(func (export "i8x16.sub") (result v128)
+				v128.const i8x16 0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15
+				v128.const i8x16 1 1 1 1 1 1 1 1 1 1 1 1 1 1 1 1
+				i8x16.sub
+			)
+			(func (export "f32x4.div") (result v128)
+				v128.const f32x4 1 -1 0 6
+				v128.const f32x4 0 0 0 3
+				f32x4.div
+			)
+		)
+	`)
+
+	// each lane is changed on its own, with a carry that would cross into the next lane
+	for lane := 0; lane < 4; lane++ {
+		a, b := make([]uint32, 4), make([]uint32, 4)
+		want := make([]uint32, 4)
+		a[lane], b[lane], want[lane] = 0xffffffff, 2, 1
+		ret, err := invokeExport(t, &i, "i32x4.add", i32x4(a...), i32x4(b...))
+		assert.NoError(t, err)
+		if assert.Len(t, ret, 1) {
+			assert.True(t, i32x4(want...).Equal(ret[0]), "lane %d: %v", lane, ret[0].V128())
+		}
+	}
+	for lane := 0; lane < 4; lane++ {
+		a, b := []float32{1, 1, 1, 1}, []float32{1, 1, 1, 1}
+		want := []float32{1, 1, 1, 1}
+		a[lane], b[lane], want[lane] = 1.5, -4, -6
+		ret, err := invokeExport(t, &i, "f32x4.mul", f32x4(a...), f32x4(b...))
+		assert.NoError(t, err)
+		if assert.Len(t, ret, 1) {
+			assert.True(t, f32x4(want...).Equal(ret[0]), "lane %d: %v", lane, ret[0].V128())
+		}
+	}
+
+	ret, err := invokeExport(t, &i, "i32x4.sub")
+	assert.NoError(t, err)
+	assert.True(t, i32x4(0xffffffff, 3, 0, 0).Equal(ret[0]))
+
+	ret, err = invokeExport(t, &i, "i16x8.mul")
+	assert.NoError(t, err)
+	// lanes wrap around without touching their neighbours
+	b := ret[0].V128()
+	assert.Equal(t, []byte{6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xfe, 0xff}, b[:])
+
+	ret, err = invokeExport(t, &i, "i8x16.sub")
+	assert.NoError(t, err)
+	b = ret[0].V128()
+	assert.Equal(t, []byte{0xff, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}, b[:])
+
+	ret, err = invokeExport(t, &i, "f32x4.div")
+	assert.NoError(t, err)
+	b = ret[0].V128()
+	assert.Equal(t, float32(math.Inf(1)), math.Float32frombits(binary.LittleEndian.Uint32(b[0:])))
+	assert.Equal(t, float32(math.Inf(-1)), math.Float32frombits(binary.LittleEndian.Uint32(b[4:])))
+	assert.True(t, math.IsNaN(float64(math.Float32frombits(binary.LittleEndian.Uint32(b[8:])))))
+	assert.Equal(t, float32(2), math.Float32frombits(binary.LittleEndian.Uint32(b[12:])))
+}
@@ -0,0 +1,108 @@
+package wasm_go
+
+var errOutOfBoundsTable = newTrap(TrapCodeOutOfBoundsTable, "out of bounds table access")
+
+// https://webassembly.github.io/spec/core/exec/instructions.html#table-instructions
+type opTableGet struct {
+	tableIdx uint32
+}
+
+func (o *opTableGet) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	table := &store.tables[frame.mod.tableAddrs[o.tableIdx]]
+	idx, _ := valueStack.Pop()
+	i := uint32(idx.I32())
+	if i >= uint32(len(table.elems)) {
+		return errOutOfBoundsTable
+	}
+	valueStack.Push(valueFromRef(table.elemType, table.elems[i]))
+	frame.NextStep()
+	return nil
+}
+
+type opTableSet struct {
+	tableIdx uint32
+}
+
+func (o *opTableSet) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	table := &store.tables[frame.mod.tableAddrs[o.tableIdx]]
+	v, _ := valueStack.Pop()
+	idx, _ := valueStack.Pop()
+	i := uint32(idx.I32())
+	if i >= uint32(len(table.elems)) {
+		return errOutOfBoundsTable
+	}
+	table.elems[i] = v.ref()
+	frame.NextStep()
+	return nil
+}
+
+type opTableSize struct {
+	tableIdx uint32
+}
+
+func (o *opTableSize) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	table := &store.tables[frame.mod.tableAddrs[o.tableIdx]]
+	valueStack.Push(ValueFromI32(int32(len(table.elems))))
+	frame.NextStep()
+	return nil
+}
+
+type opTableGrow struct {
+	tableIdx uint32
+}
+
+func (o *opTableGrow) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	table := &store.tables[frame.mod.tableAddrs[o.tableIdx]]
+	n, _ := valueStack.Pop()
+	init, _ := valueStack.Pop()
+
+	size := len(table.elems)
+	if table.grow(uint32(n.I32()), init.ref()) {
+		valueStack.Push(ValueFromI32(int32(size)))
+	} else {
+		valueStack.Push(ValueFromI32(-1))
+	}
+	frame.NextStep()
+	return nil
+}
+
+// grow appends n copies of init, it reports false when the table would exceed its max,
+// or maxTableElems when it has none.
+func (t *tableInst) grow(n uint32, init ref) bool {
+	size := uint64(len(t.elems)) + uint64(n)
+	if size > maxTableElems || (t.limits.Max >= 0 && size > uint64(t.limits.Max)) {
+		return false
+	}
+	for i := uint32(0); i < n; i++ {
+		t.elems = append(t.elems, init)
+	}
+	return true
+}
+
+type opTableFill struct {
+	tableIdx uint32
+}
+
+func (o *opTableFill) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
+	frame, _ := frameStack.Top()
+	table := &store.tables[frame.mod.tableAddrs[o.tableIdx]]
+	n, _ := valueStack.Pop()
+	v, _ := valueStack.Pop()
+	idx, _ := valueStack.Pop()
+
+	start := uint64(uint32(idx.I32()))
+	end := start + uint64(uint32(n.I32()))
+	if end > uint64(len(table.elems)) {
+		return errOutOfBoundsTable
+	}
+	r := v.ref()
+	for i := start; i < end; i++ {
+		table.elems[i] = r
+	}
+	frame.NextStep()
+	return nil
+}
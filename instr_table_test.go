@@ -0,0 +1,201 @@
+package wasm_go
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableGetSet(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(table 2 funcref)
+			(func $f (result i32)
+				i32.const 7
+			)
+			(elem declare func $f)
+			(func (export "get_is_null") (param i32) (result i32)
+				local.get 0
+				table.get 0
+				ref.is_null
+			)
+			(func (export "set") (param i32)
+				local.get 0
+				ref.func $f
+				table.set 0
+			)
+			(func (export "call") (param i32) (result i32)
+				local.get 0
+				call_indirect (result i32)
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "get_is_null", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1)}, ret)
+
+	_, err = invokeExport(t, &i, "set", ValueFromI32(1))
+	assert.NoError(t, err)
+	ret, err = invokeExport(t, &i, "get_is_null", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(0)}, ret)
+	ret, err = invokeExport(t, &i, "call", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(7)}, ret)
+
+	_, err = invokeExport(t, &i, "get_is_null", ValueFromI32(2))
+	assert.EqualError(t, err, "out of bounds table access")
+	_, err = invokeExport(t, &i, "set", ValueFromI32(-1))
+	assert.EqualError(t, err, "out of bounds table access")
+}
+
+func TestTableGrowSizeFill(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(table 1 3 funcref)
+			(func $f)
+			(elem declare func $f)
+			(func (export "size") (result i32)
+				table.size 0
+			)
+			(func (export "grow") (param i32) (result i32)
+				ref.null func
+				local.get 0
+				table.grow 0
+			)
+			(func (export "fill") (param i32 i32)
+				local.get 0
+				ref.func $f
+				local.get 1
+				table.fill 0
+			)
+			(func (export "is_null") (param i32) (result i32)
+				local.get 0
+				table.get 0
+				ref.is_null
+			)
+		)
+	`)
+	expectI32 := func(name string, expected int32, args ...Value) {
+		ret, err := invokeExport(t, &i, name, args...)
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{ValueFromI32(expected)}, ret, name)
+	}
+	expectI32("size", 1)
+	expectI32("grow", 1, ValueFromI32(2))
+	expectI32("size", 3)
+	// past the max of 3
+	expectI32("grow", -1, ValueFromI32(1))
+	expectI32("size", 3)
+
+	_, err := invokeExport(t, &i, "fill", ValueFromI32(1), ValueFromI32(2))
+	assert.NoError(t, err)
+	expectI32("is_null", 1, ValueFromI32(0))
+	expectI32("is_null", 0, ValueFromI32(1))
+	expectI32("is_null", 0, ValueFromI32(2))
+
+	_, err = invokeExport(t, &i, "fill", ValueFromI32(2), ValueFromI32(2))
+	assert.EqualError(t, err, "out of bounds table access")
+}
+
+func TestTableGrowWithoutMax(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(table 1 funcref)
+			(func (export "grow") (param i32) (result i32)
+				ref.null func
+				local.get 0
+				table.grow 0
+			)
+		)
+	`)
+	expectGrow := func(n, expected int32) {
+		ret, err := invokeExport(t, &i, "grow", ValueFromI32(n))
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{ValueFromI32(expected)}, ret, "grow %d", n)
+	}
+	// fails without allocating past the implementation cap
+	expectGrow(math.MaxInt32, -1)
+	expectGrow(maxTableElems, -1)
+	expectGrow(2, 1)
+}
+
+func TestTableHostAccess(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(import "env" "seven" (func (result i32)))
+			(table 2 funcref)
+			(table 1 externref)
+			(func $nine (result i32)
+				i32.const 9
+			)
+			(func (export "dispatch") (param i32) (result i32)
+				local.get 0
+				call_indirect (result i32)
+			)
+		)
+	`)
+	assert.NoError(t, i.RegisterFunc("env", "seven", nil, []ValType{I32}, func(args []Value) ([]Value, error) {
+		return []Value{ValueFromI32(7)}, nil
+	}))
+
+	r, err := i.TableGet(0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Ref{Null: true}, r)
+	_, err = invokeExport(t, &i, "dispatch", ValueFromI32(1))
+	assert.ErrorIs(t, err, errUninitializedElement)
+
+	// funcs are named by their index, the imported one comes first
+	assert.NoError(t, i.TableSet(0, 1, Ref{Index: 1}))
+	assert.NoError(t, i.TableSet(0, 0, Ref{Index: 0}))
+	ret, err := invokeExport(t, &i, "dispatch", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(9)}, ret)
+	ret, err = invokeExport(t, &i, "dispatch", ValueFromI32(0))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(7)}, ret)
+	r, err = i.TableGet(0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Ref{Index: 1}, r)
+
+	assert.NoError(t, i.TableSet(0, 1, Ref{Null: true}))
+	_, err = invokeExport(t, &i, "dispatch", ValueFromI32(1))
+	assert.ErrorIs(t, err, errUninitializedElement)
+
+	assert.NoError(t, i.TableSet(1, 0, Ref{Index: 42}))
+	r, err = i.TableGet(1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Ref{Index: 42}, r)
+
+	_, err = i.TableGet(0, 2)
+	assert.ErrorIs(t, err, errOutOfBoundsTable)
+	assert.ErrorIs(t, i.TableSet(1, 1, Ref{Null: true}), errOutOfBoundsTable)
+	_, err = i.TableGet(2, 0)
+	assert.EqualError(t, err, "unknown table 2")
+	assert.EqualError(t, i.TableSet(0, 0, Ref{Index: 5}), "unknown func 5")
+}
+
+func TestCallIndirectExternRef(t *testing.T) {
+	wat := `
+		(module
+			(table 1 funcref)
+			(table $host 1 externref)
+			(func (export "dispatch") (param i32) (result i32)
+				local.get 0
+				call_indirect $host (result i32)
+			)
+		)
+	`
+	wasm, err := wat2wasm(wat)
+	assert.NoError(t, err)
+	_, err = NewInterpreter(wasm)
+	assert.EqualError(t, err, "func 0: instr 1: indirect call through table 1 of externref")
+
+	// an externref set by the host must trap rather than be taken for a func addr
+	i := Interpreter{module: parseWat(t, wat)}
+	assert.NoError(t, i.instantiate(nil))
+	assert.NoError(t, i.TableSet(1, 0, Ref{Index: 42}))
+	_, err = invokeExport(t, &i, "dispatch", ValueFromI32(0))
+	assert.ErrorIs(t, err, errIndirectCallTypeMismatch)
+}
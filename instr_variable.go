@@ -8,10 +8,10 @@ type opLocalGet struct {
 
 func (o *opLocalGet) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
-	v, ok := valueStack.Get(frame.sp, o.localIdx)
-	if !ok {
+	if !frame.hasLocal(o.localIdx) {
 		return fmt.Errorf("local variable[%d] not found", o.localIdx)
 	}
+	v, _ := valueStack.Get(frame.sp, o.localIdx)
 	valueStack.Push(*v)
 
 	frame.NextStep()
@@ -24,6 +24,9 @@ type opLocalSet struct {
 
 func (o *opLocalSet) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
+	if !frame.hasLocal(o.localIdx) {
+		return fmt.Errorf("local variable[%d] not found", o.localIdx)
+	}
 	v, _ := valueStack.Pop()
 	valueStack.Set(frame.sp, o.localIdx, v)
 	frame.NextStep()
@@ -36,6 +39,9 @@ type opLocalTee struct {
 
 func (o *opLocalTee) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
+	if !frame.hasLocal(o.localIdx) {
+		return fmt.Errorf("local variable[%d] not found", o.localIdx)
+	}
 	v, _ := valueStack.Top()
 	valueStack.Set(frame.sp, o.localIdx, *v)
 	frame.NextStep()
@@ -62,16 +68,16 @@ type opGlobalSet struct {
 func (o *opGlobalSet) exec(frameStack *stack[frame], valueStack *stack[Value], store *store) error {
 	frame, _ := frameStack.Top()
 	globalAddr := frame.mod.globalAddrs[o.globalIdx]
-	global := store.globals[globalAddr]
+	global := &store.globals[globalAddr]
 	if global.globalType.mut == const_ {
 		return fmt.Errorf("global[%d] is a const value", o.globalIdx)
 	}
-	v, _ := valueStack.Top()
+	v, _ := valueStack.Pop()
 	if global.globalType.valueType != v.ValType {
 		return fmt.Errorf("global[%d] and value types do not match ", o.globalIdx)
 	}
 
-	global.value = *v
+	global.value = v
 	frame.NextStep()
 	return nil
 }
@@ -0,0 +1,80 @@
+package wasm_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobalSetPersists(t *testing.T) {
+	mod := moduleInst{globalAddrs: []uint32{0}}
+	s := store{globals: []globalInst{{
+		globalType: globalType{valueType: I32, mut: var_},
+		value:      ValueFromI32(1),
+	}}}
+	frameStack := stack[frame]{}
+	frameStack.Push(frame{mod: &mod})
+	valueStack := stack[Value]{}
+
+	valueStack.Push(ValueFromI32(42))
+	assert.NoError(t, (&opGlobalSet{globalIdx: 0}).exec(&frameStack, &valueStack, &s))
+	assert.Equal(t, 0, valueStack.Len())
+	assert.NoError(t, (&opGlobalGet{globalIdx: 0}).exec(&frameStack, &valueStack, &s))
+	v, _ := valueStack.Pop()
+	assert.Equal(t, ValueFromI32(42), v)
+}
+
+func TestLocalTeeAndGlobals(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(global (mut i32) (i32.const 10))
+			(func (export "tee") (param i32) (result i32)
+				(local i32)
+				local.get 0
+				local.tee 1
+				local.get 1
+				i32.add
+			)
+			(func (export "incr") (result i32)
+				global.get 0
+				i32.const 1
+				i32.add
+				global.set 0
+				global.get 0
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "tee", ValueFromI32(21))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+
+	ret, err = invokeExport(t, &i, "incr")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(11)}, ret)
+	ret, err = invokeExport(t, &i, "incr")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(12)}, ret)
+}
+
+func TestLocalOutOfRange(t *testing.T) {
+	frameStack := stack[frame]{}
+	frameStack.Push(frame{sp: 0, locals: 1})
+	valueStack := stack[Value]{}
+	// one local, then the operand to store
+	valueStack.Push(ValueFromI32(1))
+	valueStack.Push(ValueFromI32(2))
+
+	// the operand above the locals isn't a local
+	assert.EqualError(t, (&opLocalTee{localIdx: 1}).exec(&frameStack, &valueStack, &store{}), "local variable[1] not found")
+	assert.EqualError(t, (&opLocalGet{localIdx: 1}).exec(&frameStack, &valueStack, &store{}), "local variable[1] not found")
+	assert.EqualError(t, (&opLocalSet{localIdx: 1}).exec(&frameStack, &valueStack, &store{}), "local variable[1] not found")
+	assert.EqualError(t, (&opLocalSet{localIdx: 5}).exec(&frameStack, &valueStack, &store{}), "local variable[5] not found")
+	assert.EqualError(t, (&opLocalTee{localIdx: -1}).exec(&frameStack, &valueStack, &store{}), "local variable[-1] not found")
+	assert.EqualError(t, (&opLocalGet{localIdx: -1}).exec(&frameStack, &valueStack, &store{}), "local variable[-1] not found")
+	assert.Equal(t, []Value{ValueFromI32(1), ValueFromI32(2)}, valueStack.inner)
+
+	assert.NoError(t, (&opLocalSet{localIdx: 0}).exec(&frameStack, &valueStack, &store{}))
+	v, _ := valueStack.Top()
+	assert.Equal(t, ValueFromI32(2), *v)
+	assert.Equal(t, 1, valueStack.Len())
+}
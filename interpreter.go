@@ -1,76 +1,245 @@
 package wasm_go
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"io"
+)
 
 type Interpreter struct {
 	frameStack stack[frame]
 	valueStack stack[Value]
 	store      store
 	mod        moduleInst
+	names      names
+	// called before each instruction, nil when tracing is off
+	traceHook func(pc int, op Opcode)
+	// executions of each op, nil when profiling is off
+	profile map[instrOp]uint64
+	// set until the start function ran, it waits for RegisterFunc to bind every imported func
+	startPending bool
+	// kept to instantiate the module again on Reset
+	module module
+	linker *Linker
 }
 
 func NewInterpreter(bytes []byte) (Interpreter, error) {
 	p := newParser(bytes)
 	m, err := p.parse()
-	i := Interpreter{}
 	if err != nil {
-		return i, err
+		return Interpreter{}, err
 	}
+	return newInterpreter(m, nil)
+}
 
-	store, modInst, err := newStoreAndModuleInst(&i.valueStack, m)
+// NewInterpreterFromReader is like NewInterpreter, but reads the module from r
+// one section at a time instead of requiring it all in memory.
+func NewInterpreterFromReader(r io.Reader) (Interpreter, error) {
+	m, err := parseReader(r)
 	if err != nil {
+		return Interpreter{}, err
+	}
+	return newInterpreter(m, nil)
+}
+
+// newInterpreter instantiates m, resolving its imports against l unless it's nil.
+func newInterpreter(m module, l *Linker) (Interpreter, error) {
+	i := Interpreter{module: m, linker: l}
+	if err := m.validate(); err != nil {
 		return i, err
 	}
+	return i, i.instantiate(nil)
+}
+
+// instantiate creates a fresh store for i.module and runs its start function.
+// Settings and registered host functions are carried over from prev unless it's nil.
+func (i *Interpreter) instantiate(prev *store) error {
+	store, modInst, err := newStoreAndModuleInst(&i.valueStack, i.module, i.linker)
+	if err != nil {
+		return err
+	}
+	if prev != nil {
+		store.canonicalNaN = prev.canonicalNaN
+		store.maxCallDepth = prev.maxCallDepth
+		// imported functions come first in both stores
+		for x := range store.funcs {
+			if store.funcs[x].kind == externalFunc {
+				store.funcs[x].externalFunc.fn = prev.funcs[x].externalFunc.fn
+				store.funcs[x].externalFunc.memFn = prev.funcs[x].externalFunc.memFn
+			}
+		}
+	}
 	i.store = store
 	i.mod = modInst
-	return i, nil
+	i.names = i.module.names
+
+	i.startPending = i.module.start != nil
+	return i.runStart()
 }
 
-func (i *Interpreter) Execute() error {
-	for !i.frameStack.isEmpty() {
-		frame, _ := i.frameStack.Peek(0)
-		instr := frame.insts[frame.pc]
-		if err := instr.exec(&i.frameStack, &i.valueStack, &i.store); err != nil {
-			return err
+// runStart runs the pending start function once every imported func is bound, a Linker
+// binds them all on instantiation, otherwise the last RegisterFunc call does.
+// https://webassembly.github.io/spec/core/exec/modules.html#instantiation
+func (i *Interpreter) runStart() error {
+	if !i.startPending {
+		return nil
+	}
+	for _, f := range i.store.funcs {
+		if f.kind == externalFunc && f.externalFunc.fn == nil && f.externalFunc.memFn == nil {
+			return nil
 		}
 	}
+	fnAddr := i.mod.funcAddrs[i.module.start.funcIdx]
+	err := call(&i.frameStack, &i.valueStack, &i.store, &i.store.funcs[fnAddr])
+	if err == nil {
+		err = i.Execute()
+	}
+	if err != nil {
+		// a failed start leaves nothing behind on the stacks and stays pending
+		i.frameStack = stack[frame]{}
+		i.valueStack = stack[Value]{}
+		return fmt.Errorf("start function: %w", err)
+	}
+	i.startPending = false
 	return nil
 }
 
-func (i *Interpreter) GetFunc(fnName string) (func(args []Value) ([]Value, error), error) {
-	fnIdx := -1
-	for _, export := range i.mod.exports {
-		if export.name == fnName {
-			if export.value.kind != exportImportKindFunc {
-				return nil, fmt.Errorf("%s not a func", fnName)
+// Reset instantiates the module again without parsing it, globals, memories and tables
+// are back to their initial state and the start function runs again.
+// Host functions registered with RegisterFunc and the interpreter settings are kept.
+func (i *Interpreter) Reset() error {
+	prev := i.store
+	i.frameStack = stack[frame]{}
+	i.valueStack = stack[Value]{}
+	return i.instantiate(&prev)
+}
+
+// number of instructions executed between two checks of the context
+const ctxCheckInterval = 1024
+
+// number of nested calls allowed unless changed with SetMaxCallDepth
+const defaultMaxCallDepth = 1024
+
+func (i *Interpreter) Execute() error {
+	return i.ExecuteWithContext(context.Background())
+}
+
+// ExecuteWithContext runs like Execute, but stops once ctx is done.
+func (i *Interpreter) ExecuteWithContext(ctx context.Context) error {
+	// the context can never be cancelled, skip the checks
+	done := ctx.Done() != nil
+	for n := 0; !i.frameStack.isEmpty(); n++ {
+		if done && n%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("execution cancelled: %w", err)
 			}
-			fnIdx = int(export.value.idx)
-			break
 		}
+		if _, err := i.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step executes one instruction of the top frame and reports whether
+// the frame stack is empty afterwards.
+func (i *Interpreter) Step() (done bool, err error) {
+	frame, ok := i.frameStack.Peek(0)
+	if !ok {
+		return true, nil
+	}
+	instr := frame.insts[frame.pc]
+	if i.traceHook != nil {
+		i.traceHook(frame.pc, frame.ops[frame.pc].code)
+	}
+	if i.profile != nil {
+		i.profile[frame.ops[frame.pc]]++
 	}
-	if fnIdx < 0 {
-		return nil, fmt.Errorf("can't find %s func", fnName)
+	if err := instr.exec(&i.frameStack, &i.valueStack, &i.store); err != nil {
+		return false, err
 	}
+	return i.frameStack.isEmpty(), nil
+}
 
-	fnAddr := i.mod.funcAddrs[fnIdx]
-	fn := i.store.funcs[fnAddr]
-	if fn.kind == externalFunc {
-		// TODO: external func
+// SetTraceHook sets a hook called with the pc and opcode of the instruction about to run,
+// prefixed instructions pass their prefix like OpcodeSIMD. StackDepth gives the value
+// stack depth at that point. A nil hook turns tracing off.
+func (i *Interpreter) SetTraceHook(hook func(pc int, op Opcode)) {
+	i.traceHook = hook
+}
+
+// CurrentPC returns the pc of the top frame, or -1 when nothing is running.
+func (i *Interpreter) CurrentPC() int {
+	frame, ok := i.frameStack.Peek(0)
+	if !ok {
+		return -1
 	}
+	return frame.pc
+}
 
+// StackDepth returns the number of values on the value stack.
+func (i *Interpreter) StackDepth() int {
+	return i.valueStack.Len()
+}
+
+func (i *Interpreter) GetFunc(fnName string) (func(args []Value) ([]Value, error), error) {
+	fn, err := i.GetFuncWithContext(fnName)
+	if err != nil {
+		return nil, err
+	}
 	return func(args []Value) ([]Value, error) {
-		i.frameStack.Push(frame{
-			pc:    0,
-			sp:    i.valueStack.Len(),
-			insts: fn.internalFunc.code.body,
-			mod:   &i.mod,
-		})
+		return fn(context.Background(), args)
+	}, nil
+}
+
+// GetFuncWithContext is like GetFunc, but the returned func stops once ctx is done.
+func (i *Interpreter) GetFuncWithContext(fnName string) (func(ctx context.Context, args []Value) ([]Value, error), error) {
+	fnIdx, err := i.exportIdx(fnName, exportImportKindFunc)
+	if err != nil {
+		return nil, err
+	}
+	return i.funcCaller(fnIdx, fnName), nil
+}
 
-		for x := len(args) - 1; x >= 0; x-- {
-			i.valueStack.Push(args[x])
+// GetFuncByIndex is like GetFunc, but looks the func up by its index in the func index space,
+// imported funcs come first. The func doesn't need to be exported.
+func (i *Interpreter) GetFuncByIndex(idx uint32) (func(args []Value) ([]Value, error), error) {
+	if int(idx) >= len(i.mod.funcAddrs) {
+		return nil, fmt.Errorf("unknown func %d", idx)
+	}
+	fn := i.funcCaller(idx, fmt.Sprintf("%d", idx))
+	return func(args []Value) ([]Value, error) {
+		return fn(context.Background(), args)
+	}, nil
+}
+
+// funcCaller returns a func calling the func at fnIdx, fnName is only used in the error messages.
+func (i *Interpreter) funcCaller(fnIdx uint32, fnName string) func(ctx context.Context, args []Value) ([]Value, error) {
+	fnAddr := i.mod.funcAddrs[fnIdx]
+	fn := i.store.funcs[fnAddr]
+
+	return func(ctx context.Context, args []Value) ([]Value, error) {
+		// the start function runs before any export is callable
+		if i.startPending {
+			return nil, fmt.Errorf("func %s: the start function didn't run, register every imported func first", fnName)
+		}
+		params := fn.funcType.params
+		if len(args) != len(params) {
+			return nil, fmt.Errorf("func %s expects %d arguments, got %d", fnName, len(params), len(args))
+		}
+		for x, arg := range args {
+			if arg.ValType != params[x] {
+				return nil, fmt.Errorf("func %s argument %d: expected %s, got %s", fnName, x, typeName(params[x]), typeName(arg.ValType))
+			}
+		}
+		for _, arg := range args {
+			i.valueStack.Push(arg)
 		}
 
-		err := i.Execute()
+		err := call(&i.frameStack, &i.valueStack, &i.store, &i.store.funcs[fnAddr])
+		if err == nil {
+			err = i.ExecuteWithContext(ctx)
+		}
 		if err != nil {
 			// cleanup valueStack and frameStack
 			i.frameStack = stack[frame]{}
@@ -78,13 +247,225 @@ func (i *Interpreter) GetFunc(fnName string) (func(args []Value) ([]Value, error
 			return nil, err
 		}
 
+		// the last result is on top of the stack
 		results := make([]Value, len(fn.funcType.results))
-		for x := 0; x < len(fn.funcType.results); x++ {
+		for x := len(results) - 1; x >= 0; x-- {
 			ret, _ := i.valueStack.Pop()
 			results[x] = ret
 		}
 		return results, nil
-	}, nil
+	}
+}
+
+// RegisterFunc binds fn to the function imported from module.name, params and results
+// must match the type of the import.
+func (i *Interpreter) RegisterFunc(module, name string, params, results []ValType, fn HostFunc) error {
+	t := funcType{params: params, results: results}
+	found := false
+	for x := range i.store.funcs {
+		f := &i.store.funcs[x]
+		if f.kind == externalFunc && f.externalFunc.module == module && f.externalFunc.name == name {
+			if !f.funcType.equal(t) {
+				return fmt.Errorf("incompatible import type for %s.%s", module, name)
+			}
+			f.externalFunc.fn = fn
+			f.externalFunc.memFn = nil
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("can't find imported func %s.%s", module, name)
+	}
+	return i.runStart()
+}
+
+// GetGlobal returns the value of the exported global name.
+func (i *Interpreter) GetGlobal(name string) (Value, error) {
+	idx, err := i.exportIdx(name, exportImportKindGlobal)
+	if err != nil {
+		return Value{}, err
+	}
+	return i.store.globals[i.mod.globalAddrs[idx]].value, nil
+}
+
+// SetGlobal sets the exported global name, which must be mutable and of v's type.
+func (i *Interpreter) SetGlobal(name string, v Value) error {
+	idx, err := i.exportIdx(name, exportImportKindGlobal)
+	if err != nil {
+		return err
+	}
+	global := &i.store.globals[i.mod.globalAddrs[idx]]
+	if global.globalType.mut == const_ {
+		return fmt.Errorf("global %s is a const value", name)
+	}
+	if global.globalType.valueType != v.ValType {
+		return fmt.Errorf("global %s and value types do not match", name)
+	}
+	global.value = v
+	return nil
+}
+
+// exportIdx resolves the export name to its index in the kind index space.
+func (i *Interpreter) exportIdx(name string, kind exportImportKind) (uint32, error) {
+	for _, export := range i.mod.exports {
+		if export.name == name {
+			if export.value.kind != kind {
+				return 0, fmt.Errorf("%s not a %s", name, kind)
+			}
+			return export.value.idx, nil
+		}
+	}
+	return 0, fmt.Errorf("can't find %s %s", name, kind)
+}
+
+// ModuleName returns the module name from the name section.
+func (i *Interpreter) ModuleName() string {
+	return i.names.module
+}
+
+// FuncName returns the name section entry for the func at idx.
+func (i *Interpreter) FuncName(idx uint32) (string, bool) {
+	name, ok := i.names.funcs[idx]
+	return name, ok
+}
+
+// LocalName returns the name section entry for a local of the func at funcIdx.
+func (i *Interpreter) LocalName(funcIdx, localIdx uint32) (string, bool) {
+	name, ok := i.names.locals[funcIdx][localIdx]
+	return name, ok
+}
+
+// SetDeterministic makes the results of float instrs independent of the host: every NaN
+// produced by scalar arithmetic, conversions and f32x4 lanes is the positive canonical NaN,
+// instead of whatever the host hardware propagates. The other instrs are deterministic already.
+func (i *Interpreter) SetDeterministic(on bool) {
+	i.store.canonicalNaN = on
+}
+
+// SetMaxCallDepth limits the number of nested calls, a call beyond it traps
+// with "call stack exhausted". The default is 1024.
+func (i *Interpreter) SetMaxCallDepth(depth int) {
+	i.store.maxCallDepth = depth
+}
+
+// Memory returns the backing slice of the default memory.
+// The slice is replaced when the memory grows, so don't hold on to it.
+func (i *Interpreter) Memory() ([]byte, error) {
+	mem, err := i.defaultMem()
+	if err != nil {
+		return nil, err
+	}
+	return mem.data, nil
+}
+
+// ReadMemory copies length bytes at offset out of the default memory.
+func (i *Interpreter) ReadMemory(offset, length uint32) ([]byte, error) {
+	mem, err := i.defaultMem()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(offset)+uint64(length) > uint64(len(mem.data)) {
+		return nil, errOutOfBounds
+	}
+	data := make([]byte, length)
+	copy(data, mem.data[offset:])
+	return data, nil
+}
+
+// WriteMemory copies data into the default memory at offset.
+func (i *Interpreter) WriteMemory(offset uint32, data []byte) error {
+	mem, err := i.defaultMem()
+	if err != nil {
+		return err
+	}
+	if uint64(offset)+uint64(len(data)) > uint64(len(mem.data)) {
+		return errOutOfBounds
+	}
+	copy(mem.data[offset:], data)
+	return nil
+}
+
+// GrowMemory grows the default memory by pages like memory.grow does, previous is the
+// size in pages before growing, or -1 when the declared max or the address space
+// doesn't leave room for them. err is only set when the module has no memory.
+func (i *Interpreter) GrowMemory(pages uint32) (previous int32, err error) {
+	mem, err := i.defaultMem()
+	if err != nil {
+		return -1, err
+	}
+	previous = int32(mem.pages())
+	if pages > maxPages || mem.grow(int(pages)) != nil {
+		return -1, nil
+	}
+	return previous, nil
+}
+
+// Ref is an element of a table. In a funcref table Index is the index of the func
+// in the func index space, imported funcs first. In an externref table it is the host value.
+type Ref struct {
+	Null  bool
+	Index uint32
+}
+
+// TableGet returns the element elemIdx of the table tableIdx, tables are in the table index space.
+func (i *Interpreter) TableGet(tableIdx, elemIdx uint32) (Ref, error) {
+	table, err := i.table(tableIdx)
+	if err != nil {
+		return Ref{}, err
+	}
+	if elemIdx >= uint32(len(table.elems)) {
+		return Ref{}, errOutOfBoundsTable
+	}
+	r := table.elems[elemIdx]
+	switch {
+	case r.isNull():
+		return Ref{Null: true}, nil
+	case r.kind == refExtern:
+		return Ref{Index: uint32(r.addr)}, nil
+	}
+	for idx, addr := range i.mod.funcAddrs {
+		if int(addr) == r.addr {
+			return Ref{Index: uint32(idx)}, nil
+		}
+	}
+	// only a table shared with another module can hold one of its funcs
+	return Ref{}, fmt.Errorf("table %d element %d refers to a func of another module", tableIdx, elemIdx)
+}
+
+// TableSet replaces the element elemIdx of the table tableIdx, see TableGet.
+func (i *Interpreter) TableSet(tableIdx, elemIdx uint32, r Ref) error {
+	table, err := i.table(tableIdx)
+	if err != nil {
+		return err
+	}
+	if elemIdx >= uint32(len(table.elems)) {
+		return errOutOfBoundsTable
+	}
+	switch {
+	case r.Null:
+		table.elems[elemIdx] = ref{kind: refNull}
+	case table.elemType == ExternRef:
+		table.elems[elemIdx] = ref{addr: int(r.Index), kind: refExtern}
+	case int(r.Index) >= len(i.mod.funcAddrs):
+		return fmt.Errorf("unknown func %d", r.Index)
+	default:
+		table.elems[elemIdx] = ref{addr: int(i.mod.funcAddrs[r.Index]), kind: refFunc}
+	}
+	return nil
+}
+
+func (i *Interpreter) table(tableIdx uint32) (*tableInst, error) {
+	if tableIdx >= uint32(len(i.mod.tableAddrs)) {
+		return nil, fmt.Errorf("unknown table %d", tableIdx)
+	}
+	return &i.store.tables[i.mod.tableAddrs[tableIdx]], nil
+}
+
+func (i *Interpreter) defaultMem() (*memInst, error) {
+	if len(i.mod.memAddrs) == 0 {
+		return nil, fmt.Errorf("module has no memory")
+	}
+	return &i.store.mems[i.mod.defaultMemAddr()], nil
 }
 
 // https://webassembly.github.io/spec/core/exec/runtime.html#store
@@ -95,22 +476,28 @@ type store struct {
 	globals []globalInst
 	elems   []elemInst
 	datas   []dataInst
+	// replace every arithmetic NaN result by the canonical NaN
+	canonicalNaN bool
+	// number of frames a call may not exceed
+	maxCallDepth int
 }
 
 func newStoreAndModuleInst(
 	valueStack *stack[Value],
 	m module,
+	l *Linker,
 ) (store, moduleInst, error) {
-	s := store{}
+	s := store{maxCallDepth: defaultMaxCallDepth}
 	modInst := moduleInst{}
 
 	eval := func(expr expr) (Value, error) {
 		frameStack := stack[frame]{}
 		// mock frame
 		frameStack.Push(frame{
-			pc:  0,
-			sp:  valueStack.Len(),
-			mod: &modInst,
+			pc:    0,
+			sp:    valueStack.Len(),
+			arity: 1,
+			mod:   &modInst,
 		})
 		for _, i := range expr {
 			if err := i.exec(&frameStack, valueStack, &s); err != nil {
@@ -122,20 +509,74 @@ func newStoreAndModuleInst(
 		return v, nil
 	}
 
-	for i, g := range m.globals {
+	// imports come first in their index spaces, without a linker only functions
+	// can be imported and they are bound later with RegisterFunc
+	for _, imp := range m.imports {
+		switch {
+		case imp.kind == exportImportKindFunc:
+			fn := funcInst{
+				funcType: m.types[imp.importDesc.typeIdx],
+				kind:     externalFunc,
+				externalFunc: externalFuncInst{
+					module:   imp.module,
+					name:     imp.name,
+					importer: &modInst,
+				},
+			}
+			if l != nil {
+				def, err := l.resolveFunc(imp, fn.funcType)
+				if err != nil {
+					return s, modInst, err
+				}
+				fn.externalFunc.fn = def.fn
+				fn.externalFunc.memFn = def.memFn
+			}
+			modInst.funcAddrs = append(modInst.funcAddrs, uint32(len(s.funcs)))
+			s.funcs = append(s.funcs, fn)
+		case l == nil:
+			// skipping the import would shift the indices of everything defined after it
+			return s, modInst, fmt.Errorf("unresolved import: imported %s %s.%s needs a Linker", imp.kind, imp.module, imp.name)
+		case imp.kind == exportImportKindGlobal:
+			global, err := l.resolveGlobal(imp)
+			if err != nil {
+				return s, modInst, err
+			}
+			modInst.globalAddrs = append(modInst.globalAddrs, uint32(len(s.globals)))
+			s.globals = append(s.globals, global)
+		case imp.kind == exportImportKindMem:
+			mem, err := l.resolveMem(len(modInst.memAddrs), imp)
+			if err != nil {
+				return s, modInst, err
+			}
+			modInst.memAddrs = append(modInst.memAddrs, uint32(len(s.mems)))
+			s.mems = append(s.mems, mem)
+		case imp.kind == exportImportKindTable:
+			table, err := l.resolveTable(imp)
+			if err != nil {
+				return s, modInst, err
+			}
+			modInst.tableAddrs = append(modInst.tableAddrs, uint32(len(s.tables)))
+			s.tables = append(s.tables, table)
+		default:
+			return s, modInst, fmt.Errorf("unknown import %s.%s", imp.module, imp.name)
+		}
+	}
+
+	// global initializers may read imported globals
+	for _, g := range m.globals {
 		gv, err := eval(g.initExpr)
 		if err != nil {
 			return s, modInst, err
 		}
-		modInst.globalAddrs = append(modInst.globalAddrs, uint32(i))
+		modInst.globalAddrs = append(modInst.globalAddrs, uint32(len(s.globals)))
 		s.globals = append(s.globals, globalInst{
 			globalType: g.type_,
 			value:      gv,
 		})
 	}
 
-	for i, f := range m.funcs {
-		modInst.funcAddrs = append(modInst.funcAddrs, uint32(i))
+	for _, f := range m.funcs {
+		modInst.funcAddrs = append(modInst.funcAddrs, uint32(len(s.funcs)))
 		s.funcs = append(s.funcs, funcInst{
 			funcType: m.types[f.typeIdx],
 			kind:     internalFunc,
@@ -146,56 +587,69 @@ func newStoreAndModuleInst(
 		})
 	}
 
-	for i, mem := range m.mems {
-		min := mem.limits.Min * uint32(PAGE_SIZE)
-		modInst.memAddrs = append(modInst.memAddrs, uint32(i))
-		s.mems = append(s.mems, memInst{
-			memType: memType{limits: mem.limits},
-			data:    make([]byte, min),
-		})
+	for _, mem := range m.mems {
+		inst, err := newMemInst(len(modInst.memAddrs), mem.memType)
+		if err != nil {
+			return s, modInst, err
+		}
+		modInst.memAddrs = append(modInst.memAddrs, uint32(len(s.mems)))
+		s.mems = append(s.mems, inst)
 	}
 
 	for i := range m.elems {
 		modInst.elemAddrs = append(modInst.elemAddrs, uint32(i))
 	}
-	for i, tab := range m.tables {
-		elems := make([]ref, tab.limits.Min)
-		modInst.tableAddrs = append(modInst.tableAddrs, uint32(i))
-		for _, elem := range m.elems {
-			offsetVal, err := eval(elem.offset)
-			offset := int(offsetVal.I32())
-			if err != nil {
-				return s, modInst, err
-			}
-			if len(elems) <= offset+len(elem.init) {
-				originalElems := elems
-				elems = make([]ref, offset+len(elem.init))
-				copy(elems, originalElems)
-			}
-
-			for i, funcIdx := range elem.init {
-				elems[i+offset] = ref{addr: int(funcIdx), kind: refFunc}
-			}
-		}
+	for _, tab := range m.tables {
+		modInst.tableAddrs = append(modInst.tableAddrs, uint32(len(s.tables)))
 		s.tables = append(s.tables, tableInst{
 			tableType: tableType{
 				limits:   tab.limits,
 				elemType: tab.elemType,
 			},
-			elems: elems,
+			elems: nullRefs(int(tab.limits.Min)),
 		})
 	}
 
+	for _, elem := range m.elems {
+		if elem.mode != elemModeActive {
+			continue
+		}
+		offsetVal, err := eval(elem.offset)
+		if err != nil {
+			return s, modInst, err
+		}
+		// the segment must fit in the table as allocated, tables only grow with table.grow
+		offset := uint64(uint32(offsetVal.I32()))
+		table := &s.tables[modInst.tableAddrs[elem.tableIdx]]
+		if offset+uint64(len(elem.init)) > uint64(len(table.elems)) {
+			return s, modInst, errOutOfBoundsTable
+		}
+
+		for i, funcIdx := range elem.init {
+			table.elems[uint64(i)+offset] = ref{addr: int(modInst.funcAddrs[funcIdx]), kind: refFunc}
+		}
+	}
+
 	for i, data := range m.datas {
 		modInst.dataAddrs = append(modInst.dataAddrs, uint32(i))
+		if data.mode != dataModeActive {
+			s.datas = append(s.datas, dataInst{data: data.init})
+			continue
+		}
+		// active segments are dropped once copied into memory
+		s.datas = append(s.datas, dataInst{})
 		offsetVal, err := eval(data.offset)
 		if err != nil {
 			return s, modInst, err
 		}
-		offset := int(offsetVal.I32())
-		mem := s.mems[data.memIdx]
-		if len(mem.data) < offset+len(data.init) {
-			return s, modInst, fmt.Errorf("data is too large to fit in memory")
+		// the offset is an unsigned address, filling up to the very end of the memory is fine
+		offset := uint64(uint32(offsetVal.I32()))
+		if offsetVal.ValType == I64 {
+			offset = uint64(offsetVal.I64())
+		}
+		mem := s.mems[modInst.memAddrs[data.memIdx]]
+		if offset > uint64(len(mem.data)) || offset+uint64(len(data.init)) > uint64(len(mem.data)) {
+			return s, modInst, errOutOfBounds
 		}
 		copy(mem.data[offset:], data.init)
 	}
@@ -217,8 +671,14 @@ type frame struct {
 	pc int
 	// value stack pointer
 	sp int
+	// number of function results
+	arity int
+	// number of params and declared locals, they are the first values from sp
+	locals int
 	// function instructions
 	insts []instr
+	// the op of each instr of insts
+	ops []instrOp
 
 	// labels for if, loop, block
 	labels stack[label]
@@ -228,3 +688,9 @@ type frame struct {
 func (f *frame) NextStep() {
 	f.pc += 1
 }
+
+// hasLocal reports whether idx is one of the params or declared locals of the frame,
+// the operands above them on the value stack aren't locals.
+func (f *frame) hasLocal(idx int) bool {
+	return idx >= 0 && idx < f.locals
+}
@@ -0,0 +1,1327 @@
+package wasm_go
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newInterpreterFromWat(t *testing.T, wat string) Interpreter {
+	wasm, err := wat2wasm(wat)
+	assert.NoError(t, err)
+	i, err := NewInterpreter(wasm)
+	assert.NoError(t, err)
+	return i
+}
+
+func invokeExport(t *testing.T, i *Interpreter, name string, args ...Value) ([]Value, error) {
+	fn, err := i.GetFunc(name)
+	if !assert.NoError(t, err) {
+		return nil, err
+	}
+	return fn(args)
+}
+
+func TestBrIfLoop(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "countdown") (param i32) (result i32)
+				(loop
+					(block
+						local.get 0
+						i32.const -1
+						i32.add
+						local.set 0
+						local.get 0
+						br_if 1
+					)
+				)
+				local.get 0
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "countdown", ValueFromI32(10))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(0)}, ret)
+}
+
+func TestBrTable(t *testing.T) {
+	wat := `
+		(module
+			(func (export "switch") (param i32) (result i32)
+				(block
+					(block
+						(block
+							(block
+								(block
+									local.get 0
+									br_table 0 1 2 3
+								)
+								i32.const 100
+								local.set 0
+								br 3
+							)
+							i32.const 101
+							local.set 0
+							br 2
+						)
+						i32.const 102
+						local.set 0
+						br 1
+					)
+					i32.const 103
+					local.set 0
+				)
+				local.get 0
+			)
+		)
+	`
+	m := parseWat(t, wat)
+	var brTable *opBrTable
+	for _, instr := range m.funcs[0].body {
+		if o, ok := instr.(*opBrTable); ok {
+			brTable = o
+		}
+	}
+	if assert.NotNil(t, brTable) {
+		assert.Equal(t, []int{0, 1, 2}, brTable.labelIdxArr)
+		assert.Equal(t, 3, brTable.defaultIdx)
+	}
+
+	i := newInterpreterFromWat(t, wat)
+	cases := map[int32]int32{0: 100, 1: 101, 2: 102, 3: 103, 100: 103, -1: 103}
+	for arg, expected := range cases {
+		ret, err := invokeExport(t, &i, "switch", ValueFromI32(arg))
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{ValueFromI32(expected)}, ret, "switch(%d)", arg)
+	}
+}
+
+func TestCallRecursive(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func $fac (export "fac") (param i32) (result i32)
+				(block (result i32)
+					i32.const 1
+					local.get 0
+					i32.eqz
+					br_if 0
+					drop
+					local.get 0
+					local.get 0
+					i32.const 1
+					i32.sub
+					call $fac
+					i32.mul
+				)
+			)
+			(func (export "sub") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				call $sub
+			)
+			(func $sub (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.sub
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "fac", ValueFromI32(5))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(120)}, ret)
+
+	ret, err = invokeExport(t, &i, "sub", ValueFromI32(10), ValueFromI32(3))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(7)}, ret)
+}
+
+func TestGetFuncResultOrder(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "pair") (result i32 i64)
+				i32.const 1
+				i64.const 2
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "pair")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1), ValueFromI64(2)}, ret)
+}
+
+func TestGetFuncArgs(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "sub") (param i32 i64) (result i32)
+				local.get 0
+			)
+		)
+	`)
+	_, err := invokeExport(t, &i, "sub", ValueFromI32(1))
+	assert.EqualError(t, err, "func sub expects 2 arguments, got 1")
+	_, err = invokeExport(t, &i, "sub", ValueFromI32(1), ValueFromI64(2), ValueFromI32(3))
+	assert.EqualError(t, err, "func sub expects 2 arguments, got 3")
+	_, err = invokeExport(t, &i, "sub", ValueFromI32(1), ValueFromI32(2))
+	assert.EqualError(t, err, "func sub argument 1: expected i64, got i32")
+	assert.Equal(t, 0, i.valueStack.Len())
+
+	ret, err := invokeExport(t, &i, "sub", ValueFromI32(1), ValueFromI64(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1)}, ret)
+}
+
+func TestGetFuncByIndex(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(import "env" "neg" (func (param i32) (result i32)))
+			(func (export "run") (param i32) (result i32)
+				local.get 0
+				call $square
+			)
+			(func $square (param i32) (result i32)
+				local.get 0
+				local.get 0
+				i32.mul
+			)
+		)
+	`)
+	assert.NoError(t, i.RegisterFunc("env", "neg", []ValType{I32}, []ValType{I32}, func(args []Value) ([]Value, error) {
+		return []Value{ValueFromI32(-args[0].I32())}, nil
+	}))
+
+	// the imported func comes first, $square isn't exported
+	square, err := i.GetFuncByIndex(2)
+	if assert.NoError(t, err) {
+		ret, err := square([]Value{ValueFromI32(7)})
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{ValueFromI32(49)}, ret)
+		_, err = square([]Value{ValueFromI64(7)})
+		assert.EqualError(t, err, "func 2 argument 0: expected i32, got i64")
+	}
+	neg, err := i.GetFuncByIndex(0)
+	if assert.NoError(t, err) {
+		ret, err := neg([]Value{ValueFromI32(7)})
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{ValueFromI32(-7)}, ret)
+	}
+
+	_, err = i.GetFuncByIndex(3)
+	assert.EqualError(t, err, "unknown func 3")
+}
+
+func TestCallIndirect(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(type $i32 (func (result i32)))
+			(table 4 funcref)
+			(elem (i32.const 1) $one $f64)
+			(func $zero (result i32)
+				i32.const 0
+			)
+			(func $one (result i32)
+				i32.const 1
+			)
+			(func $f64 (result f64)
+				f64.const 2
+			)
+			(func (export "dispatch") (param i32) (result i32)
+				local.get 0
+				call_indirect (type $i32)
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "dispatch", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1)}, ret)
+
+	_, err = invokeExport(t, &i, "dispatch", ValueFromI32(2))
+	assert.EqualError(t, err, "indirect call type mismatch")
+	_, err = invokeExport(t, &i, "dispatch", ValueFromI32(3))
+	assert.EqualError(t, err, "uninitialized element")
+	_, err = invokeExport(t, &i, "dispatch", ValueFromI32(4))
+	assert.EqualError(t, err, "undefined element")
+}
+
+func TestReturnFromNestedBlock(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func $inner (param i32) (result i32)
+				(block
+					(loop
+						i32.const 7
+						local.get 0
+						i32.const 42
+						return
+					)
+				)
+				i32.const 0
+			)
+			(func (export "outer") (result i32)
+				i32.const 1
+				call $inner
+				i32.const 1
+				i32.add
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "outer")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(43)}, ret)
+}
+
+func TestLocalsZeroInitialized(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func $locals (param i32) (result i32)
+				(local i32 i64)
+				local.get 1
+				local.get 0
+				i32.add
+				local.set 1
+				local.get 1
+			)
+			(func (export "run") (result i32)
+				i32.const 5
+				call $locals
+				i32.const 6
+				call $locals
+				i32.add
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "run")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(11)}, ret)
+}
+
+func TestHostFunc(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(import "env" "add" (func $add (param i32 i32) (result i32)))
+			(export "add" (func $add))
+			(func (export "run") (param i32) (result i32)
+				local.get 0
+				i32.const 2
+				call $add
+				i32.const 3
+				i32.mul
+			)
+		)
+	`)
+	_, err := invokeExport(t, &i, "run", ValueFromI32(1))
+	assert.EqualError(t, err, "imported func env.add is not registered")
+
+	// the host signature must match the import
+	err = i.RegisterFunc("env", "add", []ValType{I32}, []ValType{I32}, func(args []Value) ([]Value, error) {
+		return []Value{args[0]}, nil
+	})
+	assert.EqualError(t, err, "incompatible import type for env.add")
+	_, err = invokeExport(t, &i, "run", ValueFromI32(1))
+	assert.EqualError(t, err, "imported func env.add is not registered")
+
+	i32s := []ValType{I32, I32}
+	err = i.RegisterFunc("env", "add", i32s, i32s[:1], func(args []Value) ([]Value, error) {
+		return []Value{ValueFromI32(args[0].I32() + args[1].I32())}, nil
+	})
+	assert.NoError(t, err)
+	assert.Error(t, i.RegisterFunc("env", "sub", nil, nil, nil))
+
+	ret, err := invokeExport(t, &i, "run", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(9)}, ret)
+
+	ret, err = invokeExport(t, &i, "add", ValueFromI32(4), ValueFromI32(5))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(9)}, ret)
+
+	err = i.RegisterFunc("env", "add", i32s, i32s[:1], func(args []Value) ([]Value, error) {
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	_, err = invokeExport(t, &i, "run", ValueFromI32(1))
+	assert.EqualError(t, err, "imported func env.add returned 0 results, expected 1")
+}
+
+func TestImportedFuncIndexSpace(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(import "env" "one" (func (result i32)))
+			(import "env" "two" (func (result i32)))
+			(table 1 funcref)
+			(elem (i32.const 0) 3)
+			(func (result i32)
+				i32.const 3
+			)
+			(func (result i32)
+				i32.const 4
+			)
+			(export "three" (func 2))
+			(export "four" (func 3))
+			(func (export "indirect") (result i32)
+				i32.const 0
+				call_indirect (result i32)
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "three")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+	ret, err = invokeExport(t, &i, "four")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(4)}, ret)
+	ret, err = invokeExport(t, &i, "indirect")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(4)}, ret)
+}
+
+func TestElemSegmentTableIdx(t *testing.T) {
+	offset := func(v int32) expr {
+		return expr{&opConst{val: ValueFromI32(v)}, &opEnd{}}
+	}
+	m := module{
+		types: []funcType{{}},
+		funcs: []function{{}, {}},
+		tables: []table{
+			{tableType{limits: limits{Min: 2, Max: -1}, elemType: FuncRef}},
+			{tableType{limits: limits{Min: 2, Max: -1}, elemType: FuncRef}},
+		},
+		elems: []elem{
+			{tableIdx: 1, offset: offset(1), init: []uint32{0}},
+			{tableIdx: 0, offset: offset(0), init: []uint32{1}},
+		},
+	}
+	valueStack := stack[Value]{}
+	s, _, err := newStoreAndModuleInst(&valueStack, m, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []ref{{addr: 1, kind: refFunc}, {kind: refNull}}, s.tables[0].elems)
+	assert.Equal(t, []ref{{kind: refNull}, {addr: 0, kind: refFunc}}, s.tables[1].elems)
+}
+
+func TestBlockEndKeepsResults(t *testing.T) {
+	// not a valid module, the block leaves a scratch value below its result
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "run") (result i32)
+				i32.const 1
+				(block (result i32)
+					i32.const 99
+					i32.const 2
+				)
+				i32.add
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "run")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+	assert.Equal(t, 0, i.valueStack.Len())
+}
+
+func TestBrDiscardsOperands(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "block") (result i32)
+				i32.const 1
+				(block (result i32)
+					i32.const 99
+					i32.const 98
+					i32.const 2
+					br 0
+				)
+				i32.add
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "block")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+	assert.Equal(t, 0, i.valueStack.Len())
+
+	// a branch back to a loop keeps nothing pushed inside the loop body
+	m := parseWat(t, `
+		(module
+			(func
+				(loop
+					i32.const 99
+					i32.const 98
+					br 0
+				)
+			)
+		)
+	`)
+	body := m.funcs[0].body
+	frameStack := stack[frame]{}
+	frameStack.Push(frame{insts: body})
+	valueStack := stack[Value]{}
+	valueStack.Push(ValueFromI32(1))
+	for x := 0; x < 4; x++ {
+		assert.NoError(t, body[x].exec(&frameStack, &valueStack, &store{}))
+	}
+	// the loop body starts again with its label still pushed
+	frame, _ := frameStack.Top()
+	assert.Equal(t, 1, frame.pc)
+	assert.Equal(t, 1, frame.labels.Len())
+	assert.Equal(t, []Value{ValueFromI32(1)}, valueStack.inner)
+}
+
+func TestBrUnknownLabel(t *testing.T) {
+	// validation rejects these depths, run the instr on its own
+	frameStack := stack[frame]{}
+	frameStack.Push(frame{insts: []instr{&opBlock{}, &opBr{}, &opEnd{}, &opEnd{}}, pc: 1})
+	top, _ := frameStack.Top()
+	top.labels.Push(label{kind: LabelKindBlock, endPc: 2})
+	valueStack := stack[Value]{}
+	for _, level := range []int{2, 5, -1} {
+		err := (&opBr{level: level}).exec(&frameStack, &valueStack, &store{})
+		assert.EqualError(t, err, fmt.Sprintf("unknown label %d", level))
+		assert.Equal(t, 1, top.labels.Len())
+	}
+
+	s := stack[int]{}
+	_, ok := s.Peek(0)
+	assert.False(t, ok)
+	s.Push(1)
+	s.Push(2)
+	v, ok := s.Peek(1)
+	assert.True(t, ok)
+	assert.Equal(t, 1, *v)
+	_, ok = s.Peek(2)
+	assert.False(t, ok)
+	_, ok = s.Peek(-1)
+	assert.False(t, ok)
+}
+
+func TestIf(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "if_else") (param i32) (result i32)
+				(if (result i32) (local.get 0)
+					(then
+						(block
+							i32.const 1
+							drop
+						)
+						i32.const 10
+					)
+					(else
+						(block
+							i32.const 2
+							drop
+						)
+						i32.const 20
+					)
+				)
+				i32.const 1
+				i32.add
+			)
+			(func (export "if") (param i32) (result i32)
+				i32.const 5
+				(if (local.get 0)
+					(then
+						i32.const 3
+						local.set 0
+					)
+				)
+				local.get 0
+				i32.add
+			)
+		)
+	`)
+	cases := []struct {
+		name     string
+		arg      int32
+		expected int32
+	}{
+		{"if_else", 1, 11},
+		{"if_else", 0, 21},
+		{"if", 1, 8},
+		{"if", 0, 5},
+	}
+	for _, c := range cases {
+		ret, err := invokeExport(t, &i, c.name, ValueFromI32(c.arg))
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{ValueFromI32(c.expected)}, ret, "%s(%d)", c.name, c.arg)
+	}
+}
+
+func TestMultiValueBlocks(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(type $binop (func (param i32 i32) (result i32)))
+			(func (export "block") (result i32)
+				i32.const 100
+				i32.const 1
+				i32.const 2
+				(block (type $binop) (param i32 i32) (result i32)
+					i32.add
+				)
+				i32.add
+			)
+			(func (export "if") (param i32) (result i32)
+				i32.const 7
+				local.get 0
+				(if (param i32) (result i32 i32)
+					(then
+						i32.const 1
+					)
+					(else
+						i32.const 2
+					)
+				)
+				(block (param i32 i32) (result i32 i32)
+					(block (param i32 i32) (result i32 i32))
+				)
+				i32.sub
+			)
+			(func (export "loop") (param i32) (result i32)
+				;; sums n + (n-1) + ... + 1, the loop carries the sum and counter as its params
+				i32.const 0
+				local.get 0
+				(loop (param i32 i32) (result i32)
+					local.tee 0
+					i32.add
+					local.get 0
+					i32.const 1
+					i32.sub
+					local.tee 0
+					local.get 0
+					br_if 0
+					drop
+				)
+			)
+			(func (export "br_func") (result i32)
+				i32.const 1
+				(block (result i32)
+					i32.const 2
+					br 1
+				)
+				unreachable
+			)
+		)
+	`)
+	cases := []struct {
+		name     string
+		args     []Value
+		expected []Value
+	}{
+		{"block", nil, []Value{ValueFromI32(103)}},
+		{"if", []Value{ValueFromI32(1)}, []Value{ValueFromI32(6)}},
+		{"if", []Value{ValueFromI32(0)}, []Value{ValueFromI32(5)}},
+		{"loop", []Value{ValueFromI32(4)}, []Value{ValueFromI32(10)}},
+		// a branch to the function body returns its results
+		{"br_func", nil, []Value{ValueFromI32(2)}},
+	}
+	for _, c := range cases {
+		ret, err := invokeExport(t, &i, c.name, c.args...)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.expected, ret, c.name)
+		assert.Equal(t, 0, i.valueStack.Len(), c.name)
+	}
+}
+
+func TestCallIndirectFuncIdxZero(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(table 2 funcref)
+			(elem (i32.const 0) $zero)
+			(func $zero (result i32)
+				i32.const 42
+			)
+			(func (export "dispatch") (param i32) (result i32)
+				local.get 0
+				call_indirect (result i32)
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "dispatch", ValueFromI32(0))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+
+	_, err = invokeExport(t, &i, "dispatch", ValueFromI32(1))
+	assert.EqualError(t, err, "uninitialized element")
+}
+
+func BenchmarkLoop(b *testing.B) {
+	wasm, err := wat2wasm(`
+		(module
+			(func (export "sum") (param i32) (result i32)
+				(local i32)
+				(loop
+					local.get 1
+					local.get 0
+					i32.add
+					local.set 1
+					local.get 0
+					i32.const -1
+					i32.add
+					local.tee 0
+					br_if 0
+				)
+				local.get 1
+			)
+		)
+	`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	i, err := NewInterpreter(wasm)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fn, err := i.GetFunc("sum")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := fn([]Value{ValueFromI32(1000)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoopWithBlocks(b *testing.B) {
+	wasm, err := wat2wasm(`
+		(module
+			(func (export "count_odd") (param i32) (result i32)
+				(local i32)
+				(loop
+					(block
+						(block
+							local.get 0
+							i32.const 1
+							i32.and
+							br_if 0
+							br 1
+						)
+						local.get 1
+						i32.const 1
+						i32.add
+						local.set 1
+					)
+					(if (local.get 0)
+						(then
+							local.get 0
+							i32.const -1
+							i32.add
+							local.set 0
+						)
+					)
+					local.get 0
+					br_if 0
+				)
+				local.get 1
+			)
+		)
+	`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	i, err := NewInterpreter(wasm)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fn, err := i.GetFunc("count_odd")
+	if err != nil {
+		b.Fatal(err)
+	}
+	ret, err := fn([]Value{ValueFromI32(10)})
+	if err != nil || ret[0].I32() != 5 {
+		b.Fatalf("count_odd(10) = %v, %v", ret, err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := fn([]Value{ValueFromI32(1000)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStartFunc(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(global (mut i32) (i32.const 0))
+			(func
+				i32.const 42
+				global.set 0
+			)
+			(func (export "get") (result i32)
+				global.get 0
+			)
+			(start 0)
+		)
+	`)
+	assert.Equal(t, ValueFromI32(42), i.store.globals[0].value)
+	ret, err := invokeExport(t, &i, "get")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+}
+
+func TestStartFuncImports(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(import "env" "answer" (func $answer (param i32) (result i32)))
+			(global $g (export "g") (mut i32) (i32.const 0))
+			(func $start
+				i32.const 6
+				call $answer
+				global.set $g
+			)
+			(func (export "get") (result i32)
+				global.get $g
+			)
+			(start $start)
+		)
+	`)
+	// the start function waits for the imported func
+	g, err := i.GetGlobal("g")
+	assert.NoError(t, err)
+	assert.Equal(t, ValueFromI32(0), g)
+	_, err = invokeExport(t, &i, "get")
+	assert.EqualError(t, err, "func get: the start function didn't run, register every imported func first")
+
+	// a failing start function leaves the stacks empty
+	err = i.RegisterFunc("env", "answer", []ValType{I32}, []ValType{I32}, func(args []Value) ([]Value, error) {
+		return nil, fmt.Errorf("no answer")
+	})
+	assert.EqualError(t, err, "start function: no answer")
+	assert.Equal(t, 0, i.StackDepth())
+	assert.True(t, i.frameStack.isEmpty())
+	_, err = invokeExport(t, &i, "get")
+	assert.Error(t, err)
+
+	// registering again runs it again
+	err = i.RegisterFunc("env", "answer", []ValType{I32}, []ValType{I32}, func(args []Value) ([]Value, error) {
+		return []Value{ValueFromI32(args[0].I32() * 7)}, nil
+	})
+	assert.NoError(t, err)
+	ret, err := invokeExport(t, &i, "get")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+}
+
+func TestMemoryAccess(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(func (export "load") (param i32) (result i32)
+				local.get 0
+				i32.load
+			)
+			(func (export "store") (param i32 i32)
+				local.get 0
+				local.get 1
+				i32.store
+			)
+		)
+	`)
+	assert.NoError(t, i.WriteMemory(16, []byte{0x01, 0x02, 0x03, 0x04}))
+	ret, err := invokeExport(t, &i, "load", ValueFromI32(16))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(0x04030201)}, ret)
+
+	_, err = invokeExport(t, &i, "store", ValueFromI32(32), ValueFromI32(0x0a0b0c0d))
+	assert.NoError(t, err)
+	data, err := i.ReadMemory(32, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x0d, 0x0c, 0x0b, 0x0a}, data)
+
+	mem, err := i.Memory()
+	assert.NoError(t, err)
+	assert.Len(t, mem, PAGE_SIZE)
+	assert.Equal(t, byte(0x01), mem[16])
+
+	assert.ErrorIs(t, i.WriteMemory(uint32(PAGE_SIZE)-2, []byte{1, 2, 3}), errOutOfBounds)
+	_, err = i.ReadMemory(uint32(PAGE_SIZE), 1)
+	assert.ErrorIs(t, err, errOutOfBounds)
+
+	noMem := newInterpreterFromWat(t, `(module)`)
+	_, err = noMem.Memory()
+	assert.EqualError(t, err, "module has no memory")
+}
+
+func TestExportedGlobals(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(global (export "answer") i32 (i32.const 42))
+			(global (export "counter") (mut i64) (i64.const 1))
+			(func (export "get_counter") (result i64)
+				global.get 1
+			)
+		)
+	`)
+	v, err := i.GetGlobal("answer")
+	assert.NoError(t, err)
+	assert.Equal(t, ValueFromI32(42), v)
+	assert.EqualError(t, i.SetGlobal("answer", ValueFromI32(1)), "global answer is a const value")
+
+	assert.EqualError(t, i.SetGlobal("counter", ValueFromI32(7)), "global counter and value types do not match")
+	assert.NoError(t, i.SetGlobal("counter", ValueFromI64(7)))
+	ret, err := invokeExport(t, &i, "get_counter")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(7)}, ret)
+
+	_, err = i.GetGlobal("get_counter")
+	assert.EqualError(t, err, "get_counter not a global")
+	_, err = i.GetGlobal("missing")
+	assert.EqualError(t, err, "can't find missing global")
+}
+
+func TestExecuteWithContext(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "spin")
+				(loop
+					br 0
+				)
+			)
+		)
+	`)
+	fn, err := i.GetFuncWithContext("spin")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = fn(ctx, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// the interpreter is usable after a cancelled call
+	assert.Equal(t, 0, i.frameStack.Len())
+	assert.Equal(t, 0, i.valueStack.Len())
+}
+
+func TestCallStackExhausted(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func $loop (export "loop")
+				call $loop
+			)
+			(func (export "depth") (param i32) (result i32)
+				local.get 0
+				if (result i32)
+					local.get 0
+					i32.const 1
+					i32.sub
+					call 1
+				else
+					i32.const 0
+				end
+			)
+		)
+	`)
+	_, err := invokeExport(t, &i, "loop")
+	assert.ErrorIs(t, err, errCallStackExhausted)
+	assert.Equal(t, 0, i.frameStack.Len())
+	assert.Equal(t, 0, i.valueStack.Len())
+
+	// the export's own frame counts towards the limit
+	ret, err := invokeExport(t, &i, "depth", ValueFromI32(1023))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(0)}, ret)
+	_, err = invokeExport(t, &i, "depth", ValueFromI32(1024))
+	assert.ErrorIs(t, err, errCallStackExhausted)
+
+	i.SetMaxCallDepth(10)
+	_, err = invokeExport(t, &i, "depth", ValueFromI32(10))
+	assert.ErrorIs(t, err, errCallStackExhausted)
+	ret, err = invokeExport(t, &i, "depth", ValueFromI32(9))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(0)}, ret)
+}
+
+func TestTailCall(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(type $countdown (func (param i64 i64) (result i64)))
+			(table funcref (elem $sum))
+			;; sum(n, acc) adds n, n-1, ..., 1 to acc
+			(func $sum (export "sum") (param i64 i64) (result i64)
+				(local i32)
+				local.get 0
+				i64.eqz
+				if (result i64)
+					local.get 1
+				else
+					;; leftover operands are dropped along with the frame
+					i32.const 42
+					local.get 0
+					i64.const 1
+					i64.sub
+					local.get 1
+					local.get 0
+					i64.add
+					return_call $sum
+				end
+			)
+			(func (export "sum_indirect") (param i64 i64) (result i64)
+				local.get 0
+				local.get 1
+				i32.const 0
+				return_call_indirect (type $countdown)
+			)
+			(func (export "nested") (param i64) (result i64)
+				local.get 0
+				i64.const 0
+				call $sum
+				i64.const 1
+				i64.add
+			)
+		)
+	`)
+	// a regular call would need one frame per step
+	ret, err := invokeExport(t, &i, "sum", ValueFromI64(100000), ValueFromI64(0))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(5000050000)}, ret)
+	assert.Equal(t, 0, i.valueStack.Len())
+
+	ret, err = invokeExport(t, &i, "sum_indirect", ValueFromI64(3), ValueFromI64(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(7)}, ret)
+
+	// the results of the tail calls are returned to the first caller
+	i.SetMaxCallDepth(2)
+	ret, err = invokeExport(t, &i, "nested", ValueFromI64(10))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(56)}, ret)
+}
+
+func TestStep(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "add") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.add
+			)
+		)
+	`)
+	assert.Equal(t, -1, i.CurrentPC())
+	done, err := i.Step()
+	assert.NoError(t, err)
+	assert.True(t, done)
+
+	i.valueStack.Push(ValueFromI32(1))
+	i.valueStack.Push(ValueFromI32(2))
+	assert.NoError(t, call(&i.frameStack, &i.valueStack, &i.store, &i.store.funcs[0]))
+
+	expected := [][]Value{
+		{ValueFromI32(1), ValueFromI32(2), ValueFromI32(1)},
+		{ValueFromI32(1), ValueFromI32(2), ValueFromI32(1), ValueFromI32(2)},
+		{ValueFromI32(1), ValueFromI32(2), ValueFromI32(3)},
+		{ValueFromI32(3)},
+	}
+	for pc, values := range expected {
+		assert.Equal(t, pc, i.CurrentPC())
+		done, err := i.Step()
+		assert.NoError(t, err)
+		assert.Equal(t, pc == len(expected)-1, done)
+		assert.Equal(t, values, i.valueStack.inner, "after pc %d", pc)
+		assert.Equal(t, len(values), i.StackDepth())
+	}
+}
+
+func TestTraceHook(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "abs") (param i32) (result i32)
+				local.get 0
+				i32.const 0
+				i32.lt_s
+				(if
+					(then
+						i32.const 0
+						local.get 0
+						i32.sub
+						local.set 0
+					)
+				)
+				local.get 0
+			)
+		)
+	`)
+	var pcs, depths []int
+	var ops []Opcode
+	i.SetTraceHook(func(pc int, op Opcode) {
+		pcs = append(pcs, pc)
+		ops = append(ops, op)
+		depths = append(depths, i.StackDepth())
+	})
+	ret, err := invokeExport(t, &i, "abs", ValueFromI32(5))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(5)}, ret)
+	assert.Equal(t, []int{0, 1, 2, 3, 8, 9, 10}, pcs)
+	assert.Equal(t, []Opcode{
+		OpcodeLocalGet, OpcodeI32Const, OpcodeI32LtS, OpcodeIf, OpcodeEnd, OpcodeLocalGet, OpcodeEnd,
+	}, ops)
+	assert.Equal(t, []int{1, 2, 3, 2, 1, 1, 2}, depths)
+
+	pcs = nil
+	_, err = invokeExport(t, &i, "abs", ValueFromI32(-5))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, pcs)
+
+	i.SetTraceHook(nil)
+	pcs = nil
+	_, err = invokeExport(t, &i, "abs", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Nil(t, pcs)
+}
+
+func TestProfile(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "count") (param i32) (result i32)
+				(local i32)
+				loop
+					local.get 1
+					i32.const 1
+					i32.add
+					local.tee 1
+					local.get 0
+					i32.lt_s
+					br_if 0
+				end
+				local.get 1
+				call $double
+			)
+			(func $double (param i32) (result i32)
+				local.get 0
+				local.get 0
+				i32.add
+			)
+		)
+	`)
+	_, err := invokeExport(t, &i, "count", ValueFromI32(3))
+	assert.NoError(t, err)
+	assert.Nil(t, i.Profile())
+
+	i.SetProfiling(true)
+	ret, err := invokeExport(t, &i, "count", ValueFromI32(10))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(20)}, ret)
+	profile := i.Profile()
+	// one add per iteration and one in the callee
+	assert.Equal(t, uint64(11), profile["i32.add"])
+	assert.Equal(t, uint64(10), profile["br_if"])
+	// branching back to the loop doesn't run the loop instr again
+	assert.Equal(t, uint64(1), profile["loop"])
+	assert.Equal(t, uint64(1), profile["call"])
+	// the loop's end and the end of both bodies
+	assert.Equal(t, uint64(3), profile["end"])
+	assert.NotContains(t, profile, "br")
+
+	// counts add up until profiling is turned on again
+	_, err = invokeExport(t, &i, "count", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(13), i.Profile()["i32.add"])
+	i.SetProfiling(true)
+	assert.Empty(t, i.Profile())
+	i.SetProfiling(false)
+	assert.Nil(t, i.Profile())
+}
+
+func TestNewInterpreterFromReader(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(func (export "add") (param i32 i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.add
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	i, err := NewInterpreterFromReader(iotest.OneByteReader(bytes.NewReader(wasm)))
+	assert.NoError(t, err)
+	ret, err := invokeExport(t, &i, "add", ValueFromI32(1), ValueFromI32(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+
+	// the last section is cut short
+	_, err = NewInterpreterFromReader(bytes.NewReader(wasm[:len(wasm)-1]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = NewInterpreterFromReader(bytes.NewReader([]byte("\x00asm\x02\x00\x00\x00")))
+	assert.ErrorIs(t, err, errInvalidWASMBinary)
+}
+
+func TestSelect(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(func (export "select") (param i32) (result i32)
+				i32.const 10
+				i32.const 20
+				local.get 0
+				select
+			)
+		)
+	`)
+	ret, err := invokeExport(t, &i, "select", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(10)}, ret)
+	ret, err = invokeExport(t, &i, "select", ValueFromI32(0))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(20)}, ret)
+}
+
+func TestReset(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(import "env" "double" (func $double (param i32) (result i32)))
+			(memory (export "memory") 1)
+			(data (i32.const 0) "\01\02")
+			(global $g (export "g") (mut i32) (i32.const 10))
+			(global $starts (export "starts") (mut i32) (i32.const 0))
+			(func $start
+				global.get $starts
+				i32.const 1
+				i32.add
+				global.set $starts
+			)
+			(start $start)
+			(func (export "mutate") (result i32)
+				i32.const 0
+				i32.const 0xff
+				i32.store8
+				i32.const 1
+				memory.grow
+				drop
+				i32.const 7
+				call $double
+				global.set $g
+				global.get $g
+			)
+		)
+	`)
+	assert.NoError(t, i.RegisterFunc("env", "double", []ValType{I32}, []ValType{I32}, func(args []Value) ([]Value, error) {
+		return []Value{ValueFromI32(args[0].I32() * 2)}, nil
+	}))
+	i.SetMaxCallDepth(10)
+
+	ret, err := invokeExport(t, &i, "mutate")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(14)}, ret)
+	mem, err := i.Memory()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0xff), mem[0])
+	assert.Len(t, mem, 2*PAGE_SIZE)
+
+	assert.NoError(t, i.Reset())
+	mem, err = i.Memory()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, mem[:2])
+	assert.Len(t, mem, PAGE_SIZE)
+	g, err := i.GetGlobal("g")
+	assert.NoError(t, err)
+	assert.Equal(t, ValueFromI32(10), g)
+	// the start function ran on the fresh instance only
+	starts, err := i.GetGlobal("starts")
+	assert.NoError(t, err)
+	assert.Equal(t, ValueFromI32(1), starts)
+	assert.Equal(t, 10, i.store.maxCallDepth)
+
+	// the registered host function is still bound
+	ret, err = invokeExport(t, &i, "mutate")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(14)}, ret)
+}
+
+func TestMemoryLimits(t *testing.T) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	cases := []struct {
+		name   string
+		limits []byte
+		err    string
+	}{
+		{name: "min greater than max", limits: []byte{0x01, 0x02, 0x01}, err: "memory 0: min 2 exceeds max 1"},
+		// 65537 pages
+		{name: "min over 4GiB", limits: []byte{0x00, 0x81, 0x80, 0x04}, err: "memory 0: size exceeds 65536 pages"},
+		{name: "max over 4GiB", limits: []byte{0x01, 0x00, 0x81, 0x80, 0x04}, err: "memory 0: size exceeds 65536 pages"},
+		// 2^31 and 2^32-1 pages, neither may read as no max
+		{name: "max over int32", limits: []byte{0x01, 0x00, 0x80, 0x80, 0x80, 0x80, 0x08}, err: "memory 0: size exceeds 65536 pages"},
+		{name: "max u32", limits: []byte{0x01, 0x00, 0xff, 0xff, 0xff, 0xff, 0x0f}, err: "memory 0: size exceeds 65536 pages"},
+		// 2^32 pages of memory64
+		{name: "memory64 min over u32", limits: []byte{0x04, 0x80, 0x80, 0x80, 0x80, 0x10}, err: "memory64 limit 4294967296 exceeds the u32 range"},
+	}
+	for _, c := range cases {
+		section := append([]byte{byte(MemorySection), byte(len(c.limits) + 1), 0x01}, c.limits...)
+		_, err := NewInterpreter(append(append([]byte{}, header...), section...))
+		assert.EqualError(t, err, c.err, c.name)
+	}
+
+	l := NewLinker()
+	l.DefineMemory("env", "mem", 3, 2)
+	wasm, err := wat2wasm(`(module (import "env" "mem" (memory 1)))`)
+	assert.NoError(t, err)
+	_, err = l.Instantiate(wasm)
+	assert.EqualError(t, err, "memory 0: min 3 exceeds max 2")
+}
+
+func TestDataSegmentBounds(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(memory 1)
+			(data (i32.const 65534) "ab")
+		)
+	`)
+	assert.NoError(t, err)
+	i, err := NewInterpreter(wasm)
+	if assert.NoError(t, err) {
+		data, err := i.ReadMemory(uint32(PAGE_SIZE-2), 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("ab"), data)
+	}
+
+	for _, wat := range []string{
+		`(module (memory 1) (data (i32.const 65535) "ab"))`,
+		// a negative offset is a large unsigned address
+		`(module (memory 1) (data (i32.const -1) "a"))`,
+		`(module (memory 0) (data (i32.const 0) "a"))`,
+	} {
+		wasm, err := wat2wasm(wat)
+		assert.NoError(t, err)
+		_, err = NewInterpreter(wasm)
+		assert.ErrorIs(t, err, errOutOfBounds, wat)
+		assert.EqualError(t, err, "out of bounds memory access", wat)
+	}
+}
+
+func TestElemSegmentBounds(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(table 2 funcref)
+			(func $f (result i32) i32.const 42)
+			(elem (i32.const 1) $f)
+			(type $t (func (result i32)))
+			(func (export "call") (param i32) (result i32)
+				local.get 0
+				call_indirect (type $t)
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	i, err := NewInterpreter(wasm)
+	if assert.NoError(t, err) {
+		ret, err := invokeExport(t, &i, "call", ValueFromI32(1))
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+	}
+
+	for _, wat := range []string{
+		`(module (table 2 funcref) (func $f) (elem (i32.const 1) $f $f))`,
+		`(module (table 2 funcref) (func $f) (elem (i32.const -1) $f))`,
+	} {
+		wasm, err := wat2wasm(wat)
+		assert.NoError(t, err)
+		_, err = NewInterpreter(wasm)
+		assert.ErrorIs(t, err, errOutOfBoundsTable, wat)
+	}
+}
@@ -0,0 +1,136 @@
+package wasm_go
+
+import "fmt"
+
+// Linker holds host definitions and resolves the imports of the modules it instantiates.
+type Linker struct {
+	funcs   map[importKey]hostFuncDef
+	globals map[importKey]globalInst
+	mems    map[importKey]memType
+	tables  map[importKey]tableType
+}
+
+type importKey struct {
+	module string
+	name   string
+}
+
+type hostFuncDef struct {
+	funcType funcType
+	fn       HostFunc
+	memFn    hostMemFunc
+}
+
+func NewLinker() *Linker {
+	return &Linker{
+		funcs:   map[importKey]hostFuncDef{},
+		globals: map[importKey]globalInst{},
+		mems:    map[importKey]memType{},
+		tables:  map[importKey]tableType{},
+	}
+}
+
+// DefineFunc provides fn for the function import module.name, which must have the given signature.
+func (l *Linker) DefineFunc(module, name string, params, results []ValType, fn HostFunc) {
+	l.funcs[importKey{module, name}] = hostFuncDef{
+		funcType: funcType{params: params, results: results},
+		fn:       fn,
+	}
+}
+
+// defineMemFunc is like DefineFunc for host functions that access the memory of the importing module.
+func (l *Linker) defineMemFunc(module, name string, params, results []ValType, fn hostMemFunc) {
+	l.funcs[importKey{module, name}] = hostFuncDef{
+		funcType: funcType{params: params, results: results},
+		memFn:    fn,
+	}
+}
+
+// DefineGlobal provides v as the initial value of the global import module.name.
+func (l *Linker) DefineGlobal(module, name string, v Value, mutable bool) {
+	mut := const_
+	if mutable {
+		mut = var_
+	}
+	l.globals[importKey{module, name}] = globalInst{
+		globalType: globalType{valueType: v.ValType, mut: mut},
+		value:      v,
+	}
+}
+
+// DefineMemory provides a zeroed memory of min pages for the memory import module.name,
+// max is -1 when the memory can grow without limit.
+func (l *Linker) DefineMemory(module, name string, min uint32, max int32) {
+	l.mems[importKey{module, name}] = memType{limits: limits{Min: min, Max: max}}
+}
+
+// DefineTable provides a table of min null elements of elemType, FuncRef or ExternRef,
+// for the table import module.name, max is -1 when the table can grow without limit.
+func (l *Linker) DefineTable(module, name string, elemType ValType, min uint32, max int32) {
+	l.tables[importKey{module, name}] = tableType{elemType: elemType, limits: limits{Min: min, Max: max}}
+}
+
+// Instantiate parses a module and instantiates it with its imports resolved against l.
+// Unlike NewInterpreter, every import must be defined, including globals, memories and tables.
+func (l *Linker) Instantiate(bytes []byte) (Interpreter, error) {
+	p := newParser(bytes)
+	m, err := p.parse()
+	if err != nil {
+		return Interpreter{}, err
+	}
+	return newInterpreter(m, l)
+}
+
+// https://webassembly.github.io/spec/core/exec/modules.html#import-matching
+func (l *Linker) resolveFunc(imp import_, t funcType) (hostFuncDef, error) {
+	def, ok := l.funcs[importKey{imp.module, imp.name}]
+	if !ok {
+		return def, fmt.Errorf("unknown import %s.%s", imp.module, imp.name)
+	}
+	if !def.funcType.equal(t) {
+		return def, fmt.Errorf("incompatible import type for %s.%s", imp.module, imp.name)
+	}
+	return def, nil
+}
+
+func (l *Linker) resolveGlobal(imp import_) (globalInst, error) {
+	def, ok := l.globals[importKey{imp.module, imp.name}]
+	if !ok {
+		return globalInst{}, fmt.Errorf("unknown import %s.%s", imp.module, imp.name)
+	}
+	if def.globalType != imp.importDesc.global {
+		return globalInst{}, fmt.Errorf("incompatible import type for %s.%s", imp.module, imp.name)
+	}
+	return def, nil
+}
+
+func (l *Linker) resolveMem(idx int, imp import_) (memInst, error) {
+	def, ok := l.mems[importKey{imp.module, imp.name}]
+	if !ok {
+		return memInst{}, fmt.Errorf("unknown import %s.%s", imp.module, imp.name)
+	}
+	if def.is64 != imp.importDesc.mem.is64 || !limitsMatch(def.limits, imp.importDesc.mem.limits) {
+		return memInst{}, fmt.Errorf("incompatible import type for %s.%s", imp.module, imp.name)
+	}
+	return newMemInst(idx, def)
+}
+
+func (l *Linker) resolveTable(imp import_) (tableInst, error) {
+	def, ok := l.tables[importKey{imp.module, imp.name}]
+	if !ok {
+		return tableInst{}, fmt.Errorf("unknown import %s.%s", imp.module, imp.name)
+	}
+	if def.elemType != imp.importDesc.table.elemType || !limitsMatch(def.limits, imp.importDesc.table.limits) {
+		return tableInst{}, fmt.Errorf("incompatible import type for %s.%s", imp.module, imp.name)
+	}
+	if def.limits.Max >= 0 && def.limits.Min > uint32(def.limits.Max) {
+		return tableInst{}, fmt.Errorf("table %s.%s: min %d exceeds max %d", imp.module, imp.name, def.limits.Min, def.limits.Max)
+	}
+	return tableInst{tableType: def, elems: nullRefs(int(def.limits.Min))}, nil
+}
+
+// https://webassembly.github.io/spec/core/valid/types.html#match-limits
+// the definition may be larger, but must not allow growing beyond the import's maximum
+func limitsMatch(def, want limits) bool {
+	return def.Min >= want.Min && (want.Max < 0 || (def.Max >= 0 && def.Max <= want.Max))
+}
@@ -0,0 +1,313 @@
+package wasm_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinker(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(import "env" "add" (func $add (param i32 i32) (result i32)))
+			(import "env" "base" (global $base i32))
+			(import "env" "counter" (global $counter (mut i64)))
+			(import "env" "mem" (memory 1 2))
+			(global $init i32 (global.get $base))
+			(global $ran (mut i32) (i32.const 0))
+			(export "counter" (global $counter))
+			(export "ran" (global $ran))
+			(func $start
+				i32.const 1
+				global.set $ran
+			)
+			(start $start)
+			(func (export "run") (param i32) (result i32)
+				global.get $counter
+				i64.const 1
+				i64.add
+				global.set $counter
+				i32.const 0
+				local.get 0
+				global.get $init
+				call $add
+				i32.store
+				i32.const 0
+				i32.load
+			)
+		)
+	`)
+	assert.NoError(t, err)
+
+	l := NewLinker()
+	l.DefineFunc("env", "add", []ValType{I32, I32}, []ValType{I32}, func(args []Value) ([]Value, error) {
+		return []Value{ValueFromI32(args[0].I32() + args[1].I32())}, nil
+	})
+	l.DefineGlobal("env", "base", ValueFromI32(40), false)
+	l.DefineGlobal("env", "counter", ValueFromI64(0), true)
+	l.DefineMemory("env", "mem", 1, 2)
+
+	i, err := l.Instantiate(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ran, err := i.GetGlobal("ran")
+	assert.NoError(t, err)
+	assert.Equal(t, ValueFromI32(1), ran)
+
+	ret, err := invokeExport(t, &i, "run", ValueFromI32(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+	counter, err := i.GetGlobal("counter")
+	assert.NoError(t, err)
+	assert.Equal(t, ValueFromI64(1), counter)
+	mem, err := i.Memory()
+	assert.NoError(t, err)
+	assert.Len(t, mem, PAGE_SIZE)
+
+	cases := []struct {
+		name   string
+		define func(l *Linker)
+		err    string
+	}{
+		{
+			name:   "missing func",
+			define: func(l *Linker) { delete(l.funcs, importKey{"env", "add"}) },
+			err:    "unknown import env.add",
+		},
+		{
+			name: "func signature",
+			define: func(l *Linker) {
+				l.DefineFunc("env", "add", []ValType{I64, I64}, []ValType{I64}, nil)
+			},
+			err: "incompatible import type for env.add",
+		},
+		{
+			name:   "global type",
+			define: func(l *Linker) { l.DefineGlobal("env", "base", ValueFromF32(1), false) },
+			err:    "incompatible import type for env.base",
+		},
+		{
+			name:   "global mutability",
+			define: func(l *Linker) { l.DefineGlobal("env", "counter", ValueFromI64(0), false) },
+			err:    "incompatible import type for env.counter",
+		},
+		{
+			name:   "memory without maximum",
+			define: func(l *Linker) { l.DefineMemory("env", "mem", 1, -1) },
+			err:    "incompatible import type for env.mem",
+		},
+		{
+			name:   "missing memory",
+			define: func(l *Linker) { delete(l.mems, importKey{"env", "mem"}) },
+			err:    "unknown import env.mem",
+		},
+	}
+	for _, c := range cases {
+		l := NewLinker()
+		l.DefineFunc("env", "add", []ValType{I32, I32}, []ValType{I32}, nil)
+		l.DefineGlobal("env", "base", ValueFromI32(40), false)
+		l.DefineGlobal("env", "counter", ValueFromI64(0), true)
+		l.DefineMemory("env", "mem", 2, 2)
+		c.define(l)
+		_, err := l.Instantiate(wasm)
+		assert.EqualError(t, err, c.err, c.name)
+	}
+}
+
+func TestLinkerImportedGlobal(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(import "env" "seed" (global $seed i32))
+			(import "env" "total" (global $total (mut i32)))
+			(global $local (mut i32) (i32.const 100))
+			(export "total" (global $total))
+			(func (export "add") (param i32)
+				global.get $total
+				local.get 0
+				i32.add
+				global.set $total
+				global.get $local
+				global.get $seed
+				i32.add
+				global.set $local
+			)
+			(func (export "local") (result i32)
+				global.get $local
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	l := NewLinker()
+	l.DefineGlobal("env", "seed", ValueFromI32(1), false)
+	l.DefineGlobal("env", "total", ValueFromI32(10), true)
+	i, err := l.Instantiate(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = invokeExport(t, &i, "add", ValueFromI32(5))
+	assert.NoError(t, err)
+	total, err := i.GetGlobal("total")
+	assert.NoError(t, err)
+	assert.Equal(t, ValueFromI32(15), total)
+
+	// the host's change is seen by the module
+	assert.NoError(t, i.SetGlobal("total", ValueFromI32(20)))
+	_, err = invokeExport(t, &i, "add", ValueFromI32(1))
+	assert.NoError(t, err)
+	total, err = i.GetGlobal("total")
+	assert.NoError(t, err)
+	assert.Equal(t, ValueFromI32(21), total)
+
+	// the module's own global comes after the imported ones
+	ret, err := invokeExport(t, &i, "local")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(102)}, ret)
+}
+
+func TestLinkerImportedMemory(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(import "env" "mem" (memory 1 1))
+			(func (export "load") (param i32) (result i32)
+				local.get 0
+				i32.load
+			)
+			(func (export "grow") (result i32)
+				i32.const 1
+				memory.grow
+			)
+		)
+	`)
+	assert.NoError(t, err)
+
+	_, err = NewInterpreter(wasm)
+	assert.EqualError(t, err, "unresolved import: imported memory env.mem needs a Linker")
+
+	l := NewLinker()
+	l.DefineMemory("env", "mem", 1, 1)
+	i, err := l.Instantiate(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, i.WriteMemory(uint32(PAGE_SIZE-4), []byte{0x2a, 0, 0, 0}))
+	ret, err := invokeExport(t, &i, "load", ValueFromI32(int32(PAGE_SIZE-4)))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(42)}, ret)
+	_, err = invokeExport(t, &i, "load", ValueFromI32(int32(PAGE_SIZE-3)))
+	assert.ErrorIs(t, err, errOutOfBounds)
+
+	// the imported maximum holds
+	ret, err = invokeExport(t, &i, "grow")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(-1)}, ret)
+
+	l.DefineMemory("env", "mem", 0, 1)
+	_, err = l.Instantiate(wasm)
+	assert.EqualError(t, err, "incompatible import type for env.mem")
+}
+
+func TestLinkerImportedTable(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(import "env" "tab" (table 2 4 funcref))
+			(table $own 1 funcref)
+			(type $ret (func (result i32)))
+			(func $seven (result i32) i32.const 7)
+			(elem (table 0) (i32.const 1) func $seven)
+			(elem (table $own) (i32.const 0) func $seven)
+			(func (export "call") (param i32) (result i32)
+				local.get 0
+				call_indirect (type $ret)
+			)
+			(func (export "own") (result i32)
+				i32.const 0
+				call_indirect $own (type $ret)
+			)
+			(func (export "grow") (result i32)
+				ref.null func
+				i32.const 3
+				table.grow 0
+			)
+		)
+	`)
+	assert.NoError(t, err)
+
+	l := NewLinker()
+	l.DefineTable("env", "tab", FuncRef, 2, 4)
+	i, err := l.Instantiate(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ret, err := invokeExport(t, &i, "call", ValueFromI32(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(7)}, ret)
+	_, err = invokeExport(t, &i, "call", ValueFromI32(0))
+	assert.ErrorIs(t, err, errUninitializedElement)
+	// the module's own table comes after the imported one
+	ret, err = invokeExport(t, &i, "own")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(7)}, ret)
+	r, err := i.TableGet(1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Ref{Index: 0}, r)
+
+	// the imported maximum holds
+	ret, err = invokeExport(t, &i, "grow")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(-1)}, ret)
+
+	cases := []struct {
+		name  string
+		table func(l *Linker)
+	}{
+		{"smaller min", func(l *Linker) { l.DefineTable("env", "tab", FuncRef, 1, 4) }},
+		{"larger max", func(l *Linker) { l.DefineTable("env", "tab", FuncRef, 2, 5) }},
+		{"no max", func(l *Linker) { l.DefineTable("env", "tab", FuncRef, 2, -1) }},
+		{"elem type", func(l *Linker) { l.DefineTable("env", "tab", ExternRef, 2, 4) }},
+	}
+	for _, c := range cases {
+		c.table(l)
+		_, err = l.Instantiate(wasm)
+		assert.EqualError(t, err, "incompatible import type for env.tab", c.name)
+	}
+
+	delete(l.tables, importKey{"env", "tab"})
+	_, err = l.Instantiate(wasm)
+	assert.EqualError(t, err, "unknown import env.tab")
+}
+
+func TestUnresolvedImportWithoutLinker(t *testing.T) {
+	tests := []struct {
+		name string
+		wat  string
+		err  string
+	}{
+		{
+			name: "global",
+			wat: `(module
+				(import "env" "base" (global i32))
+				(global i32 (i32.const 7))
+				(func (export "get") (result i32) global.get 0)
+			)`,
+			err: "unresolved import: imported global env.base needs a Linker",
+		},
+		{
+			name: "table",
+			wat: `(module
+				(import "env" "tab" (table 1 funcref))
+				(table 1 funcref)
+			)`,
+			err: "unresolved import: imported table env.tab needs a Linker",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wasm, err := wat2wasm(tt.wat)
+			assert.NoError(t, err)
+			_, err = NewInterpreter(wasm)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package wasm_go
+
+// Module is a parsed module, it can be inspected without being instantiated.
+type Module struct {
+	m module
+}
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params  []ValType
+	Results []ValType
+}
+
+// Import is an import of a module, Kind is one of "func", "table", "memory" or "global".
+type Import struct {
+	Module string
+	Name   string
+	Kind   string
+}
+
+// Export is an export of a module, Index is in the index space of its Kind.
+type Export struct {
+	Name  string
+	Kind  string
+	Index uint32
+}
+
+// Limits are in pages for memories and in elements for tables, Max is -1 when there is no maximum.
+type Limits struct {
+	Min uint32
+	Max int32
+}
+
+// Custom is the content of a custom section, including the "name" section.
+type Custom struct {
+	Name string
+	Data []byte
+}
+
+func Parse(bytes []byte) (*Module, error) {
+	p := newParser(bytes)
+	m, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Module{m: m}, nil
+}
+
+// Types returns the type section.
+func (m *Module) Types() []FuncType {
+	types := make([]FuncType, len(m.m.types))
+	for i, t := range m.m.types {
+		types[i] = newFuncType(t)
+	}
+	return types
+}
+
+// FuncType returns the signature of the func at idx, imported funcs come first.
+func (m *Module) FuncType(idx uint32) (FuncType, bool) {
+	t, err := m.m.funcType(idx)
+	if err != nil {
+		return FuncType{}, false
+	}
+	return newFuncType(t), true
+}
+
+func (m *Module) Imports() []Import {
+	imports := make([]Import, len(m.m.imports))
+	for i, imp := range m.m.imports {
+		imports[i] = Import{Module: imp.module, Name: imp.name, Kind: imp.kind.String()}
+	}
+	return imports
+}
+
+func (m *Module) Exports() []Export {
+	exports := make([]Export, len(m.m.exports))
+	for i, export := range m.m.exports {
+		exports[i] = Export{Name: export.name, Kind: export.kind.String(), Index: export.idx}
+	}
+	return exports
+}
+
+// Memories returns the limits of the imported and then the defined memories.
+func (m *Module) Memories() []Limits {
+	var mems []Limits
+	for _, imp := range m.m.imports {
+		if imp.kind == exportImportKindMem {
+			mems = append(mems, Limits(imp.importDesc.mem.limits))
+		}
+	}
+	for _, mem := range m.m.mems {
+		mems = append(mems, Limits(mem.limits))
+	}
+	return mems
+}
+
+func (m *Module) CustomSections() []Custom {
+	customs := make([]Custom, len(m.m.customs))
+	for i, c := range m.m.customs {
+		customs[i] = Custom{Name: c.name, Data: c.data}
+	}
+	return customs
+}
+
+func newFuncType(t funcType) FuncType {
+	return FuncType{Params: t.params, Results: t.results}
+}
@@ -0,0 +1,49 @@
+package wasm_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(import "env" "log" (func (param i32)))
+			(memory (export "memory") 1 2)
+			(func $add (param i32) (param i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.add
+			)
+			(export "add" (func $add))
+		)
+	`)
+	assert.NoError(t, err)
+	m, err := Parse(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []Export{
+		{Name: "memory", Kind: "memory", Index: 0},
+		{Name: "add", Kind: "func", Index: 1},
+	}, m.Exports())
+	assert.Equal(t, []Import{{Module: "env", Name: "log", Kind: "func"}}, m.Imports())
+	assert.Equal(t, []Limits{{Min: 1, Max: 2}}, m.Memories())
+	assert.Len(t, m.Types(), 2)
+
+	add, ok := m.FuncType(m.Exports()[1].Index)
+	assert.True(t, ok)
+	assert.Equal(t, FuncType{Params: []ValType{I32, I32}, Results: []ValType{I32}}, add)
+	_, ok = m.FuncType(2)
+	assert.False(t, ok)
+
+	// wat2wasm keeps the $add name in the name section
+	if assert.Len(t, m.CustomSections(), 1) {
+		assert.Equal(t, "name", m.CustomSections()[0].Name)
+	}
+
+	_, err = Parse([]byte{0x00, 0x61, 0x73})
+	assert.Error(t, err)
+}
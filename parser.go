@@ -1,35 +1,67 @@
 package wasm_go
 
 import (
+	"bufio"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 )
 
 var errInvalidWASMBinary = errors.New("invalid wasm binary magic")
 
 const WASM_MAGIC uint32 = 0x6d736100
 
+// set in the align of a memarg when an explicit memory index follows
+const memArgMemIdxFlag uint32 = 0x40
+
 // https://webassembly.github.io/spec/core/binary/modules.html#sections
 type SectionID uint8
 
 const (
-	CustomSection   SectionID = 0x00
-	TypeSection     SectionID = 0x01
-	ImportSection   SectionID = 0x02
-	FunctionSection SectionID = 0x03
-	TableSection    SectionID = 0x04
-	MemorySection   SectionID = 0x05
-	GlobalSection   SectionID = 0x06
-	ExportSection   SectionID = 0x07
-	StartSection    SectionID = 0x08
-	ElementSection  SectionID = 0x09
-	CodeSection     SectionID = 0x0a
-	DataSection     SectionID = 0x0b
+	CustomSection    SectionID = 0x00
+	TypeSection      SectionID = 0x01
+	ImportSection    SectionID = 0x02
+	FunctionSection  SectionID = 0x03
+	TableSection     SectionID = 0x04
+	MemorySection    SectionID = 0x05
+	GlobalSection    SectionID = 0x06
+	ExportSection    SectionID = 0x07
+	StartSection     SectionID = 0x08
+	ElementSection   SectionID = 0x09
+	CodeSection      SectionID = 0x0a
+	DataSection      SectionID = 0x0b
+	DataCountSection SectionID = 0x0c
 )
 
+// sectionOrder is the position of each non-custom section in a module,
+// the data count section sits between the element and code sections.
+var sectionOrder = map[SectionID]int{
+	TypeSection:      1,
+	ImportSection:    2,
+	FunctionSection:  3,
+	TableSection:     4,
+	MemorySection:    5,
+	GlobalSection:    6,
+	ExportSection:    7,
+	StartSection:     8,
+	ElementSection:   9,
+	DataCountSection: 10,
+	CodeSection:      11,
+	DataSection:      12,
+}
+
 type parser struct {
 	r leb128Reader
+	// function types, used to resolve block types
+	types []funcType
+	// when set, called with the raw bytes of every instr of a function body
+	onCodeInstr func(funcIdx int, raw []byte)
+	// sectionOrder of the last non-custom section
+	lastSection int
+	// memories in the memory index space, a memarg offset is a u64 for memory64
+	mems []memType
 }
 
 func newParser(bytes []byte) parser {
@@ -41,13 +73,9 @@ func newParser(bytes []byte) parser {
 // https://webassembly.github.io/spec/core/binary/modules.html#binary-module
 func (p *parser) parse() (module, error) {
 	m := module{}
-	magic, version, err := p.header()
-	if err != nil {
+	if err := p.preamble(); err != nil {
 		return m, err
 	}
-	if magic != WASM_MAGIC || version != 1 {
-		return m, errInvalidWASMBinary
-	}
 
 	for {
 		sid, length, err := p.sectionHeader()
@@ -58,38 +86,157 @@ func (p *parser) parse() (module, error) {
 		if err != nil {
 			return m, err
 		}
+		// sections are parsed on their own bytes so none can read into the next one
+		content, err := p.r.eatBytes(length)
+		if err != nil {
+			return m, fmt.Errorf("section %d exceeds the module length", sid)
+		}
+		r := p.r
+		p.r = leb128Reader{bytes: content}
+		err = p.section(&m, sid, length)
+		p.r = r
+		if err != nil {
+			return m, err
+		}
+	}
+	return m, m.checkDataCount()
+}
 
-		switch sid {
-		case CustomSection:
-			m.custom, err = p.customSection(length)
-		case TypeSection:
-			m.types, err = p.typeSection()
-		case ImportSection:
-			m.imports, err = p.importSection()
-		case FunctionSection:
-			m.funcs, err = p.funcSection()
-		case TableSection:
-			m.tables, err = p.tableSection()
-		case MemorySection:
-			m.mems, err = p.memorySection()
-		case GlobalSection:
-			m.globals, err = p.globalSection()
-		case ExportSection:
-			m.exports, err = p.exportSection()
-		case StartSection:
-			m.start, err = p.startSection()
-		case ElementSection:
-			m.elems, err = p.elemSection()
-		case CodeSection:
-			err = p.codeSection(m.funcs)
-		case DataSection:
-			m.datas, err = p.dataSection()
+// parseReader parses a module from r, only the section being parsed is buffered.
+func parseReader(r io.Reader) (module, error) {
+	m := module{}
+	br := bufio.NewReader(r)
+
+	preamble := make([]byte, 8)
+	if _, err := io.ReadFull(br, preamble); err != nil {
+		return m, err
+	}
+	p := newParser(preamble)
+	if err := p.preamble(); err != nil {
+		return m, err
+	}
+
+	for {
+		sid, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return m, err
 		}
+		length, err := readU32(br)
 		if err != nil {
 			return m, err
 		}
+		content := make([]byte, length)
+		if _, err := io.ReadFull(br, content); err != nil {
+			return m, err
+		}
+		p.r = leb128Reader{bytes: content}
+		if err := p.section(&m, SectionID(sid), length); err != nil {
+			return m, err
+		}
 	}
-	return m, nil
+	return m, m.checkDataCount()
+}
+
+// readU32 reads a LEB128 u32 one byte at a time.
+func readU32(br io.ByteReader) (uint32, error) {
+	var bs []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		bs = append(bs, b)
+		// an overlong u32 is rejected by eatU32 without reading any further
+		if b&0x80 == 0 || len(bs) == 5 {
+			break
+		}
+	}
+	r := leb128Reader{bytes: bs}
+	return r.eatU32()
+}
+
+func (p *parser) preamble() error {
+	magic, version, err := p.header()
+	if err != nil {
+		return err
+	}
+	if magic != WASM_MAGIC || version != 1 {
+		return errInvalidWASMBinary
+	}
+	return nil
+}
+
+// section parses the content of the section sid into m.
+func (p *parser) section(m *module, sid SectionID, length uint32) error {
+	if order, ok := sectionOrder[sid]; ok {
+		if order == p.lastSection {
+			return fmt.Errorf("duplicate section %d", sid)
+		}
+		if order < p.lastSection {
+			return fmt.Errorf("section %d out of order", sid)
+		}
+		p.lastSection = order
+	}
+	var err error
+	switch sid {
+	case CustomSection:
+		var c custom
+		c, err = p.customSection()
+		if err != nil {
+			break
+		}
+		m.customs = append(m.customs, c)
+		if c.name == "name" {
+			names := newParser(c.data)
+			m.names, err = names.nameSection()
+		}
+	case TypeSection:
+		m.types, err = p.typeSection()
+		p.types = m.types
+	case ImportSection:
+		m.imports, err = p.importSection()
+	case FunctionSection:
+		m.funcs, err = p.funcSection()
+	case TableSection:
+		m.tables, err = p.tableSection()
+	case MemorySection:
+		m.mems, err = p.memorySection()
+	case GlobalSection:
+		m.globals, err = p.globalSection()
+	case ExportSection:
+		m.exports, err = p.exportSection()
+	case StartSection:
+		m.start, err = p.startSection()
+	case ElementSection:
+		m.elems, err = p.elemSection()
+	case CodeSection:
+		p.mems = m.memTypes()
+		err = p.codeSection(m.funcs)
+	case DataSection:
+		m.datas, err = p.dataSection()
+	case DataCountSection:
+		m.dataCount, err = p.dataCountSection()
+	default:
+		// sections of proposals we don't implement are skipped whole
+		p.r.pos = len(p.r.bytes)
+	}
+	if err != nil {
+		return err
+	}
+	if p.r.pos != len(p.r.bytes) {
+		return fmt.Errorf("section %d size mismatch", sid)
+	}
+	return nil
+}
+
+func (m *module) checkDataCount() error {
+	if m.dataCount != nil && int(*m.dataCount) != len(m.datas) {
+		return fmt.Errorf("data count and data section have inconsistent lengths")
+	}
+	return nil
 }
 
 func (p *parser) header() (magic, version uint32, err error) {
@@ -119,20 +266,84 @@ func (p *parser) sectionHeader() (sid SectionID, length uint32, err error) {
 }
 
 // https://webassembly.github.io/spec/core/binary/modules.html#custom-section
-func (p *parser) customSection(length uint32) (custom, error) {
+func (p *parser) customSection() (custom, error) {
 	c, err := custom{}, error(nil)
 	c.name, err = p.name()
+	if err == io.EOF {
+		return c, fmt.Errorf("custom section name exceeds the section length")
+	}
 	if err != nil {
 		return c, err
 	}
-	c.data, err = p.r.eatBytes(length - (uint32(len(c.name) + 4)))
-	return c, err
+	// the rest of the section
+	c.data = p.r.bytes[p.r.pos:]
+	p.r.pos = len(p.r.bytes)
+	return c, nil
+}
+
+// https://webassembly.github.io/spec/core/appendix/custom.html#name-section
+func (p *parser) nameSection() (names, error) {
+	n := names{}
+	for p.r.pos < len(p.r.bytes) {
+		id, err := p.r.eatU8()
+		if err != nil {
+			return n, err
+		}
+		size, err := p.r.eatU32()
+		if err != nil {
+			return n, err
+		}
+		end := p.r.pos + int(size)
+		switch id {
+		case 0:
+			n.module, err = p.name()
+		case 1:
+			n.funcs, err = p.nameMap()
+		case 2:
+			var count uint32
+			count, err = p.r.eatVecLen()
+			n.locals = make(map[uint32]map[uint32]string, count)
+			for i := uint32(0); err == nil && i < count; i++ {
+				var funcIdx uint32
+				funcIdx, err = p.r.eatU32()
+				if err == nil {
+					n.locals[funcIdx], err = p.nameMap()
+				}
+			}
+		}
+		if err != nil {
+			return n, err
+		}
+		// skip unknown subsections
+		p.r.pos = end
+	}
+	return n, nil
+}
+
+// namemap ::= vec(idx name)
+func (p *parser) nameMap() (map[uint32]string, error) {
+	count, err := p.r.eatVecLen()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[uint32]string, count)
+	for i := uint32(0); i < count; i++ {
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return m, err
+		}
+		m[idx], err = p.name()
+		if err != nil {
+			return m, err
+		}
+	}
+	return m, nil
 }
 
 // https://webassembly.github.io/spec/core/binary/modules.html#type-section
 func (p *parser) typeSection() ([]funcType, error) {
 	var funcTypes []funcType
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return funcTypes, err
 	}
@@ -183,7 +394,7 @@ func (p *parser) typeSection() ([]funcType, error) {
 // The and fields of the respective functions are encoded separately in the code section.
 func (p *parser) funcSection() ([]function, error) {
 	var funcs []function
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return funcs, err
 	}
@@ -202,7 +413,7 @@ func (p *parser) funcSection() ([]function, error) {
 // https://webassembly.github.io/spec/core/binary/modules.html#table-section
 func (p *parser) tableSection() ([]table, error) {
 	var tables []table
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return tables, err
 	}
@@ -223,7 +434,14 @@ func (p *parser) table() (table, error) {
 		return t, err
 	}
 	t.elemType = type_(elemType)
-	t.limits, err = p.limits()
+	var shared, is64 bool
+	t.limits, shared, is64, err = p.limits()
+	if err == nil && shared {
+		err = fmt.Errorf("tables can't be shared")
+	}
+	if err == nil && is64 {
+		err = fmt.Errorf("tables can't have 64-bit limits")
+	}
 	return t, err
 }
 
@@ -231,7 +449,7 @@ func (p *parser) table() (table, error) {
 // (memory 1)
 func (p *parser) memorySection() ([]mem, error) {
 	var mems []mem
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return mems, err
 	}
@@ -247,15 +465,17 @@ func (p *parser) memorySection() ([]mem, error) {
 
 func (p *parser) memory() (mem, error) {
 	m := mem{}
-	limits, err := p.limits()
+	limits, shared, is64, err := p.limits()
 	m.limits = limits
+	m.shared = shared
+	m.is64 = is64
 	return m, err
 }
 
 // https://webassembly.github.io/spec/core/binary/modules.html#global-section
 func (p *parser) globalSection() ([]global, error) {
 	var globals []global
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return globals, err
 	}
@@ -275,26 +495,58 @@ func (p *parser) globalSection() ([]global, error) {
 	return globals, nil
 }
 
-// elem ::= { table tableidx, offset expr, init vec(funcidx) }
+// https://webassembly.github.io/spec/core/binary/modules.html#element-section
+// The leading flags select the mode and encoding of each segment:
+// bit 0 passive or declarative, bit 1 explicit table index or declarative, bit 2 init exprs.
 func (p *parser) elemSection() ([]elem, error) {
 	var elems []elem
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return elems, err
 	}
 	elems = make([]elem, count)
 
 	for i := uint32(0); i < count; i++ {
-		tableIdx, err := p.r.eatU32()
+		flags, err := p.r.eatU32()
 		if err != nil {
 			return elems, err
 		}
-		elems[i].tableIdx = tableIdx
-		elems[i].offset, err = p.expr()
-		if err != nil {
-			return elems, err
+		if flags > 3 {
+			return elems, fmt.Errorf("unsupported element segment flags: %d", flags)
 		}
-		funcIdxCount, err := p.r.eatU32()
+		elems[i].elemType = FuncRef
+
+		switch {
+		case flags&0x01 == 0:
+			elems[i].mode = elemModeActive
+			if flags&0x02 != 0 {
+				elems[i].tableIdx, err = p.r.eatU32()
+				if err != nil {
+					return elems, err
+				}
+			}
+			elems[i].offset, err = p.expr()
+			if err != nil {
+				return elems, err
+			}
+		case flags&0x02 == 0:
+			elems[i].mode = elemModePassive
+		default:
+			elems[i].mode = elemModeDeclarative
+		}
+
+		if flags != 0 {
+			// elemkind, only funcref (0x00) is defined
+			elemKind, err := p.r.eatU8()
+			if err != nil {
+				return elems, err
+			}
+			if elemKind != 0x00 {
+				return elems, fmt.Errorf("invalid elemkind %x", elemKind)
+			}
+		}
+
+		funcIdxCount, err := p.r.eatVecLen()
 		if err != nil {
 			return elems, err
 		}
@@ -310,25 +562,38 @@ func (p *parser) elemSection() ([]elem, error) {
 	return elems, nil
 }
 
-// https://www.w3.org/TR/wasm-core-1/#data-segments%E2%91%A0
-// data ::= {data memidx, offset expr, init vec(byte)}
+// https://webassembly.github.io/spec/core/binary/modules.html#data-section
+// flags 0: active in memory 0, 1: passive, 2: active with an explicit memidx
 func (p *parser) dataSection() ([]data, error) {
 	var datas []data
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return datas, err
 	}
 	datas = make([]data, count)
 
 	for i := uint32(0); i < count; i++ {
-		memIdx, err := p.r.eatU32()
+		flags, err := p.r.eatU32()
 		if err != nil {
 			return datas, err
 		}
-		datas[i].memIdx = memIdx
-		datas[i].offset, err = p.expr()
-		if err != nil {
-			return datas, err
+		switch flags {
+		case 0, 2:
+			datas[i].mode = dataModeActive
+			if flags == 2 {
+				datas[i].memIdx, err = p.r.eatU32()
+				if err != nil {
+					return datas, err
+				}
+			}
+			datas[i].offset, err = p.expr()
+			if err != nil {
+				return datas, err
+			}
+		case 1:
+			datas[i].mode = dataModePassive
+		default:
+			return datas, fmt.Errorf("invalid data segment flags: %d", flags)
 		}
 
 		initCount, err := p.r.eatU32()
@@ -344,9 +609,18 @@ func (p *parser) dataSection() ([]data, error) {
 	return datas, nil
 }
 
+// https://webassembly.github.io/spec/core/binary/modules.html#data-count-section
+func (p *parser) dataCountSection() (*uint32, error) {
+	count, err := p.r.eatU32()
+	if err != nil {
+		return nil, err
+	}
+	return &count, nil
+}
+
 func (p *parser) importSection() ([]import_, error) {
 	var imports []import_
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return imports, err
 	}
@@ -366,8 +640,9 @@ func (p *parser) importSection() ([]import_, error) {
 		if err != nil {
 			return imports, err
 		}
+		imports[i].kind = exportImportKind(kind)
 
-		switch exportImportKind(kind) {
+		switch imports[i].kind {
 		case exportImportKindFunc:
 			imports[i].importDesc.typeIdx, err = p.r.eatU32()
 		case exportImportKindTable:
@@ -387,7 +662,7 @@ func (p *parser) importSection() ([]import_, error) {
 // https://webassembly.github.io/spec/core/binary/modules.html#export-section
 func (p *parser) exportSection() ([]export, error) {
 	var exports []export
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return exports, err
 	}
@@ -412,14 +687,14 @@ func (p *parser) exportSection() ([]export, error) {
 	return exports, nil
 }
 
-func (p *parser) startSection() (start, error) {
+func (p *parser) startSection() (*start, error) {
 	s, err := p.r.eatU32()
-	return start{funcIdx: s}, err
+	return &start{funcIdx: s}, err
 }
 
 // https://webassembly.github.io/spec/core/binary/modules.html#code-section
 func (p *parser) codeSection(fs []function) error {
-	count, err := p.r.eatU32()
+	count, err := p.r.eatVecLen()
 	if err != nil {
 		return err
 	}
@@ -434,7 +709,7 @@ func (p *parser) codeSection(fs []function) error {
 		if err != nil {
 			return err
 		}
-		localsCount, err := p.r.eatU32()
+		localsCount, err := p.r.eatVecLen()
 		if err != nil {
 			return nil
 		}
@@ -453,17 +728,24 @@ func (p *parser) codeSection(fs []function) error {
 		}
 
 		fs[i].body = []instr{}
+		codeStart := p.r.pos
 		for {
+			start := p.r.pos
 			instr, _, err := p.instr()
 			if err != nil {
 				return err
 			}
+			if p.onCodeInstr != nil {
+				p.onCodeInstr(int(i), p.r.bytes[start:p.r.pos])
+			}
 			fs[i].body = append(fs[i].body, instr)
+			fs[i].ops = append(fs[i].ops, rawInstrOp(p.r.bytes[start:p.r.pos]))
 			if p.r.pos >= funcEnd {
 				break
 			}
 		}
-		if err != nil {
+		fs[i].code = p.r.bytes[codeStart:p.r.pos]
+		if err := resolveJumpAddrs(fs[i].body); err != nil {
 			return err
 		}
 	}
@@ -483,29 +765,62 @@ func (p *parser) globalType() (globalType, error) {
 }
 
 // https://webassembly.github.io/spec/core/binary/types.html#limits
-func (p *parser) limits() (limits, error) {
-	var l limits
-	limits, err := p.r.eatU32()
+// https://github.com/WebAssembly/threads/blob/main/proposals/threads/Overview.md#spec-changes
+// https://github.com/WebAssembly/memory64/blob/main/proposals/memory64/Overview.md#binary-format
+// the threads proposal marks shared memories with bit 1 of the flags, they must have a max,
+// memory64 marks i64 addressed memories with bit 2, their limits are u64
+func (p *parser) limits() (l limits, shared, is64 bool, err error) {
+	flags, err := p.r.eatU32()
 	if err != nil {
-		return l, err
+		return
+	}
+	if flags > 7 {
+		err = fmt.Errorf("invalid limits flags 0x%02x", flags)
+		return
+	}
+	hasMax := flags&0x01 != 0
+	shared, is64 = flags&0x02 != 0, flags&0x04 != 0
+	if shared && !hasMax {
+		err = fmt.Errorf("shared memory must have maximum")
+		return
 	}
 
-	l.Min, err = p.r.eatU32()
+	l.Min, err = p.limit(is64)
 	if err != nil {
-		return l, err
+		return
 	}
-	if limits == 0 {
+	if !hasMax {
 		// -1 means there is no maximum value
 		l.Max = -1
 	} else {
-		max, err := p.r.eatU32()
+		var max uint32
+		max, err = p.limit(is64)
 		if err != nil {
-			return l, err
+			return
+		}
+		// a max beyond int32 can't be reached anyway, it must not wrap around to -1,
+		// that would lift the limit. Memories are still rejected when instantiated.
+		if max > math.MaxInt32 {
+			max = math.MaxInt32
 		}
 		l.Max = int32(max)
 	}
+	return
+}
 
-	return l, nil
+// limit reads a min or max, the u64 limits of memory64 must still fit in a u32.
+func (p *parser) limit(is64 bool) (uint32, error) {
+	if !is64 {
+		return p.r.eatU32()
+	}
+	v, err := p.r.eatU64()
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxUint32 {
+		return 0, fmt.Errorf("memory64 limit %d exceeds the u32 range", v)
+	}
+	return uint32(v), nil
 }
 
 // https://webassembly.github.io/spec/core/binary/values.html#names
@@ -539,487 +854,803 @@ func (p *parser) instr() (i instr, isEnd bool, err error) {
 	if err != nil {
 		return nil, false, err
 	}
-	switch opcode(op) {
-	case opCodeUnreachable:
+	switch Opcode(op) {
+	case OpcodeUnreachable:
 		i = &opUnreachable{}
-	case opCodeNop:
+	case OpcodeNop:
 		i = &opNop{}
-	case opCodeBlock:
+	case OpcodeBlock:
 		block, err := p.eatBlock()
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opBlock{block}
-	case opCodeLoop:
+		i = &opBlock{block: block}
+	case OpcodeLoop:
 		block, err := p.eatBlock()
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoop{block}
-	case opCodeIf:
+		i = &opLoop{block: block}
+	case OpcodeIf:
 		block, err := p.eatBlock()
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opIf{block}
-	case opCodeElse:
+		i = &opIf{block: block}
+	case OpcodeElse:
 		i = &opElse{}
-	case opCodeEnd:
+	case OpcodeEnd:
 		i = &opEnd{}
 		return i, true, nil
-	case opCodeBr:
-	case opCodeBrIf:
-	case opCodeBrTable:
-	case opCodeLocalGet:
+	case OpcodeBr:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opBr{level: int(idx)}
+	case OpcodeBrIf:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opBrIf{level: int(idx)}
+	case OpcodeBrTable:
+		count, err := p.r.eatVecLen()
+		if err != nil {
+			return nil, false, err
+		}
+		labelIdxArr := make([]int, count)
+		for j := uint32(0); j < count; j++ {
+			idx, err := p.r.eatU32()
+			if err != nil {
+				return nil, false, err
+			}
+			labelIdxArr[j] = int(idx)
+		}
+		defaultIdx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opBrTable{labelIdxArr: labelIdxArr, defaultIdx: int(defaultIdx)}
+	case OpcodeLocalGet:
 		idx, err := p.r.eatU32()
 		if err != nil {
 			return nil, false, err
 		}
 		i = &opLocalGet{localIdx: int(idx)}
-	case opCodeLocalSet:
+	case OpcodeLocalSet:
 		idx, err := p.r.eatU32()
 		if err != nil {
 			return nil, false, err
 		}
 		i = &opLocalSet{localIdx: int(idx)}
-	case opCodeLocalTee:
-	case opCodeGlobalGet:
-	case opCodeGlobalSet:
-	case opCodeCall:
-	case opCodeCallIndirect:
-	case opCodeI32Const:
+	case OpcodeLocalTee:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opLocalTee{localIdx: int(idx)}
+	case OpcodeGlobalGet:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opGlobalGet{globalIdx: int(idx)}
+	case OpcodeGlobalSet:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opGlobalSet{globalIdx: int(idx)}
+	case OpcodeTableGet:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opTableGet{tableIdx: idx}
+	case OpcodeTableSet:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opTableSet{tableIdx: idx}
+	case OpcodeCall:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opCall{funcIdx: idx}
+	case OpcodeCallIndirect:
+		typeIdx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		tableIdx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opCallIndirect{typeIdx: typeIdx, tableIdx: tableIdx}
+	case OpcodeReturnCall:
+		// https://github.com/WebAssembly/tail-call/blob/main/proposals/tail-call/Overview.md
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opReturnCall{funcIdx: idx}
+	case OpcodeReturnCallIndirect:
+		typeIdx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		tableIdx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opReturnCallIndirect{typeIdx: typeIdx, tableIdx: tableIdx}
+	case OpcodeI32Const:
 		v, err := p.r.eatI32()
 		if err != nil {
 			return nil, false, err
 		}
 		i = &opConst{val: ValueFromI32(v)}
-	case opCodeI32Eqz:
+	case OpcodeI32Eqz:
 		i = &opTest{testFn: i32Eqz}
-	case opCodeI32Eq:
+	case OpcodeI32Eq:
 		i = &opRel{relFn: i32Eq}
-	case opCodeI32Ne:
+	case OpcodeI32Ne:
 		i = &opRel{relFn: i32Ne}
-	case opCodeI32LtS:
+	case OpcodeI32LtS:
 		i = &opRel{relFn: i32LtS}
-	case opCodeI32LtU:
+	case OpcodeI32LtU:
 		i = &opRel{relFn: i32LtU}
-	case opCodeI32GtS:
+	case OpcodeI32GtS:
 		i = &opRel{relFn: i32GtS}
-	case opCodeI32GtU:
+	case OpcodeI32GtU:
 		i = &opRel{relFn: i32GtU}
-	case opCodeI32LeS:
+	case OpcodeI32LeS:
 		i = &opRel{relFn: i32LeS}
-	case opCodeI32LeU:
+	case OpcodeI32LeU:
 		i = &opRel{relFn: i32LeU}
-	case opCodeI32GeS:
+	case OpcodeI32GeS:
 		i = &opRel{relFn: i32GeS}
-	case opCodeI32GeU:
+	case OpcodeI32GeU:
 		i = &opRel{relFn: i32GeU}
-	case opCodeI32Add:
+	case OpcodeI32Add:
 		i = &opBin{binFn: i32Add}
-	case opCodeI32Sub:
+	case OpcodeI32Sub:
 		i = &opBin{binFn: i32Sub}
-	case opCodeI32Mul:
+	case OpcodeI32Mul:
 		i = &opBin{binFn: i32Mul}
-	case opCodeI32Clz:
+	case OpcodeI32Clz:
 		i = &opUn{unOpFn: i32Clz}
-	case opCodeI32Ctz:
+	case OpcodeI32Ctz:
 		i = &opUn{unOpFn: i32Ctz}
-	case opCodeI32Popcnt:
+	case OpcodeI32Popcnt:
 		i = &opUn{unOpFn: i32Popcnt}
-	case opCodeI32DivS:
+	case OpcodeI32DivS:
 		i = &opBin{binFn: i32DivS}
-	case opCodeI32DivU:
+	case OpcodeI32DivU:
 		i = &opBin{binFn: i32DivU}
-	case opCodeI32RemS:
+	case OpcodeI32RemS:
 		i = &opBin{binFn: i32RemS}
-	case opCodeI32RemU:
+	case OpcodeI32RemU:
 		i = &opBin{binFn: i32RemU}
-	case opCodeI32And:
+	case OpcodeI32And:
 		i = &opBin{binFn: i32And}
-	case opCodeI32Or:
+	case OpcodeI32Or:
 		i = &opBin{binFn: i32Or}
-	case opCodeI32Xor:
+	case OpcodeI32Xor:
 		i = &opBin{binFn: i32Xor}
-	case opCodeI32ShL:
+	case OpcodeI32ShL:
 		i = &opBin{binFn: i32Shl}
-	case opCodeI32ShrS:
+	case OpcodeI32ShrS:
 		i = &opBin{binFn: i32ShrS}
-	case opCodeI32ShrU:
+	case OpcodeI32ShrU:
 		i = &opBin{binFn: i32ShrU}
-	case opCodeI32RtoL:
+	case OpcodeI32RtoL:
 		i = &opBin{binFn: i32RotL}
-	case opCodeI32RtoR:
+	case OpcodeI32RtoR:
 		i = &opBin{binFn: i32RotR}
-	case opCodeI32Extend8S:
+	case OpcodeI32Extend8S:
 		i = &opUn{unOpFn: i32Extend8S}
-	case opCodeI32Extend16S:
+	case OpcodeI32Extend16S:
 		i = &opUn{unOpFn: i32Extend16S}
-	case opCodeI64Const:
+	case OpcodeI64Const:
 		v, err := p.r.eatI64()
 		if err != nil {
 			return nil, false, err
 		}
 		i = &opConst{val: ValueFromI64(v)}
-	case opCodeI64Eqz:
+	case OpcodeI64Eqz:
 		i = &opTest{testFn: i64Eqz}
-	case opCodeI64Eq:
+	case OpcodeI64Eq:
 		i = &opRel{relFn: i64Eq}
-	case opCodeI64Ne:
+	case OpcodeI64Ne:
 		i = &opRel{relFn: i64Ne}
-	case opCodeI64LtS:
+	case OpcodeI64LtS:
 		i = &opRel{relFn: i64LtS}
-	case opCodeI64LtU:
+	case OpcodeI64LtU:
 		i = &opRel{relFn: i64LtU}
-	case opCodeI64GtS:
+	case OpcodeI64GtS:
 		i = &opRel{relFn: i64GtS}
-	case opCodeI64GtU:
+	case OpcodeI64GtU:
 		i = &opRel{relFn: i64GtU}
-	case opCodeI64LeS:
+	case OpcodeI64LeS:
 		i = &opRel{relFn: i64LeS}
-	case opCodeI64LeU:
+	case OpcodeI64LeU:
 		i = &opRel{relFn: i64LeU}
-	case opCodeI64GeS:
+	case OpcodeI64GeS:
 		i = &opRel{relFn: i64GeS}
-	case opCodeI64GeU:
+	case OpcodeI64GeU:
 		i = &opRel{relFn: i64GeU}
-	case opCodeI64Clz:
+	case OpcodeI64Clz:
 		i = &opUn{unOpFn: i64Clz}
-	case opCodeI64Ctz:
+	case OpcodeI64Ctz:
 		i = &opUn{unOpFn: i64Ctz}
-	case opCodeI64Popcnt:
+	case OpcodeI64Popcnt:
 		i = &opUn{unOpFn: i64Popcnt}
-	case opCodeI64Add:
+	case OpcodeI64Add:
 		i = &opBin{binFn: i64Add}
-	case opCodeI64Sub:
+	case OpcodeI64Sub:
 		i = &opBin{binFn: i64Sub}
-	case opCodeI64Mul:
+	case OpcodeI64Mul:
 		i = &opBin{binFn: i64Mul}
-	case opCodeI64DivS:
+	case OpcodeI64DivS:
 		i = &opBin{binFn: i64DivS}
-	case opCodeI64DivU:
+	case OpcodeI64DivU:
 		i = &opBin{binFn: i64DivU}
-	case opCodeI64RemS:
+	case OpcodeI64RemS:
 		i = &opBin{binFn: i64RemS}
-	case opCodeI64RemU:
+	case OpcodeI64RemU:
 		i = &opBin{binFn: i64RemU}
-	case opCodeI64And:
+	case OpcodeI64And:
 		i = &opBin{binFn: i64And}
-	case opCodeI64Or:
+	case OpcodeI64Or:
 		i = &opBin{binFn: i64Or}
-	case opCodeI64Xor:
+	case OpcodeI64Xor:
 		i = &opBin{binFn: i64Xor}
-	case opCodeI64ShL:
+	case OpcodeI64ShL:
 		i = &opBin{binFn: i64Shl}
-	case opCodeI64ShrS:
+	case OpcodeI64ShrS:
 		i = &opBin{binFn: i64ShrS}
-	case opCodeI64ShrU:
+	case OpcodeI64ShrU:
 		i = &opBin{binFn: i64ShrU}
-	case opCodeI64RtoL:
+	case OpcodeI64RtoL:
 		i = &opBin{binFn: i64RotL}
-	case opCodeI64RtoR:
+	case OpcodeI64RtoR:
 		i = &opBin{binFn: i64RotR}
-	case opCodeI64Extend8S:
+	case OpcodeI64Extend8S:
 		i = &opUn{unOpFn: i64Extend8S}
-	case opCodeI64Extend16S:
+	case OpcodeI64Extend16S:
 		i = &opUn{unOpFn: i64Extend16S}
-	case opCodeI64Extend32S:
+	case OpcodeI64Extend32S:
 		i = &opUn{unOpFn: i64Extend32S}
-	case opCodeF32Const:
-	case opCodeF64Const:
-	case opCodeF32Eq:
+	case OpcodeF32Const:
+		b, err := p.r.eatBytes(4)
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opConst{val: ValueFromF32(math.Float32frombits(binary.LittleEndian.Uint32(b)))}
+	case OpcodeF64Const:
+		b, err := p.r.eatBytes(8)
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opConst{val: ValueFromF64(math.Float64frombits(binary.LittleEndian.Uint64(b)))}
+	case OpcodeF32Eq:
 		i = &opRel{relFn: f32Eq}
-	case opCodeF32Ne:
+	case OpcodeF32Ne:
 		i = &opRel{relFn: f32Ne}
-	case opCodeF32Lt:
+	case OpcodeF32Lt:
 		i = &opRel{relFn: f32Lt}
-	case opCodeF32Gt:
+	case OpcodeF32Gt:
 		i = &opRel{relFn: f32Gt}
-	case opCodeF32Le:
+	case OpcodeF32Le:
 		i = &opRel{relFn: f32Le}
-	case opCodeF32Ge:
+	case OpcodeF32Ge:
 		i = &opRel{relFn: f32Ge}
-	case opCodeF32Abs:
-		i = &opUn{unOpFn: f32Abs}
-	case opCodeF32Neg:
-		i = &opUn{unOpFn: f32Neg}
-	case opCodeF32Ceil:
+	case OpcodeF32Abs:
+		i = &opUn{unOpFn: f32Abs, bitwise: true}
+	case OpcodeF32Neg:
+		i = &opUn{unOpFn: f32Neg, bitwise: true}
+	case OpcodeF32Ceil:
 		i = &opUn{unOpFn: f32Ceil}
-	case opCodeF32Floor:
+	case OpcodeF32Floor:
 		i = &opUn{unOpFn: f32Floor}
-	case opCodeF32Trunc:
+	case OpcodeF32Trunc:
 		i = &opUn{unOpFn: f32Trunc}
-	case opCodeF32Nearest:
+	case OpcodeF32Nearest:
 		i = &opUn{unOpFn: f32Nearest}
-	case opCodeF32Sqrt:
+	case OpcodeF32Sqrt:
 		i = &opUn{unOpFn: f32Sqrt}
-	case opCodeF32Add:
+	case OpcodeF32Add:
 		i = &opBin{binFn: f32Add}
-	case opCodeF32Sub:
+	case OpcodeF32Sub:
 		i = &opBin{binFn: f32Sub}
-	case opCodeF32Mul:
+	case OpcodeF32Mul:
 		i = &opBin{binFn: f32Mul}
-	case opCodeF32Div:
+	case OpcodeF32Div:
 		i = &opBin{binFn: f32Div}
-	case opCodeF32Min:
+	case OpcodeF32Min:
 		i = &opBin{binFn: f32Min}
-	case opCodeF32Max:
+	case OpcodeF32Max:
 		i = &opBin{binFn: f32Max}
-	case opCodeF64Abs:
-		i = &opUn{unOpFn: f64Abs}
-	case opCodeF64Neg:
-		i = &opUn{unOpFn: f64Neg}
-	case opCodeF64Ceil:
+	case OpcodeF64Abs:
+		i = &opUn{unOpFn: f64Abs, bitwise: true}
+	case OpcodeF64Neg:
+		i = &opUn{unOpFn: f64Neg, bitwise: true}
+	case OpcodeF64Ceil:
 		i = &opUn{unOpFn: f64Ceil}
-	case opCodeF64Floor:
+	case OpcodeF64Floor:
 		i = &opUn{unOpFn: f64Floor}
-	case opCodeF64Trunc:
+	case OpcodeF64Trunc:
 		i = &opUn{unOpFn: f64Trunc}
-	case opCodeF64Nearest:
+	case OpcodeF64Nearest:
 		i = &opUn{unOpFn: f64Nearest}
-	case opCodeF64Sqrt:
+	case OpcodeF64Sqrt:
 		i = &opUn{unOpFn: f64Sqrt}
-	case opCodeF64Add:
+	case OpcodeF64Add:
 		i = &opBin{binFn: f64Add}
-	case opCodeF64Sub:
+	case OpcodeF64Sub:
 		i = &opBin{binFn: f64Sub}
-	case opCodeF64Mul:
+	case OpcodeF64Mul:
 		i = &opBin{binFn: f64Mul}
-	case opCodeF64Div:
+	case OpcodeF64Div:
 		i = &opBin{binFn: f64Div}
-	case opCodeF64Min:
+	case OpcodeF64Min:
 		i = &opBin{binFn: f64Min}
-	case opCodeF64Max:
+	case OpcodeF64Max:
 		i = &opBin{binFn: f64Max}
-	case opCodeF64Copysign:
-		i = &opBin{binFn: f64Copysign}
-	case opCodeI32WrapI64:
-	case opCodeF64Eq:
+	case OpcodeF64Copysign:
+		i = &opBin{binFn: f64Copysign, bitwise: true}
+	case OpcodeI32WrapI64:
+		i = &opCut{cutFn: i32WrapI64}
+	case OpcodeF64Eq:
 		i = &opRel{relFn: f64Eq}
-	case opCodeF64Ne:
+	case OpcodeF64Ne:
 		i = &opRel{relFn: f64Ne}
-	case opCodeF64Lt:
+	case OpcodeF64Lt:
 		i = &opRel{relFn: f64Lt}
-	case opCodeF64Gt:
+	case OpcodeF64Gt:
 		i = &opRel{relFn: f64Gt}
-	case opCodeF64Le:
+	case OpcodeF64Le:
 		i = &opRel{relFn: f64Le}
-	case opCodeF64Ge:
+	case OpcodeF64Ge:
 		i = &opRel{relFn: f64Ge}
-	case opCodeF32Copysign:
-		i = &opBin{binFn: f32Copysign}
-	case opCodeReturn:
+	case OpcodeF32Copysign:
+		i = &opBin{binFn: f32Copysign, bitwise: true}
+	case OpcodeReturn:
 		i = &opReturn{}
-	case opCodeI32Load:
-		align, offset, err := p.memoryArgs()
+	case OpcodeI32Load:
+		align, memIdx, offset, err := p.memoryArgs(2)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i32load}
-	case opCodeI64Load:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i32load}
+	case OpcodeI64Load:
+		align, memIdx, offset, err := p.memoryArgs(3)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i64load}
-	case opCodeF32Load:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i64load}
+	case OpcodeF32Load:
+		align, memIdx, offset, err := p.memoryArgs(2)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: f32load}
-	case opCodeF64Load:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: f32load}
+	case OpcodeF64Load:
+		align, memIdx, offset, err := p.memoryArgs(3)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: f64load}
-	case opCodeI32Load8S:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: f64load}
+	case OpcodeI32Load8S:
+		align, memIdx, offset, err := p.memoryArgs(0)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i32load8S}
-	case opCodeI32Load8U:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i32load8S}
+	case OpcodeI32Load8U:
+		align, memIdx, offset, err := p.memoryArgs(0)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i32load8U}
-	case opCodeI32Load16S:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i32load8U}
+	case OpcodeI32Load16S:
+		align, memIdx, offset, err := p.memoryArgs(1)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i32load16S}
-	case opCodeI32Load16U:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i32load16S}
+	case OpcodeI32Load16U:
+		align, memIdx, offset, err := p.memoryArgs(1)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i32load16U}
-	case opCodeI64Load8S:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i32load16U}
+	case OpcodeI64Load8S:
+		align, memIdx, offset, err := p.memoryArgs(0)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i64Load8S}
-	case opCodeI64Load8U:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i64Load8S}
+	case OpcodeI64Load8U:
+		align, memIdx, offset, err := p.memoryArgs(0)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i64Load8U}
-	case opCodeI64Load16S:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i64Load8U}
+	case OpcodeI64Load16S:
+		align, memIdx, offset, err := p.memoryArgs(1)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i64load16S}
-	case opCodeI64Load16U:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i64load16S}
+	case OpcodeI64Load16U:
+		align, memIdx, offset, err := p.memoryArgs(1)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i64load16U}
-	case opCodeI64Load32S:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i64load16U}
+	case OpcodeI64Load32S:
+		align, memIdx, offset, err := p.memoryArgs(2)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i64load32S}
-	case opCodeI64Load32U:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i64load32S}
+	case OpcodeI64Load32U:
+		align, memIdx, offset, err := p.memoryArgs(2)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opLoad{align: align, offset: offset, loadFn: i64load32U}
-	case opCodeI32Store:
-		align, offset, err := p.memoryArgs()
+		i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i64load32U}
+	case OpcodeI32Store:
+		align, memIdx, offset, err := p.memoryArgs(2)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: i32store}
-	case opCodeI64Store:
-		align, offset, err := p.memoryArgs()
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i32store}
+	case OpcodeI64Store:
+		align, memIdx, offset, err := p.memoryArgs(3)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: i64store}
-	case opCodeF32Store:
-		align, offset, err := p.memoryArgs()
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i64store}
+	case OpcodeF32Store:
+		align, memIdx, offset, err := p.memoryArgs(2)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: f32store}
-	case opCodeF64Store:
-		align, offset, err := p.memoryArgs()
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: f32store}
+	case OpcodeF64Store:
+		align, memIdx, offset, err := p.memoryArgs(3)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: f64store}
-	case opCodeI32Store8:
-		align, offset, err := p.memoryArgs()
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: f64store}
+	case OpcodeI32Store8:
+		align, memIdx, offset, err := p.memoryArgs(0)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: i32store8}
-	case opCodeI32Store16:
-		align, offset, err := p.memoryArgs()
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i32store8}
+	case OpcodeI32Store16:
+		align, memIdx, offset, err := p.memoryArgs(1)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: i32store16}
-	case opCodeI64Store8:
-		align, offset, err := p.memoryArgs()
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i32store16}
+	case OpcodeI64Store8:
+		align, memIdx, offset, err := p.memoryArgs(0)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: i64store8}
-	case opCodeI64Store16:
-		align, offset, err := p.memoryArgs()
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i64store8}
+	case OpcodeI64Store16:
+		align, memIdx, offset, err := p.memoryArgs(1)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: i64store16}
-	case opCodeI64Store32:
-		align, offset, err := p.memoryArgs()
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i64store16}
+	case OpcodeI64Store32:
+		align, memIdx, offset, err := p.memoryArgs(2)
 		if err != nil {
 			return nil, false, err
 		}
-		i = &opStore{align: align, offset: offset, storeFn: i64store32}
-	case opCodeMemorySize:
+		i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i64store32}
+	case OpcodeMemorySize:
+		if err := p.defaultMemIdx("memory.size"); err != nil {
+			return nil, false, err
+		}
 		i = &opMemorySize{}
-	case opCodeMemoryGrow:
+	case OpcodeMemoryGrow:
+		if err := p.defaultMemIdx("memory.grow"); err != nil {
+			return nil, false, err
+		}
 		i = &opMemoryGrow{}
-	case opCodeMemoryCopyOrFill:
-		kind, err := p.r.eatU8()
+	case OpcodeMemoryCopyOrFill:
+		kind, err := p.r.eatU32()
 		if err != nil {
 			return nil, false, err
 		}
-		if kind == 10 {
+		switch kind {
+		case 0:
+			i = &opCut{cutFn: i32TruncSatF32S}
+		case 1:
+			i = &opCut{cutFn: i32TruncSatF32U}
+		case 2:
+			i = &opCut{cutFn: i32TruncSatF64S}
+		case 3:
+			i = &opCut{cutFn: i32TruncSatF64U}
+		case 4:
+			i = &opCut{cutFn: i64TruncSatF32S}
+		case 5:
+			i = &opCut{cutFn: i64TruncSatF32U}
+		case 6:
+			i = &opCut{cutFn: i64TruncSatF64S}
+		case 7:
+			i = &opCut{cutFn: i64TruncSatF64U}
+		case 8:
+			// 0xFC 8:U32 dataidx:U32 0x00
+			idx, err := p.r.eatU32()
+			if err != nil {
+				return nil, false, err
+			}
+			if err := p.defaultMemIdx("memory.init"); err != nil {
+				return nil, false, err
+			}
+			if err := p.noMem64("memory.init"); err != nil {
+				return nil, false, err
+			}
+			i = &opMemoryInit{dataIdx: idx}
+		case 9:
+			idx, err := p.r.eatU32()
+			if err != nil {
+				return nil, false, err
+			}
+			i = &opDataDrop{dataIdx: idx}
+		case 10:
 			// 0xFC 10:U32 0x00 0x00
-			p.r.eatU32()
-			p.r.eatU32()
+			if err := p.defaultMemIdx("memory.copy"); err != nil {
+				return nil, false, err
+			}
+			if err := p.defaultMemIdx("memory.copy"); err != nil {
+				return nil, false, err
+			}
+			if err := p.noMem64("memory.copy"); err != nil {
+				return nil, false, err
+			}
 			i = &opMemoryCopy{}
-		} else if kind == 11 {
+		case 11:
 			// 0xFC 11:U32 0x00
-			p.r.eatU32()
+			if err := p.defaultMemIdx("memory.fill"); err != nil {
+				return nil, false, err
+			}
+			if err := p.noMem64("memory.fill"); err != nil {
+				return nil, false, err
+			}
 			i = &opMemoryFill{}
-		} else {
-			return nil, false, fmt.Errorf("unknown memory copy or fill kind: %d", kind)
+		case 15, 16, 17:
+			idx, err := p.r.eatU32()
+			if err != nil {
+				return nil, false, err
+			}
+			switch kind {
+			case 15:
+				i = &opTableGrow{tableIdx: idx}
+			case 16:
+				i = &opTableSize{tableIdx: idx}
+			case 17:
+				i = &opTableFill{tableIdx: idx}
+			}
+		default:
+			return nil, false, fmt.Errorf("unknown 0xFC instruction kind: %d", kind)
+		}
+	case OpcodeSIMD:
+		// https://webassembly.github.io/spec/core/binary/instructions.html#vector-instructions
+		kind, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		switch kind {
+		case 0:
+			align, memIdx, offset, err := p.memoryArgs(4)
+			if err != nil {
+				return nil, false, err
+			}
+			i = &opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: v128load}
+		case 11:
+			align, memIdx, offset, err := p.memoryArgs(4)
+			if err != nil {
+				return nil, false, err
+			}
+			i = &opStore{align: align, memIdx: memIdx, offset: offset, storeFn: v128store}
+		case 12:
+			b, err := p.r.eatBytes(16)
+			if err != nil {
+				return nil, false, err
+			}
+			i = &opConst{val: ValueFromV128([16]byte(b))}
+		default:
+			binFn, ok := simdBinFns[kind]
+			if !ok {
+				return nil, false, fmt.Errorf("unknown 0xFD instruction kind: %d", kind)
+			}
+			// f32x4 instrs are the kinds 0xE0 to 0xEB
+			i = &opBin{binFn: binFn, f32x4: kind >= 0xE0 && kind <= 0xEB}
+		}
+	case OpcodeAtomic:
+		// https://github.com/WebAssembly/threads/blob/main/proposals/threads/Overview.md#atomic-memory-accesses
+		kind, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
 		}
-	case opCodeSelect:
+		switch kind {
+		case 0x10, 0x11, 0x17, 0x18:
+			natural := uint32(2)
+			if kind == 0x11 || kind == 0x18 {
+				natural = 3
+			}
+			align, memIdx, offset, err := p.memoryArgs(natural)
+			if err != nil {
+				return nil, false, err
+			}
+			if align != natural {
+				return nil, false, fmt.Errorf("atomic alignment must be natural")
+			}
+			switch kind {
+			case 0x10:
+				i = &opAtomicLoad{opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i32load}, 4}
+			case 0x11:
+				i = &opAtomicLoad{opLoad{align: align, memIdx: memIdx, offset: offset, loadFn: i64load}, 8}
+			case 0x17:
+				i = &opAtomicStore{opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i32store}, 4}
+			case 0x18:
+				i = &opAtomicStore{opStore{align: align, memIdx: memIdx, offset: offset, storeFn: i64store}, 8}
+			}
+		default:
+			return nil, false, fmt.Errorf("unknown 0xFE instruction kind: %d", kind)
+		}
+	case OpcodeSelect:
 		i = &opSelect{}
-	case opCodeDrop:
+	case OpcodeDrop:
 		i = &opDrop{}
-	case opCodeI32TruncF32S:
-	case opCodeI32TruncF32U:
-	case opCodeI32TruncF64S:
-	case opCodeI32TruncF64U:
-	case opCodeI64ExtendI32S:
-	case opCodeI64ExtendI32U:
-	case opCodeI64TruncF32S:
-	case opCodeI64TruncF32U:
-	case opCodeI64TruncF64S:
-	case opCodeI64TruncF64U:
-	case opCodeF32ConvertI32S:
-	case opCodeF32ConvertI32U:
-	case opCodeF32ConvertI64S:
-	case opCodeF32ConvertI64U:
-	case opCodeF32DemoteF64:
-	case opCodeF64ConvertI32S:
-	case opCodeF64ConvertI32U:
-	case opCodeF64ConvertI64S:
-	case opCodeF64ConvertI64U:
-	case opCodeF64PromoteF32:
-	case opCodeI32ReinterpretF32:
-	case opCodeI64ReinterpretF64:
-	case opCodeF32ReinterpretI32:
-	case opCodeF64ReinterpretI64:
+	case OpcodeRefNull:
+		refType, err := p.r.eatU8()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opRefNull{refType: type_(refType)}
+	case OpcodeRefIsNull:
+		i = &opRefIsNull{}
+	case OpcodeRefFunc:
+		idx, err := p.r.eatU32()
+		if err != nil {
+			return nil, false, err
+		}
+		i = &opRefFunc{funcIdx: idx}
+	case OpcodeI32TruncF32S:
+		i = &opCut{cutFn: i32TruncF32S}
+	case OpcodeI32TruncF32U:
+		i = &opCut{cutFn: i32TruncF32U}
+	case OpcodeI32TruncF64S:
+		i = &opCut{cutFn: i32TruncF64S}
+	case OpcodeI32TruncF64U:
+		i = &opCut{cutFn: i32TruncF64U}
+	case OpcodeI64ExtendI32S:
+		i = &opCut{cutFn: i64ExtendI32S}
+	case OpcodeI64ExtendI32U:
+		i = &opCut{cutFn: i64ExtendI32U}
+	case OpcodeI64TruncF32S:
+		i = &opCut{cutFn: i64TruncF32S}
+	case OpcodeI64TruncF32U:
+		i = &opCut{cutFn: i64TruncF32U}
+	case OpcodeI64TruncF64S:
+		i = &opCut{cutFn: i64TruncF64S}
+	case OpcodeI64TruncF64U:
+		i = &opCut{cutFn: i64TruncF64U}
+	case OpcodeF32ConvertI32S:
+		i = &opCut{cutFn: f32ConvertI32S}
+	case OpcodeF32ConvertI32U:
+		i = &opCut{cutFn: f32ConvertI32U}
+	case OpcodeF32ConvertI64S:
+		i = &opCut{cutFn: f32ConvertI64S}
+	case OpcodeF32ConvertI64U:
+		i = &opCut{cutFn: f32ConvertI64U}
+	case OpcodeF32DemoteF64:
+		i = &opCut{cutFn: f32DemoteF64}
+	case OpcodeF64ConvertI32S:
+		i = &opCut{cutFn: f64ConvertI32S}
+	case OpcodeF64ConvertI32U:
+		i = &opCut{cutFn: f64ConvertI32U}
+	case OpcodeF64ConvertI64S:
+		i = &opCut{cutFn: f64ConvertI64S}
+	case OpcodeF64ConvertI64U:
+		i = &opCut{cutFn: f64ConvertI64U}
+	case OpcodeF64PromoteF32:
+		i = &opCut{cutFn: f64PromoteF32}
+	case OpcodeI32ReinterpretF32:
+		i = &opReinterpret{valType: I32}
+	case OpcodeI64ReinterpretF64:
+		i = &opReinterpret{valType: I64}
+	case OpcodeF32ReinterpretI32:
+		i = &opReinterpret{valType: F32}
+	case OpcodeF64ReinterpretI64:
+		i = &opReinterpret{valType: F64}
 	}
 
 	return i, false, nil
 }
 
-// eat align and offset two i32 values
-func (p *parser) memoryArgs() (align, offset int32, err error) {
-	align, err = p.r.eatI32()
+// eat align and offset two u32 values
+// https://github.com/WebAssembly/multi-memory/blob/main/proposals/multi-memory/Overview.md
+// with the multi-memory proposal bit 6 of align signals a memory index between the two
+// https://webassembly.github.io/spec/core/valid/instructions.html#memory-instructions
+// align is the log2 of a byte count, it must not exceed natural, the log2 of the access size
+func (p *parser) memoryArgs(natural uint32) (align, memIdx uint32, offset uint64, err error) {
+	align, err = p.r.eatU32()
 	if err != nil {
 		return
 	}
-	offset, err = p.r.eatI32()
-	if err != nil {
+	if align&memArgMemIdxFlag != 0 {
+		align &^= memArgMemIdxFlag
+		memIdx, err = p.r.eatU32()
+		if err != nil {
+			return
+		}
+	}
+	if align > natural {
+		err = fmt.Errorf("alignment must not be larger than natural")
 		return
 	}
+	if int(memIdx) < len(p.mems) && p.mems[memIdx].is64 {
+		offset, err = p.r.eatU64()
+		return
+	}
+	var offset32 uint32
+	offset32, err = p.r.eatU32()
+	offset = uint64(offset32)
 	return
 }
 
+// defaultMemIdx reads the memory index of instr, only loads and stores can address memories
+// other than memory 0 so far, anything else is rejected rather than run against memory 0.
+func (p *parser) defaultMemIdx(instr string) error {
+	idx, err := p.r.eatU32()
+	if err != nil {
+		return err
+	}
+	if idx != 0 {
+		return fmt.Errorf("%s on memory %d is not supported", instr, idx)
+	}
+	return nil
+}
+
+// noMem64 rejects the bulk memory instrs on a memory64 memory, they only take i32 operands so far.
+func (p *parser) noMem64(instr string) error {
+	if len(p.mems) > 0 && p.mems[0].is64 {
+		return fmt.Errorf("%s on a 64-bit memory is not supported", instr)
+	}
+	return nil
+}
+
+// https://webassembly.github.io/spec/core/binary/instructions.html#binary-blocktype
+// blocktype ::= 0x40 | valtype | typeidx:s33
 func (p *parser) eatBlock() (block, error) {
-	blockType, err := p.r.eatU8()
+	v, err := p.r.eatS33()
 	if err != nil {
 		return block{}, err
 	}
+	if v >= 0 {
+		if v >= int64(len(p.types)) {
+			return block{}, fmt.Errorf("unknown type %d", v)
+		}
+		ft := p.types[v]
+		return block{blockType: blockTypeIdx, typeIdx: uint32(v), params: ft.params, valType: ft.results}, nil
+	}
+	// empty and value types are encoded as single byte negative numbers
+	blockType := uint8(v & 0x7F)
 	if blockType == 0x40 {
 		return block{blockType: blockTypeEmpty}, nil
 	} else {
@@ -0,0 +1,418 @@
+package wasm_go
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseWat(t *testing.T, wat string) module {
+	wasm, err := wat2wasm(wat)
+	assert.NoError(t, err)
+	p := newParser(wasm)
+	m, err := p.parse()
+	assert.NoError(t, err)
+	return m
+}
+
+func TestParseBr(t *testing.T) {
+	m := parseWat(t, `
+		(module
+			(func
+				(block (br 0))
+			)
+		)
+	`)
+	body := m.funcs[0].body
+	br, ok := body[1].(*opBr)
+	if assert.True(t, ok) {
+		assert.Equal(t, 0, br.level)
+	}
+
+	frameStack := stack[frame]{}
+	frameStack.Push(frame{insts: body})
+	valueStack := stack[Value]{}
+	assert.NoError(t, body[0].exec(&frameStack, &valueStack, &store{}))
+	assert.NoError(t, body[1].exec(&frameStack, &valueStack, &store{}))
+
+	endPc, err := nextEndAddr(1, body)
+	assert.NoError(t, err)
+	frame, _ := frameStack.Top()
+	assert.Equal(t, endPc, frame.pc)
+}
+
+func TestParseFloatConst(t *testing.T) {
+	m := parseWat(t, `
+		(module
+			(func (result f32)
+				f32.const 3.14
+			)
+			(func (result f64)
+				f64.const -0.0
+			)
+		)
+	`)
+	f32Const, ok := m.funcs[0].body[0].(*opConst)
+	if assert.True(t, ok) {
+		assert.Equal(t, F32, f32Const.val.ValType)
+		assert.Equal(t, math.Float32bits(3.14), math.Float32bits(f32Const.val.F32()))
+	}
+	f64Const, ok := m.funcs[1].body[0].(*opConst)
+	if assert.True(t, ok) {
+		assert.Equal(t, F64, f64Const.val.ValType)
+		assert.Equal(t, uint64(0x8000000000000000), math.Float64bits(f64Const.val.F64()))
+	}
+}
+
+func TestParseElemSegmentFlags(t *testing.T) {
+	m := parseWat(t, `
+		(module
+			(table 1 funcref)
+			(table 2 funcref)
+			(func)
+			(func)
+			(elem (i32.const 0) 0)
+			(elem (table 1) (i32.const 1) func 1 0)
+			(elem func 1)
+			(elem declare func 0)
+		)
+	`)
+	if assert.Len(t, m.elems, 4) {
+		assert.Equal(t, elemModeActive, m.elems[0].mode)
+		assert.Equal(t, uint32(0), m.elems[0].tableIdx)
+		assert.Equal(t, []uint32{0}, m.elems[0].init)
+
+		assert.Equal(t, elemModeActive, m.elems[1].mode)
+		assert.Equal(t, uint32(1), m.elems[1].tableIdx)
+		assert.Equal(t, []uint32{1, 0}, m.elems[1].init)
+
+		assert.Equal(t, elemModePassive, m.elems[2].mode)
+		assert.Equal(t, FuncRef, m.elems[2].elemType)
+		assert.Equal(t, []uint32{1}, m.elems[2].init)
+
+		assert.Equal(t, elemModeDeclarative, m.elems[3].mode)
+	}
+}
+
+func TestParseDataSegmentFlags(t *testing.T) {
+	m := parseWat(t, `
+		(module
+			(memory 1)
+			(data (i32.const 8) "ab")
+			(data "cd")
+			(data (memory 0) (i32.const 16) "ef")
+		)
+	`)
+	if assert.Len(t, m.datas, 3) {
+		assert.Equal(t, dataModeActive, m.datas[0].mode)
+		assert.Equal(t, uint32(0), m.datas[0].memIdx)
+		assert.Equal(t, []byte("ab"), m.datas[0].init)
+		assert.Equal(t, ValueFromI32(8), m.datas[0].offset[0].(*opConst).val)
+
+		assert.Equal(t, dataModePassive, m.datas[1].mode)
+		assert.Nil(t, m.datas[1].offset)
+		assert.Equal(t, []byte("cd"), m.datas[1].init)
+
+		assert.Equal(t, dataModeActive, m.datas[2].mode)
+		assert.Equal(t, []byte("ef"), m.datas[2].init)
+	}
+}
+
+func TestParseDataCountSection(t *testing.T) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	dataCount := []byte{byte(DataCountSection), 0x01, 0x01}
+	// one passive segment "hi"
+	data := []byte{byte(DataSection), 0x05, 0x01, 0x01, 0x02, 'h', 'i'}
+
+	wasm := append(append(append([]byte{}, header...), dataCount...), data...)
+	p := newParser(wasm)
+	m, err := p.parse()
+	assert.NoError(t, err)
+	if assert.NotNil(t, m.dataCount) {
+		assert.Equal(t, uint32(1), *m.dataCount)
+	}
+	assert.Equal(t, []byte("hi"), m.datas[0].init)
+
+	// the count doesn't match the data section
+	wasm = append(append([]byte{}, header...), byte(DataCountSection), 0x01, 0x02)
+	wasm = append(wasm, data...)
+	p = newParser(wasm)
+	_, err = p.parse()
+	assert.EqualError(t, err, "data count and data section have inconsistent lengths")
+}
+
+func TestParseMultiValueBlock(t *testing.T) {
+	wat := `
+		(module
+			(func (export "sum") (result i32)
+				(block (result i32 i32)
+					i32.const 1
+					i32.const 2
+				)
+				i32.add
+			)
+			(func (result i64)
+				(block (result i64)
+					i64.const 1
+				)
+			)
+		)
+	`
+	m := parseWat(t, wat)
+	b, ok := m.funcs[0].body[0].(*opBlock)
+	if assert.True(t, ok) {
+		assert.Equal(t, blockTypeIdx, b.block.blockType)
+		assert.Equal(t, 0, b.block.paramArity())
+		assert.Equal(t, 2, b.block.resultArity())
+	}
+	b, ok = m.funcs[1].body[0].(*opBlock)
+	if assert.True(t, ok) {
+		assert.Equal(t, blockTypeValue, b.block.blockType)
+		assert.Equal(t, []type_{I64}, b.block.valType)
+	}
+
+	i := newInterpreterFromWat(t, wat)
+	ret, err := invokeExport(t, &i, "sum")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+}
+
+func TestParseNameSection(t *testing.T) {
+	wat := `
+		(module $math
+			(import "env" "log" (func $log (param i32)))
+			(func $add (export "add") (param $a i32) (param $b i32) (result i32)
+				(local $sum i32)
+				local.get $a
+				local.get $b
+				i32.add
+			)
+		)
+	`
+	m := parseWat(t, wat)
+	assert.Equal(t, "math", m.names.module)
+	assert.Equal(t, map[uint32]string{0: "log", 1: "add"}, m.names.funcs)
+	assert.Equal(t, map[uint32]string{0: "a", 1: "b", 2: "sum"}, m.names.locals[1])
+
+	i := newInterpreterFromWat(t, wat)
+	assert.Equal(t, "math", i.ModuleName())
+	name, ok := i.FuncName(1)
+	assert.True(t, ok)
+	assert.Equal(t, "add", name)
+	_, ok = i.FuncName(2)
+	assert.False(t, ok)
+	name, ok = i.LocalName(1, 2)
+	assert.True(t, ok)
+	assert.Equal(t, "sum", name)
+}
+
+func TestParseCustomSection(t *testing.T) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	// a one byte name "a" followed by the data "xyz"
+	section := []byte{byte(CustomSection), 0x05, 0x01, 'a', 'x', 'y', 'z'}
+	// type section with a single [] -> [] func type
+	types := []byte{byte(TypeSection), 0x04, 0x01, 0x60, 0x00, 0x00}
+
+	wasm := append(append(append([]byte{}, header...), section...), types...)
+	p := newParser(wasm)
+	m, err := p.parse()
+	assert.NoError(t, err)
+	assert.Equal(t, []custom{{name: "a", data: []byte("xyz")}}, m.customs)
+	assert.Len(t, m.types, 1)
+
+	// the section is too short for its name
+	wasm = append(append([]byte{}, header...), byte(CustomSection), 0x01, 0x03, 'a', 'b', 'c')
+	p = newParser(wasm)
+	_, err = p.parse()
+	assert.EqualError(t, err, "custom section name exceeds the section length")
+}
+
+func TestParseUnknownSection(t *testing.T) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	// type section with a single [] -> [] func type
+	types := []byte{byte(TypeSection), 0x04, 0x01, 0x60, 0x00, 0x00}
+	// its content would be a malformed memory section
+	unknown := []byte{0x7f, 0x03, 0x01, 0x04, 0xff}
+	// (memory 1)
+	mems := []byte{byte(MemorySection), 0x03, 0x01, 0x00, 0x01}
+
+	wasm := append(append(append(append([]byte{}, header...), types...), unknown...), mems...)
+	p := newParser(wasm)
+	m, err := p.parse()
+	assert.NoError(t, err)
+	assert.Len(t, m.types, 1)
+	assert.Equal(t, []mem{{memType{limits: limits{Min: 1, Max: -1}}}}, m.mems)
+
+	m, err = parseReader(bytes.NewReader(wasm))
+	assert.NoError(t, err)
+	assert.Len(t, m.types, 1)
+	assert.Len(t, m.mems, 1)
+
+	wasm = append(append([]byte{}, header...), 0x7f, 0x03, 0x01)
+	p = newParser(wasm)
+	_, err = p.parse()
+	assert.EqualError(t, err, "section 127 exceeds the module length")
+}
+
+func TestParseSectionOrder(t *testing.T) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	types := []byte{byte(TypeSection), 0x01, 0x00}
+	code := []byte{byte(CodeSection), 0x01, 0x00}
+	dataCount := []byte{byte(DataCountSection), 0x01, 0x00}
+	// custom sections may appear anywhere
+	section := []byte{byte(CustomSection), 0x01, 0x00}
+
+	wasm := append(append(append(append(append([]byte{}, header...), types...), section...), dataCount...), code...)
+	p := newParser(wasm)
+	_, err := p.parse()
+	assert.NoError(t, err)
+
+	wasm = append(append(append([]byte{}, header...), code...), types...)
+	p = newParser(wasm)
+	_, err = p.parse()
+	assert.EqualError(t, err, "section 1 out of order")
+	_, err = parseReader(bytes.NewReader(wasm))
+	assert.EqualError(t, err, "section 1 out of order")
+
+	wasm = append(append(append([]byte{}, header...), code...), dataCount...)
+	p = newParser(wasm)
+	_, err = p.parse()
+	assert.EqualError(t, err, "section 12 out of order")
+
+	// two function sections
+	funcs := []byte{byte(FunctionSection), 0x01, 0x00}
+	wasm = append(append(append(append([]byte{}, header...), types...), funcs...), funcs...)
+	p = newParser(wasm)
+	_, err = p.parse()
+	assert.EqualError(t, err, "duplicate section 3")
+	// custom sections may repeat
+	wasm = append(append(append([]byte{}, header...), section...), section...)
+	p = newParser(wasm)
+	_, err = p.parse()
+	assert.NoError(t, err)
+}
+
+func TestParseSectionBounds(t *testing.T) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	cases := []struct {
+		name    string
+		section []byte
+		err     string
+	}{
+		{
+			name:    "vector longer than the section",
+			section: []byte{byte(TypeSection), 0x05, 0xff, 0xff, 0xff, 0xff, 0x0f},
+			err:     "vector length 4294967295 exceeds the remaining 0 bytes",
+		},
+		{
+			name:    "section longer than the module",
+			section: []byte{byte(TypeSection), 0x10, 0x01, 0x60},
+			err:     "section 1 exceeds the module length",
+		},
+		{
+			name:    "content left in the section",
+			section: []byte{byte(FunctionSection), 0x02, 0x00, 0x00},
+			err:     "section 3 size mismatch",
+		},
+	}
+	for _, c := range cases {
+		p := newParser(append(append([]byte{}, header...), c.section...))
+		_, err := p.parse()
+		assert.EqualError(t, err, c.err, c.name)
+	}
+}
+
+func TestParseSharedMemory(t *testing.T) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	// (import "env" "mem" (memory 1 2 shared))
+	imports := []byte{byte(ImportSection), 0x0d, 0x01, 0x03, 'e', 'n', 'v', 0x03, 'm', 'e', 'm', 0x02, 0x03, 0x01, 0x02}
+
+	wasm := append(append([]byte{}, header...), imports...)
+	p := newParser(wasm)
+	m, err := p.parse()
+	assert.NoError(t, err)
+	mem := m.imports[0].importDesc.mem
+	assert.True(t, mem.shared)
+	assert.Equal(t, limits{Min: 1, Max: 2}, mem.limits)
+	_, err = NewInterpreter(wasm)
+	assert.EqualError(t, err, "import env.mem: shared memories are not supported")
+
+	// shared without a max
+	wasm = append(append([]byte{}, header...), imports[:len(imports)-1]...)
+	wasm[len(header)+1] = 0x0c
+	wasm[len(wasm)-2] = 0x02
+	p = newParser(wasm)
+	_, err = p.parse()
+	assert.EqualError(t, err, "shared memory must have maximum")
+
+	// (memory 1 2 shared)
+	wasm = append(append([]byte{}, header...), byte(MemorySection), 0x04, 0x01, 0x03, 0x01, 0x02)
+	_, err = NewInterpreter(wasm)
+	assert.EqualError(t, err, "memory 0: shared memories are not supported")
+}
+
+func TestParseLimitsMaxU32(t *testing.T) {
+	m := parseWat(t, `(module (table 0 0xffffffff funcref))`)
+	// the max holds, it doesn't wrap around to no max
+	assert.Equal(t, limits{Min: 0, Max: math.MaxInt32}, m.tables[0].limits)
+}
+
+func TestParseMemArgAlignment(t *testing.T) {
+	cases := []struct {
+		instr string
+		err   bool
+	}{
+		{"i32.load8_u align=1", false},
+		{"i32.load8_u align=2", true},
+		{"i32.load16_s align=2", false},
+		{"i32.load16_s align=4", true},
+		{"i32.load align=4", false},
+		{"i32.load align=8", true},
+		{"i64.load32_u align=4", false},
+		{"i64.load32_u align=8", true},
+		{"i64.load align=8", false},
+		{"i64.load align=16", true},
+		{"f64.load align=8", false},
+		{"i32.atomic.load align=4", false},
+		{"i32.atomic.load align=2", true},
+	}
+	for _, c := range cases {
+		wasm, err := wat2wasm(`
+			(module
+				(memory 1)
+				(func (result i64)
+					i32.const 0
+					` + c.instr + `
+					drop
+					i64.const 0
+				)
+			)
+		`)
+		if !assert.NoError(t, err, c.instr) {
+			continue
+		}
+		p := newParser(wasm)
+		_, err = p.parse()
+		if c.err {
+			assert.Error(t, err, c.instr)
+		} else {
+			assert.NoError(t, err, c.instr)
+		}
+	}
+
+	wasm, err := wat2wasm(`
+		(module
+			(memory 1)
+			(func
+				i32.const 0
+				i64.const 0
+				i64.store16 align=4
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	_, err = NewInterpreter(wasm)
+	assert.EqualError(t, err, "alignment must not be larger than natural")
+}
@@ -0,0 +1,26 @@
+package wasm_go
+
+// SetProfiling turns counting the executed instructions on or off,
+// turning it on starts from zero counts. It costs nothing while off.
+func (i *Interpreter) SetProfiling(on bool) {
+	if on {
+		i.profile = map[instrOp]uint64{}
+	} else {
+		i.profile = nil
+	}
+}
+
+// Profile returns how many times each instruction ran since profiling was turned on,
+// keyed by mnemonic like "i32.add". It returns nil when profiling is off.
+func (i *Interpreter) Profile() map[string]uint64 {
+	if i.profile == nil {
+		return nil
+	}
+	counts := map[string]uint64{}
+	for op, n := range i.profile {
+		if name := op.name(); name != "" {
+			counts[name] += n
+		}
+	}
+	return counts
+}
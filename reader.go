@@ -1,9 +1,16 @@
 package wasm_go
 
 import (
+	"errors"
+	"fmt"
 	"io"
 )
 
+var (
+	errIntegerRepresentationTooLong = errors.New("integer representation too long")
+	errIntegerTooLarge              = errors.New("integer too large")
+)
+
 type leb128Reader struct {
 	bytes []byte
 	pos   int
@@ -19,6 +26,19 @@ func (r *leb128Reader) eatBytes(length uint32) ([]byte, error) {
 	return bs, nil
 }
 
+// eatVecLen reads the length of a vector, every element takes at least one byte
+// so a length beyond the remaining bytes is rejected before anything is allocated.
+func (r *leb128Reader) eatVecLen() (uint32, error) {
+	n, err := r.eatU32()
+	if err != nil {
+		return 0, err
+	}
+	if int(n) > len(r.bytes)-r.pos {
+		return 0, fmt.Errorf("vector length %d exceeds the remaining %d bytes", n, len(r.bytes)-r.pos)
+	}
+	return n, nil
+}
+
 func (r *leb128Reader) eatString(length uint32) (string, error) {
 	b, err := r.eatBytes(length)
 	if err != nil {
@@ -36,32 +56,78 @@ func (r *leb128Reader) eatU8() (uint8, error) {
 }
 
 func (r *leb128Reader) eatU64() (uint64, error) {
+	return r.eatUnsigned(64)
+}
+
+func (r *leb128Reader) eatI64() (int64, error) {
+	return r.eatSigned(64)
+}
+
+func (r *leb128Reader) eatI32() (int32, error) {
+	v, err := r.eatSigned(32)
+	return int32(v), err
+}
+
+func (r *leb128Reader) eatU32() (uint32, error) {
+	v, err := r.eatUnsigned(32)
+	return uint32(v), err
+}
+
+// eatS33 reads the signed 33 bit integer a block type index is encoded as.
+func (r *leb128Reader) eatS33() (int64, error) {
+	return r.eatSigned(33)
+}
+
+// https://webassembly.github.io/spec/core/binary/values.html#integers
+// an N bit integer takes at most ceil(N/7) bytes, the unused bits of the last byte must be zero.
+func (r *leb128Reader) eatUnsigned(bits int) (uint64, error) {
+	maxLen := (bits + 6) / 7
 	v, shift := uint64(0), 0
-	for {
+	for n := 1; ; n++ {
 		u8, err := r.eatU8()
 		if err != nil {
 			return 0, err
 		}
+		if n == maxLen {
+			if u8&0x80 != 0 {
+				return 0, errIntegerRepresentationTooLong
+			}
+			if u8>>(bits-shift) != 0 {
+				return 0, errIntegerTooLarge
+			}
+		}
 		v |= (uint64(u8) & 0x7F) << shift
 		shift += 7
-		if u8&0x80>>7 == 0 {
+		if u8&0x80 == 0 {
 			break
 		}
 	}
 	return v, nil
 }
 
-func (r *leb128Reader) eatI64() (int64, error) {
+// eatSigned is like eatUnsigned, but the unused bits of the last byte must match the sign bit.
+func (r *leb128Reader) eatSigned(bits int) (int64, error) {
+	maxLen := (bits + 6) / 7
 	v, shift := int64(0), 0
-	for {
+	for n := 1; ; n++ {
 		u8, err := r.eatU8()
 		if err != nil {
 			return 0, err
 		}
+		if n == maxLen {
+			if u8&0x80 != 0 {
+				return 0, errIntegerRepresentationTooLong
+			}
+			// the sign bit and the bits above it
+			unused := u8 &^ (1<<(bits-shift-1) - 1)
+			if unused != 0 && unused != 0x7F&^(1<<(bits-shift-1)-1) {
+				return 0, errIntegerTooLarge
+			}
+		}
 		v |= (int64(u8) & 0x7F) << shift
 		shift += 7
-		if u8&0x80>>7 == 0 {
-			if u8&0x40>>3 != 0 {
+		if u8&0x80 == 0 {
+			if u8&0x40 != 0 {
 				// negative number
 				v |= ^0 << shift
 			}
@@ -70,13 +136,3 @@ func (r *leb128Reader) eatI64() (int64, error) {
 	}
 	return v, nil
 }
-
-func (r *leb128Reader) eatI32() (int32, error) {
-	v, err := r.eatI64()
-	return int32(v), err
-}
-
-func (r *leb128Reader) eatU32() (uint32, error) {
-	v, err := r.eatU64()
-	return uint32(v), err
-}
@@ -62,3 +62,62 @@ func binaryStringToBytes(s string) []byte {
 	}
 	return b
 }
+
+func TestOverlongAndTooLarge(t *testing.T) {
+	cases := []struct {
+		name  string
+		bytes []byte
+		eat   func(r *leb128Reader) error
+		err   error
+	}{
+		{"u32 in 6 bytes", []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, eatU32, errIntegerRepresentationTooLong},
+		{"u32 above max", []byte{0xff, 0xff, 0xff, 0xff, 0x1f}, eatU32, errIntegerTooLarge},
+		{"u64 in 11 bytes", []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, eatU64, errIntegerRepresentationTooLong},
+		{"u64 above max", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, eatU64, errIntegerTooLarge},
+		{"i32 in 6 bytes", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, eatI32, errIntegerRepresentationTooLong},
+		{"i32 above max", []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, eatI32, errIntegerTooLarge},
+		{"i32 below min", []byte{0x80, 0x80, 0x80, 0x80, 0x70}, eatI32, errIntegerTooLarge},
+		{"i64 in 11 bytes", []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, eatI64, errIntegerRepresentationTooLong},
+		{"i64 unused bits not sign extended", []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, eatI64, errIntegerTooLarge},
+	}
+	for _, c := range cases {
+		r := leb128Reader{bytes: c.bytes}
+		assert.ErrorIs(t, c.eat(&r), c.err, c.name)
+	}
+
+	// padded, but within the maximum length
+	valid := []struct {
+		bytes []byte
+		eat   func(r *leb128Reader) error
+	}{
+		{[]byte{0x80, 0x80, 0x80, 0x80, 0x00}, eatU32},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, eatU32},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x07}, eatI32},
+		{[]byte{0x80, 0x80, 0x80, 0x80, 0x78}, eatI32},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x7f}, eatI32},
+	}
+	for _, c := range valid {
+		r := leb128Reader{bytes: c.bytes}
+		assert.NoError(t, c.eat(&r), "% x", c.bytes)
+	}
+}
+
+func eatU32(r *leb128Reader) error {
+	_, err := r.eatU32()
+	return err
+}
+
+func eatU64(r *leb128Reader) error {
+	_, err := r.eatU64()
+	return err
+}
+
+func eatI32(r *leb128Reader) error {
+	_, err := r.eatI32()
+	return err
+}
+
+func eatI64(r *leb128Reader) error {
+	_, err := r.eatI64()
+	return err
+}
@@ -20,25 +20,40 @@ func (s *stack[T]) Top() (*T, bool) {
 	return s.Peek(0)
 }
 
+// Peek returns the value depth below the top, ok is false when there is no such value.
 func (s *stack[T]) Peek(depth int) (*T, bool) {
 	var v T
-	if s.isEmpty() {
+	if depth < 0 || depth >= s.Len() {
 		return &v, false
 	}
 	return &s.inner[len(s.inner)-1-depth], true
 }
 
-func (s *stack[T]) Set(sp, idx int, v T) {
+// Set replaces the value at sp+idx, ok is false when there is no such value.
+func (s *stack[T]) Set(sp, idx int, v T) bool {
+	if sp+idx < 0 || sp+idx >= s.Len() {
+		return false
+	}
 	s.inner[sp+idx] = v
+	return true
 }
 
 func (s *stack[T]) Get(sp, idx int) (*T, bool) {
-	if sp+idx >= s.Len() {
+	if sp+idx < 0 || sp+idx >= s.Len() {
 		return nil, false
 	}
 	return &s.inner[sp+idx], true
 }
 
+// Unwind drops the values between height and the top n values.
+func (s *stack[T]) Unwind(height, n int) {
+	if s.Len()-n < height {
+		return
+	}
+	copy(s.inner[height:], s.inner[s.Len()-n:])
+	s.inner = s.inner[:height+n]
+}
+
 func (s *stack[T]) Pop() (T, bool) {
 	var v T
 	if s.isEmpty() {
@@ -0,0 +1,33 @@
+package wasm_go
+
+// TrapCode tells what made the execution trap.
+type TrapCode uint8
+
+const (
+	TrapCodeUnreachable TrapCode = iota
+	TrapCodeOutOfBoundsMemory
+	TrapCodeOutOfBoundsTable
+	TrapCodeDivByZero
+	TrapCodeIntegerOverflow
+	TrapCodeInvalidConversion
+	TrapCodeUndefinedElement
+	TrapCodeUninitializedElement
+	TrapCodeIndirectCallTypeMismatch
+	TrapCodeStackOverflow
+	TrapCodeUnalignedAtomic
+)
+
+// Trap is the error returned when the execution traps.
+// Error returns the message used by the spec tests.
+type Trap struct {
+	Code TrapCode
+	msg  string
+}
+
+func newTrap(code TrapCode, msg string) *Trap {
+	return &Trap{Code: code, msg: msg}
+}
+
+func (t *Trap) Error() string {
+	return t.msg
+}
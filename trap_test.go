@@ -0,0 +1,93 @@
+package wasm_go
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrap(t *testing.T) {
+	i := newInterpreterFromWat(t, `
+		(module
+			(memory 1)
+			(table 1 funcref)
+			(func (export "unreachable")
+				unreachable
+			)
+			(func (export "load") (result i32)
+				i32.const 65536
+				i32.load
+			)
+			(func (export "div") (result i32)
+				i32.const 1
+				i32.const 0
+				i32.div_s
+			)
+			(func (export "overflow") (result i32)
+				i32.const 0x80000000
+				i32.const -1
+				i32.div_s
+			)
+			(func (export "trunc") (result i32)
+				f32.const nan
+				i32.trunc_f32_s
+			)
+			(func (export "uninitialized")
+				i32.const 0
+				call_indirect
+			)
+			(func $recurse (export "recurse")
+				call $recurse
+			)
+		)
+	`)
+	cases := []struct {
+		export string
+		code   TrapCode
+		msg    string
+	}{
+		{"unreachable", TrapCodeUnreachable, "unreachable"},
+		{"load", TrapCodeOutOfBoundsMemory, "out of bounds memory access"},
+		{"div", TrapCodeDivByZero, "integer divide by zero"},
+		{"overflow", TrapCodeIntegerOverflow, "integer overflow"},
+		{"trunc", TrapCodeInvalidConversion, "invalid conversion to integer"},
+		{"uninitialized", TrapCodeUninitializedElement, "uninitialized element"},
+		{"recurse", TrapCodeStackOverflow, "call stack exhausted"},
+	}
+	for _, c := range cases {
+		_, err := invokeExport(t, &i, c.export)
+		assert.EqualError(t, err, c.msg, c.export)
+		var trap *Trap
+		if assert.True(t, errors.As(err, &trap), c.export) {
+			assert.Equal(t, c.code, trap.Code, c.export)
+		}
+	}
+}
+
+func TestTrapUnreachable(t *testing.T) {
+	// the trap surfaces unchanged from a callee nested in blocks
+	i := newInterpreterFromWat(t, `
+		(module
+			(func $fail (param i32) (result i32)
+				local.get 0
+				(if (then unreachable))
+				i32.const 1
+			)
+			(func (export "run") (param i32) (result i32)
+				(block (result i32)
+					local.get 0
+					call $fail
+				)
+			)
+		)
+	`)
+	_, err := invokeExport(t, &i, "run", ValueFromI32(1))
+	assert.EqualError(t, err, "unreachable")
+	assert.ErrorIs(t, err, errUnreachable)
+
+	// the interpreter can still be called after the trap
+	ret, err := invokeExport(t, &i, "run", ValueFromI32(0))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1)}, ret)
+}
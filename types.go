@@ -1,8 +1,13 @@
 package wasm_go
 
+import "fmt"
+
 // https://webassembly.github.io/spec/core/syntax/modules.html#modules
 type module struct {
-	custom  custom
+	// custom sections in the order they appear
+	customs []custom
+	// debug names from the "name" custom section
+	names   names
 	types   []funcType
 	funcs   []function
 	tables  []table
@@ -10,7 +15,10 @@ type module struct {
 	globals []global
 	elems   []elem
 	datas   []data
-	start   start
+	// nil when the module has no data count section
+	dataCount *uint32
+	// nil when the module has no start section
+	start   *start
 	imports []import_
 	exports []export
 }
@@ -20,11 +28,37 @@ type custom struct {
 	data []byte
 }
 
+// https://webassembly.github.io/spec/core/appendix/custom.html#name-section
+type names struct {
+	module string
+	// func idx -> name
+	funcs map[uint32]string
+	// func idx -> local idx -> name
+	locals map[uint32]map[uint32]string
+}
+
 type funcType struct {
 	params  []type_
 	results []type_
 }
 
+func (f funcType) equal(other funcType) bool {
+	if len(f.params) != len(other.params) || len(f.results) != len(other.results) {
+		return false
+	}
+	for i, t := range f.params {
+		if other.params[i] != t {
+			return false
+		}
+	}
+	for i, t := range f.results {
+		if other.results[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
 type locals struct {
 	count   uint32
 	valType type_
@@ -33,6 +67,10 @@ type function struct {
 	typeIdx uint32
 	locals  []locals
 	body    []instr
+	// the op of each instr of body, recorded when it is decoded
+	ops []instrOp
+	// raw bytes of body, kept to encode the module again
+	code []byte
 }
 
 type table struct {
@@ -47,21 +85,43 @@ type mem struct {
 	memType
 }
 
-// https://www.w3.org/TR/wasm-core-1/#data-segments%E2%91%A0
-// data ::= {data memidx,offset expr,init vec(byte)}
+// https://webassembly.github.io/spec/core/syntax/modules.html#data-segments
+// data ::= {init vec(byte), mode datamode}
 type data struct {
+	mode dataMode
+	// memIdx and offset are only meaningful for active segments
 	memIdx uint32
 	offset expr
 	init   []byte
 }
 
+type dataMode uint8
+
+const (
+	dataModeActive  dataMode = 0x00
+	dataModePassive dataMode = 0x01
+)
+
+// https://webassembly.github.io/spec/core/syntax/modules.html#element-segments
+// elem ::= {type reftype, init vec(expr), mode elemmode}
 type elem struct {
+	mode     elemMode
+	elemType type_
+	// tableIdx and offset are only meaningful for active segments
 	tableIdx uint32
 	offset   expr
 	// vec<funcIdx>
 	init []uint32
 }
 
+type elemMode uint8
+
+const (
+	elemModeActive      elemMode = 0x00
+	elemModePassive     elemMode = 0x01
+	elemModeDeclarative elemMode = 0x02
+)
+
 type import_ struct {
 	module     string
 	name       string
@@ -84,6 +144,20 @@ const (
 	exportImportKindGlobal exportImportKind = 0x03
 )
 
+func (k exportImportKind) String() string {
+	switch k {
+	case exportImportKindFunc:
+		return "func"
+	case exportImportKindTable:
+		return "table"
+	case exportImportKindMem:
+		return "memory"
+	case exportImportKindGlobal:
+		return "global"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
 type export struct {
 	name string
 	kind exportImportKind
@@ -103,9 +177,12 @@ type expr []instr
 // https://webassembly.github.io/spec/core/binary/types.html#value-types
 type type_ uint8
 
+// ValType names the value types outside the package, e.g. for host function signatures.
+type ValType = type_
+
 const (
-	I32       type_ = 0x70
-	I64       type_ = 0x6f
+	I32       type_ = 0x7F
+	I64       type_ = 0x7E
 	F32       type_ = 0x7D
 	F64       type_ = 0x7C
 	V128      type_ = 0x7B
@@ -126,6 +203,10 @@ type tableType struct {
 
 type memType struct {
 	limits limits
+	// set by the threads proposal, shared memories are not supported yet
+	shared bool
+	// set by the memory64 proposal, addresses are i64 instead of i32
+	is64 bool
 }
 
 type mutability uint8
@@ -145,192 +226,229 @@ type blockType = uint8
 const (
 	blockTypeEmpty blockType = 0
 	blockTypeValue blockType = 1
+	// the block type references a function type, used by multi-value blocks
+	blockTypeIdx blockType = 2
 )
 
+// https://webassembly.github.io/spec/core/binary/instructions.html#binary-blocktype
 type block struct {
 	blockType blockType
-	valType   []type_
+	// result types
+	valType []type_
+	params  []type_
+	typeIdx uint32
 }
 
-type opcode uint8
+func (b block) paramArity() int {
+	return len(b.params)
+}
+
+func (b block) resultArity() int {
+	return len(b.valType)
+}
+
+// Opcode is the first byte of an instruction in the binary format, the prefixed
+// instructions are told apart by the u32 following their prefix.
+// https://webassembly.github.io/spec/core/binary/instructions.html
+type Opcode uint8
 
 const (
-	opCodeUnreachable       opcode = 0x00
-	opCodeNop               opcode = 0x01
-	opCodeBlock             opcode = 0x02
-	opCodeLoop              opcode = 0x03
-	opCodeIf                opcode = 0x04
-	opCodeElse              opcode = 0x05
-	opCodeEnd               opcode = 0x0B
-	opCodeBr                opcode = 0x0C
-	opCodeBrIf              opcode = 0x0D
-	opCodeBrTable           opcode = 0x0E
-	opCodeLocalGet          opcode = 0x20
-	opCodeLocalSet          opcode = 0x21
-	opCodeLocalTee          opcode = 0x22
-	opCodeGlobalGet         opcode = 0x23
-	opCodeGlobalSet         opcode = 0x24
-	opCodeCall              opcode = 0x10
-	opCodeCallIndirect      opcode = 0x11
-	opCodeI32Const          opcode = 0x41
-	opCodeI32Eqz            opcode = 0x45
-	opCodeI32Eq             opcode = 0x46
-	opCodeI32Ne             opcode = 0x47
-	opCodeI32LtS            opcode = 0x48
-	opCodeI32LtU            opcode = 0x49
-	opCodeI32GtS            opcode = 0x4A
-	opCodeI32GtU            opcode = 0x4B
-	opCodeI32LeS            opcode = 0x4C
-	opCodeI32LeU            opcode = 0x4D
-	opCodeI32GeS            opcode = 0x4E
-	opCodeI32GeU            opcode = 0x4F
-	opCodeI32Add            opcode = 0x6a
-	opCodeI32Sub            opcode = 0x6b
-	opCodeI32Mul            opcode = 0x6c
-	opCodeI32Clz            opcode = 0x67
-	opCodeI32Ctz            opcode = 0x68
-	opCodeI32Popcnt         opcode = 0x69
-	opCodeI32DivS           opcode = 0x6D
-	opCodeI32DivU           opcode = 0x6E
-	opCodeI32RemS           opcode = 0x6F
-	opCodeI32RemU           opcode = 0x70
-	opCodeI32And            opcode = 0x71
-	opCodeI32Or             opcode = 0x72
-	opCodeI32Xor            opcode = 0x73
-	opCodeI32ShL            opcode = 0x74
-	opCodeI32ShrS           opcode = 0x75
-	opCodeI32ShrU           opcode = 0x76
-	opCodeI32RtoL           opcode = 0x77
-	opCodeI32RtoR           opcode = 0x78
-	opCodeI32Extend8S       opcode = 0xC0
-	opCodeI32Extend16S      opcode = 0xC1
-	opCodeI64Const          opcode = 0x42
-	opCodeI64Eqz            opcode = 0x50
-	opCodeI64Eq             opcode = 0x51
-	opCodeI64Ne             opcode = 0x52
-	opCodeI64LtS            opcode = 0x53
-	opCodeI64LtU            opcode = 0x54
-	opCodeI64GtS            opcode = 0x55
-	opCodeI64GtU            opcode = 0x56
-	opCodeI64LeS            opcode = 0x57
-	opCodeI64LeU            opcode = 0x58
-	opCodeI64GeS            opcode = 0x59
-	opCodeI64GeU            opcode = 0x5A
-	opCodeI64Clz            opcode = 0x79
-	opCodeI64Ctz            opcode = 0x7A
-	opCodeI64Popcnt         opcode = 0x7B
-	opCodeI64Add            opcode = 0x7C
-	opCodeI64Sub            opcode = 0x7D
-	opCodeI64Mul            opcode = 0x7E
-	opCodeI64DivS           opcode = 0x7F
-	opCodeI64DivU           opcode = 0x80
-	opCodeI64RemS           opcode = 0x81
-	opCodeI64RemU           opcode = 0x82
-	opCodeI64And            opcode = 0x83
-	opCodeI64Or             opcode = 0x84
-	opCodeI64Xor            opcode = 0x85
-	opCodeI64ShL            opcode = 0x86
-	opCodeI64ShrS           opcode = 0x87
-	opCodeI64ShrU           opcode = 0x88
-	opCodeI64RtoL           opcode = 0x89
-	opCodeI64RtoR           opcode = 0x8A
-	opCodeI64Extend8S       opcode = 0xC2
-	opCodeI64Extend16S      opcode = 0xC3
-	opCodeI64Extend32S      opcode = 0xC4
-	opCodeF32Const          opcode = 0x43
-	opCodeF64Const          opcode = 0x44
-	opCodeF32Eq             opcode = 0x5B
-	opCodeF32Ne             opcode = 0x5C
-	opCodeF32Lt             opcode = 0x5D
-	opCodeF32Gt             opcode = 0x5E
-	opCodeF32Le             opcode = 0x5F
-	opCodeF32Ge             opcode = 0x60
-	opCodeF32Abs            opcode = 0x8B
-	opCodeF32Neg            opcode = 0x8C
-	opCodeF32Ceil           opcode = 0x8D
-	opCodeF32Floor          opcode = 0x8E
-	opCodeF32Trunc          opcode = 0x8F
-	opCodeF32Nearest        opcode = 0x90
-	opCodeF32Sqrt           opcode = 0x91
-	opCodeF32Add            opcode = 0x92
-	opCodeF32Sub            opcode = 0x93
-	opCodeF32Mul            opcode = 0x94
-	opCodeF32Div            opcode = 0x95
-	opCodeF32Min            opcode = 0x96
-	opCodeF32Max            opcode = 0x97
-	opCodeF64Abs            opcode = 0x99
-	opCodeF64Neg            opcode = 0x9A
-	opCodeF64Ceil           opcode = 0x9B
-	opCodeF64Floor          opcode = 0x9C
-	opCodeF64Trunc          opcode = 0x9D
-	opCodeF64Nearest        opcode = 0x9E
-	opCodeF64Sqrt           opcode = 0x9F
-	opCodeF64Add            opcode = 0xA0
-	opCodeF64Sub            opcode = 0xA1
-	opCodeF64Mul            opcode = 0xA2
-	opCodeF64Div            opcode = 0xA3
-	opCodeF64Min            opcode = 0xA4
-	opCodeF64Max            opcode = 0xA5
-	opCodeF64Copysign       opcode = 0xA6
-	opCodeI32WrapI64        opcode = 0xA7
-	opCodeF64Eq             opcode = 0x61
-	opCodeF64Ne             opcode = 0x62
-	opCodeF64Lt             opcode = 0x63
-	opCodeF64Gt             opcode = 0x64
-	opCodeF64Le             opcode = 0x65
-	opCodeF64Ge             opcode = 0x66
-	opCodeF32Copysign       opcode = 0x98
-	opCodeReturn            opcode = 0x0f
-	opCodeI32Load           opcode = 0x28
-	opCodeI64Load           opcode = 0x29
-	opCodeF32Load           opcode = 0x2A
-	opCodeF64Load           opcode = 0x2B
-	opCodeI32Load8S         opcode = 0x2C
-	opCodeI32Load8U         opcode = 0x2D
-	opCodeI32Load16S        opcode = 0x2E
-	opCodeI32Load16U        opcode = 0x2F
-	opCodeI64Load8S         opcode = 0x30
-	opCodeI64Load8U         opcode = 0x31
-	opCodeI64Load16S        opcode = 0x32
-	opCodeI64Load16U        opcode = 0x33
-	opCodeI64Load32S        opcode = 0x34
-	opCodeI64Load32U        opcode = 0x35
-	opCodeI32Store          opcode = 0x36
-	opCodeI64Store          opcode = 0x37
-	opCodeF32Store          opcode = 0x38
-	opCodeF64Store          opcode = 0x39
-	opCodeI32Store8         opcode = 0x3A
-	opCodeI32Store16        opcode = 0x3B
-	opCodeI64Store8         opcode = 0x3C
-	opCodeI64Store16        opcode = 0x3D
-	opCodeI64Store32        opcode = 0x3E
-	opCodeMemorySize        opcode = 0x3F
-	opCodeMemoryGrow        opcode = 0x40
-	opCodeMemoryCopyOrFill  opcode = 0xFC
-	opCodeSelect            opcode = 0x1B
-	opCodeDrop              opcode = 0x1A
-	opCodeI32TruncF32S      opcode = 0xA8
-	opCodeI32TruncF32U      opcode = 0xA9
-	opCodeI32TruncF64S      opcode = 0xAA
-	opCodeI32TruncF64U      opcode = 0xAB
-	opCodeI64ExtendI32S     opcode = 0xAC
-	opCodeI64ExtendI32U     opcode = 0xAD
-	opCodeI64TruncF32S      opcode = 0xAE
-	opCodeI64TruncF32U      opcode = 0xAF
-	opCodeI64TruncF64S      opcode = 0xB0
-	opCodeI64TruncF64U      opcode = 0xB1
-	opCodeF32ConvertI32S    opcode = 0xB2
-	opCodeF32ConvertI32U    opcode = 0xB3
-	opCodeF32ConvertI64S    opcode = 0xB4
-	opCodeF32ConvertI64U    opcode = 0xB5
-	opCodeF32DemoteF64      opcode = 0xB6
-	opCodeF64ConvertI32S    opcode = 0xB7
-	opCodeF64ConvertI32U    opcode = 0xB8
-	opCodeF64ConvertI64S    opcode = 0xB9
-	opCodeF64ConvertI64U    opcode = 0xBA
-	opCodeF64PromoteF32     opcode = 0xBB
-	opCodeI32ReinterpretF32 opcode = 0xBC
-	opCodeI64ReinterpretF64 opcode = 0xBD
-	opCodeF32ReinterpretI32 opcode = 0xBE
-	opCodeF64ReinterpretI64 opcode = 0xBF
+	OpcodeUnreachable       Opcode = 0x00
+	OpcodeNop               Opcode = 0x01
+	OpcodeBlock             Opcode = 0x02
+	OpcodeLoop              Opcode = 0x03
+	OpcodeIf                Opcode = 0x04
+	OpcodeElse              Opcode = 0x05
+	OpcodeEnd               Opcode = 0x0B
+	OpcodeBr                Opcode = 0x0C
+	OpcodeBrIf              Opcode = 0x0D
+	OpcodeBrTable           Opcode = 0x0E
+	OpcodeLocalGet          Opcode = 0x20
+	OpcodeLocalSet          Opcode = 0x21
+	OpcodeLocalTee          Opcode = 0x22
+	OpcodeGlobalGet         Opcode = 0x23
+	OpcodeGlobalSet         Opcode = 0x24
+	OpcodeTableGet          Opcode = 0x25
+	OpcodeTableSet          Opcode = 0x26
+	OpcodeCall              Opcode = 0x10
+	OpcodeCallIndirect      Opcode = 0x11
+	OpcodeI32Const          Opcode = 0x41
+	OpcodeI32Eqz            Opcode = 0x45
+	OpcodeI32Eq             Opcode = 0x46
+	OpcodeI32Ne             Opcode = 0x47
+	OpcodeI32LtS            Opcode = 0x48
+	OpcodeI32LtU            Opcode = 0x49
+	OpcodeI32GtS            Opcode = 0x4A
+	OpcodeI32GtU            Opcode = 0x4B
+	OpcodeI32LeS            Opcode = 0x4C
+	OpcodeI32LeU            Opcode = 0x4D
+	OpcodeI32GeS            Opcode = 0x4E
+	OpcodeI32GeU            Opcode = 0x4F
+	OpcodeI32Add            Opcode = 0x6a
+	OpcodeI32Sub            Opcode = 0x6b
+	OpcodeI32Mul            Opcode = 0x6c
+	OpcodeI32Clz            Opcode = 0x67
+	OpcodeI32Ctz            Opcode = 0x68
+	OpcodeI32Popcnt         Opcode = 0x69
+	OpcodeI32DivS           Opcode = 0x6D
+	OpcodeI32DivU           Opcode = 0x6E
+	OpcodeI32RemS           Opcode = 0x6F
+	OpcodeI32RemU           Opcode = 0x70
+	OpcodeI32And            Opcode = 0x71
+	OpcodeI32Or             Opcode = 0x72
+	OpcodeI32Xor            Opcode = 0x73
+	OpcodeI32ShL            Opcode = 0x74
+	OpcodeI32ShrS           Opcode = 0x75
+	OpcodeI32ShrU           Opcode = 0x76
+	OpcodeI32RtoL           Opcode = 0x77
+	OpcodeI32RtoR           Opcode = 0x78
+	OpcodeI32Extend8S       Opcode = 0xC0
+	OpcodeI32Extend16S      Opcode = 0xC1
+	OpcodeI64Const          Opcode = 0x42
+	OpcodeI64Eqz            Opcode = 0x50
+	OpcodeI64Eq             Opcode = 0x51
+	OpcodeI64Ne             Opcode = 0x52
+	OpcodeI64LtS            Opcode = 0x53
+	OpcodeI64LtU            Opcode = 0x54
+	OpcodeI64GtS            Opcode = 0x55
+	OpcodeI64GtU            Opcode = 0x56
+	OpcodeI64LeS            Opcode = 0x57
+	OpcodeI64LeU            Opcode = 0x58
+	OpcodeI64GeS            Opcode = 0x59
+	OpcodeI64GeU            Opcode = 0x5A
+	OpcodeI64Clz            Opcode = 0x79
+	OpcodeI64Ctz            Opcode = 0x7A
+	OpcodeI64Popcnt         Opcode = 0x7B
+	OpcodeI64Add            Opcode = 0x7C
+	OpcodeI64Sub            Opcode = 0x7D
+	OpcodeI64Mul            Opcode = 0x7E
+	OpcodeI64DivS           Opcode = 0x7F
+	OpcodeI64DivU           Opcode = 0x80
+	OpcodeI64RemS           Opcode = 0x81
+	OpcodeI64RemU           Opcode = 0x82
+	OpcodeI64And            Opcode = 0x83
+	OpcodeI64Or             Opcode = 0x84
+	OpcodeI64Xor            Opcode = 0x85
+	OpcodeI64ShL            Opcode = 0x86
+	OpcodeI64ShrS           Opcode = 0x87
+	OpcodeI64ShrU           Opcode = 0x88
+	OpcodeI64RtoL           Opcode = 0x89
+	OpcodeI64RtoR           Opcode = 0x8A
+	OpcodeI64Extend8S       Opcode = 0xC2
+	OpcodeI64Extend16S      Opcode = 0xC3
+	OpcodeI64Extend32S      Opcode = 0xC4
+	OpcodeF32Const          Opcode = 0x43
+	OpcodeF64Const          Opcode = 0x44
+	OpcodeF32Eq             Opcode = 0x5B
+	OpcodeF32Ne             Opcode = 0x5C
+	OpcodeF32Lt             Opcode = 0x5D
+	OpcodeF32Gt             Opcode = 0x5E
+	OpcodeF32Le             Opcode = 0x5F
+	OpcodeF32Ge             Opcode = 0x60
+	OpcodeF32Abs            Opcode = 0x8B
+	OpcodeF32Neg            Opcode = 0x8C
+	OpcodeF32Ceil           Opcode = 0x8D
+	OpcodeF32Floor          Opcode = 0x8E
+	OpcodeF32Trunc          Opcode = 0x8F
+	OpcodeF32Nearest        Opcode = 0x90
+	OpcodeF32Sqrt           Opcode = 0x91
+	OpcodeF32Add            Opcode = 0x92
+	OpcodeF32Sub            Opcode = 0x93
+	OpcodeF32Mul            Opcode = 0x94
+	OpcodeF32Div            Opcode = 0x95
+	OpcodeF32Min            Opcode = 0x96
+	OpcodeF32Max            Opcode = 0x97
+	OpcodeF64Abs            Opcode = 0x99
+	OpcodeF64Neg            Opcode = 0x9A
+	OpcodeF64Ceil           Opcode = 0x9B
+	OpcodeF64Floor          Opcode = 0x9C
+	OpcodeF64Trunc          Opcode = 0x9D
+	OpcodeF64Nearest        Opcode = 0x9E
+	OpcodeF64Sqrt           Opcode = 0x9F
+	OpcodeF64Add            Opcode = 0xA0
+	OpcodeF64Sub            Opcode = 0xA1
+	OpcodeF64Mul            Opcode = 0xA2
+	OpcodeF64Div            Opcode = 0xA3
+	OpcodeF64Min            Opcode = 0xA4
+	OpcodeF64Max            Opcode = 0xA5
+	OpcodeF64Copysign       Opcode = 0xA6
+	OpcodeI32WrapI64        Opcode = 0xA7
+	OpcodeF64Eq             Opcode = 0x61
+	OpcodeF64Ne             Opcode = 0x62
+	OpcodeF64Lt             Opcode = 0x63
+	OpcodeF64Gt             Opcode = 0x64
+	OpcodeF64Le             Opcode = 0x65
+	OpcodeF64Ge             Opcode = 0x66
+	OpcodeF32Copysign       Opcode = 0x98
+	OpcodeReturn            Opcode = 0x0f
+	OpcodeI32Load           Opcode = 0x28
+	OpcodeI64Load           Opcode = 0x29
+	OpcodeF32Load           Opcode = 0x2A
+	OpcodeF64Load           Opcode = 0x2B
+	OpcodeI32Load8S         Opcode = 0x2C
+	OpcodeI32Load8U         Opcode = 0x2D
+	OpcodeI32Load16S        Opcode = 0x2E
+	OpcodeI32Load16U        Opcode = 0x2F
+	OpcodeI64Load8S         Opcode = 0x30
+	OpcodeI64Load8U         Opcode = 0x31
+	OpcodeI64Load16S        Opcode = 0x32
+	OpcodeI64Load16U        Opcode = 0x33
+	OpcodeI64Load32S        Opcode = 0x34
+	OpcodeI64Load32U        Opcode = 0x35
+	OpcodeI32Store          Opcode = 0x36
+	OpcodeI64Store          Opcode = 0x37
+	OpcodeF32Store          Opcode = 0x38
+	OpcodeF64Store          Opcode = 0x39
+	OpcodeI32Store8         Opcode = 0x3A
+	OpcodeI32Store16        Opcode = 0x3B
+	OpcodeI64Store8         Opcode = 0x3C
+	OpcodeI64Store16        Opcode = 0x3D
+	OpcodeI64Store32        Opcode = 0x3E
+	OpcodeMemorySize        Opcode = 0x3F
+	OpcodeMemoryGrow        Opcode = 0x40
+	OpcodeMemoryCopyOrFill  Opcode = 0xFC
+	OpcodeSIMD              Opcode = 0xFD
+	OpcodeAtomic            Opcode = 0xFE
+	OpcodeSelect            Opcode = 0x1B
+	OpcodeDrop              Opcode = 0x1A
+	OpcodeRefNull           Opcode = 0xD0
+	OpcodeRefIsNull         Opcode = 0xD1
+	OpcodeRefFunc           Opcode = 0xD2
+	OpcodeI32TruncF32S      Opcode = 0xA8
+	OpcodeI32TruncF32U      Opcode = 0xA9
+	OpcodeI32TruncF64S      Opcode = 0xAA
+	OpcodeI32TruncF64U      Opcode = 0xAB
+	OpcodeI64ExtendI32S     Opcode = 0xAC
+	OpcodeI64ExtendI32U     Opcode = 0xAD
+	OpcodeI64TruncF32S      Opcode = 0xAE
+	OpcodeI64TruncF32U      Opcode = 0xAF
+	OpcodeI64TruncF64S      Opcode = 0xB0
+	OpcodeI64TruncF64U      Opcode = 0xB1
+	OpcodeF32ConvertI32S    Opcode = 0xB2
+	OpcodeF32ConvertI32U    Opcode = 0xB3
+	OpcodeF32ConvertI64S    Opcode = 0xB4
+	OpcodeF32ConvertI64U    Opcode = 0xB5
+	OpcodeF32DemoteF64      Opcode = 0xB6
+	OpcodeF64ConvertI32S    Opcode = 0xB7
+	OpcodeF64ConvertI32U    Opcode = 0xB8
+	OpcodeF64ConvertI64S    Opcode = 0xB9
+	OpcodeF64ConvertI64U    Opcode = 0xBA
+	OpcodeF64PromoteF32     Opcode = 0xBB
+	OpcodeI32ReinterpretF32 Opcode = 0xBC
+	OpcodeI64ReinterpretF64 Opcode = 0xBD
+	OpcodeF32ReinterpretI32 Opcode = 0xBE
+	OpcodeF64ReinterpretI64 Opcode = 0xBF
+
+	// https://github.com/WebAssembly/tail-call/blob/main/proposals/tail-call/Overview.md
+	OpcodeReturnCall         Opcode = 0x12
+	OpcodeReturnCallIndirect Opcode = 0x13
 )
+
+// String returns the mnemonic of op in the text format, the 0xFC, 0xFD and 0xFE
+// prefixes don't name an instruction on their own.
+func (op Opcode) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("Opcode(0x%02x)", uint8(op))
+}
@@ -0,0 +1,629 @@
+package wasm_go
+
+import "fmt"
+
+// https://webassembly.github.io/spec/core/valid/modules.html
+// validate rejects modules that would otherwise panic or misbehave at runtime.
+func (m module) validate() error {
+	for i, f := range m.funcs {
+		if int(f.typeIdx) >= len(m.types) {
+			return fmt.Errorf("func %d: unknown type %d", i, f.typeIdx)
+		}
+		if err := validateOpcodes(f); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+		if err := m.validateLocalIdxs(f); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+		if err := validateBranches(f.body); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+		if err := m.validateDataIdxs(f.body); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+		if err := m.validateMemIdxs(f.body); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+		if err := m.validateGlobalIdxs(f.body); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+		if err := m.validateFuncIdxs(f.body); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+		if err := m.validateTableIdxs(f.body); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+		if err := m.validateOperands(f); err != nil {
+			return fmt.Errorf("func %d: %w", i, err)
+		}
+	}
+
+	for i, g := range m.globals {
+		if err := m.validateConstExpr(g.initExpr, g.type_.valueType); err != nil {
+			return fmt.Errorf("global %d: %w", i, err)
+		}
+	}
+	funcs := m.indexSpaceLen(exportImportKindFunc)
+	for i, e := range m.elems {
+		for _, funcIdx := range e.init {
+			if int(funcIdx) >= funcs {
+				return fmt.Errorf("elem %d: unknown func %d", i, funcIdx)
+			}
+		}
+		if e.mode != elemModeActive {
+			continue
+		}
+		if int(e.tableIdx) >= m.indexSpaceLen(exportImportKindTable) {
+			return fmt.Errorf("elem %d: unknown table %d", i, e.tableIdx)
+		}
+		if err := m.validateConstExpr(e.offset, I32); err != nil {
+			return fmt.Errorf("elem %d: %w", i, err)
+		}
+	}
+	mems := m.memTypes()
+	for i, d := range m.datas {
+		if d.mode != dataModeActive {
+			continue
+		}
+		if int(d.memIdx) >= len(mems) {
+			return fmt.Errorf("data %d: unknown memory %d", i, d.memIdx)
+		}
+		// memory64 segments have i64 offsets
+		offsetType := I32
+		if mems[d.memIdx].is64 {
+			offsetType = I64
+		}
+		if err := m.validateConstExpr(d.offset, offsetType); err != nil {
+			return fmt.Errorf("data %d: %w", i, err)
+		}
+	}
+
+	for _, imp := range m.imports {
+		if imp.kind == exportImportKindFunc && int(imp.importDesc.typeIdx) >= len(m.types) {
+			return fmt.Errorf("import %s.%s: unknown type %d", imp.module, imp.name, imp.importDesc.typeIdx)
+		}
+	}
+	// shared memories need atomics to be of any use
+	for _, imp := range m.imports {
+		if imp.kind == exportImportKindMem && imp.importDesc.mem.shared {
+			return fmt.Errorf("import %s.%s: shared memories are not supported", imp.module, imp.name)
+		}
+	}
+	for i, mem := range m.mems {
+		if mem.shared {
+			return fmt.Errorf("memory %d: shared memories are not supported", i)
+		}
+	}
+
+	for _, export := range m.exports {
+		if int(export.idx) >= m.indexSpaceLen(export.kind) {
+			return fmt.Errorf("export %s: unknown %s %d", export.name, export.kind, export.idx)
+		}
+	}
+
+	if m.start != nil {
+		if int(m.start.funcIdx) >= m.indexSpaceLen(exportImportKindFunc) {
+			return fmt.Errorf("unknown start func %d", m.start.funcIdx)
+		}
+		funcType, err := m.funcType(m.start.funcIdx)
+		if err != nil {
+			return err
+		}
+		if len(funcType.params) != 0 || len(funcType.results) != 0 {
+			return fmt.Errorf("start func %d must not have params or results", m.start.funcIdx)
+		}
+	}
+	return nil
+}
+
+// indexSpaceLen returns the number of imported and defined items of kind.
+func (m module) indexSpaceLen(kind exportImportKind) int {
+	n := 0
+	for _, imp := range m.imports {
+		if imp.kind == kind {
+			n++
+		}
+	}
+	switch kind {
+	case exportImportKindFunc:
+		n += len(m.funcs)
+	case exportImportKindTable:
+		n += len(m.tables)
+	case exportImportKindMem:
+		n += len(m.mems)
+	case exportImportKindGlobal:
+		n += len(m.globals)
+	}
+	return n
+}
+
+// funcType returns the type of the func at idx in the func index space.
+func (m module) funcType(idx uint32) (funcType, error) {
+	typeIdx := uint32(0)
+	found := false
+	for _, imp := range m.imports {
+		if imp.kind != exportImportKindFunc {
+			continue
+		}
+		if idx == 0 {
+			typeIdx = imp.importDesc.typeIdx
+			found = true
+			break
+		}
+		idx--
+	}
+	if !found {
+		if int(idx) >= len(m.funcs) {
+			return funcType{}, fmt.Errorf("unknown func %d", idx)
+		}
+		typeIdx = m.funcs[idx].typeIdx
+	}
+	if int(typeIdx) >= len(m.types) {
+		return funcType{}, fmt.Errorf("unknown type %d", typeIdx)
+	}
+	return m.types[typeIdx], nil
+}
+
+// memTypes returns the types of the memory index space, imported memories first.
+func (m module) memTypes() []memType {
+	var mems []memType
+	for _, imp := range m.imports {
+		if imp.kind == exportImportKindMem {
+			mems = append(mems, imp.importDesc.mem.memType)
+		}
+	}
+	for _, mem := range m.mems {
+		mems = append(mems, mem.memType)
+	}
+	return mems
+}
+
+// tableTypes returns the types of the table index space, imported tables first.
+func (m module) tableTypes() []tableType {
+	var tables []tableType
+	for _, imp := range m.imports {
+		if imp.kind == exportImportKindTable {
+			tables = append(tables, imp.importDesc.table.tableType)
+		}
+	}
+	for _, t := range m.tables {
+		tables = append(tables, t.tableType)
+	}
+	return tables
+}
+
+// globalType returns the type of the global at idx in the global index space.
+func (m module) globalType(idx uint32) (globalType, bool) {
+	for _, imp := range m.imports {
+		if imp.kind != exportImportKindGlobal {
+			continue
+		}
+		if idx == 0 {
+			return imp.importDesc.global, true
+		}
+		idx--
+	}
+	if int(idx) >= len(m.globals) {
+		return globalType{}, false
+	}
+	return m.globals[idx].type_, true
+}
+
+// validateOpcodes checks every instr of f was decoded, the parser leaves a nil instr for
+// the opcodes it doesn't know so the disassembler can still print them.
+func validateOpcodes(f function) error {
+	for pc, instr := range f.body {
+		if instr != nil {
+			continue
+		}
+		if pc < len(f.ops) {
+			return fmt.Errorf("instr %d: unknown opcode 0x%02x", pc, uint8(f.ops[pc].code))
+		}
+		return fmt.Errorf("instr %d: unknown opcode", pc)
+	}
+	return nil
+}
+
+// validateLocalIdxs checks local instrs only access the params and declared locals of f.
+func (m module) validateLocalIdxs(f function) error {
+	n := uint64(len(m.types[f.typeIdx].params))
+	for _, l := range f.locals {
+		n += uint64(l.count)
+	}
+	for pc, instr := range f.body {
+		var localIdx int
+		switch o := instr.(type) {
+		case *opLocalGet:
+			localIdx = o.localIdx
+		case *opLocalSet:
+			localIdx = o.localIdx
+		case *opLocalTee:
+			localIdx = o.localIdx
+		default:
+			continue
+		}
+		if localIdx < 0 || uint64(localIdx) >= n {
+			return fmt.Errorf("instr %d: unknown local %d", pc, localIdx)
+		}
+	}
+	return nil
+}
+
+// validateBranches checks every branch targets an enclosing label,
+// the function body itself counts as the outermost label.
+func validateBranches(body []instr) error {
+	depth := 0
+	check := func(pc, level int) error {
+		if level < 0 || level > depth {
+			return fmt.Errorf("instr %d: unknown label %d", pc, level)
+		}
+		return nil
+	}
+	for pc, instr := range body {
+		var err error
+		switch o := instr.(type) {
+		case *opBlock, *opLoop, *opIf:
+			depth++
+		case *opEnd:
+			depth--
+		case *opBr:
+			err = check(pc, o.level)
+		case *opBrIf:
+			err = check(pc, o.level)
+		case *opBrTable:
+			for _, level := range o.labelIdxArr {
+				if err = check(pc, level); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = check(pc, o.defaultIdx)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDataIdxs checks data segment references, which need the data count section
+// as the code section comes before the data section.
+func (m module) validateDataIdxs(body []instr) error {
+	for pc, instr := range body {
+		var dataIdx uint32
+		switch o := instr.(type) {
+		case *opMemoryInit:
+			dataIdx = o.dataIdx
+		case *opDataDrop:
+			dataIdx = o.dataIdx
+		default:
+			continue
+		}
+		if m.dataCount == nil {
+			return fmt.Errorf("instr %d: data count section required", pc)
+		}
+		if dataIdx >= *m.dataCount {
+			return fmt.Errorf("instr %d: unknown data segment %d", pc, dataIdx)
+		}
+	}
+	return nil
+}
+
+// validateMemIdxs checks the memory index of every load and store,
+// the other memory instrs always use memory 0.
+func (m module) validateMemIdxs(body []instr) error {
+	mems := m.indexSpaceLen(exportImportKindMem)
+	for pc, instr := range body {
+		var memIdx uint32
+		switch o := instr.(type) {
+		case *opLoad:
+			memIdx = o.memIdx
+		case *opStore:
+			memIdx = o.memIdx
+		case *opAtomicLoad:
+			memIdx = o.memIdx
+		case *opAtomicStore:
+			memIdx = o.memIdx
+		case *opMemorySize, *opMemoryGrow, *opMemoryFill, *opMemoryCopy, *opMemoryInit:
+		default:
+			continue
+		}
+		if int(memIdx) >= mems {
+			return fmt.Errorf("instr %d: unknown memory %d", pc, memIdx)
+		}
+	}
+	return nil
+}
+
+// validateGlobalIdxs checks globals exist and global.set only targets mutable ones,
+// imported globals keep the mutability they are imported with.
+func (m module) validateGlobalIdxs(body []instr) error {
+	for pc, instr := range body {
+		var globalIdx int
+		set := false
+		switch o := instr.(type) {
+		case *opGlobalGet:
+			globalIdx = o.globalIdx
+		case *opGlobalSet:
+			globalIdx = o.globalIdx
+			set = true
+		default:
+			continue
+		}
+		t, ok := m.globalType(uint32(globalIdx))
+		if !ok {
+			return fmt.Errorf("instr %d: unknown global %d", pc, globalIdx)
+		}
+		if set && t.mut != var_ {
+			return fmt.Errorf("instr %d: global %d is immutable", pc, globalIdx)
+		}
+	}
+	return nil
+}
+
+// validateFuncIdxs checks the funcs called directly or referenced by ref.func exist.
+func (m module) validateFuncIdxs(body []instr) error {
+	funcs := m.indexSpaceLen(exportImportKindFunc)
+	for pc, instr := range body {
+		var funcIdx uint32
+		switch o := instr.(type) {
+		case *opCall:
+			funcIdx = o.funcIdx
+		case *opReturnCall:
+			funcIdx = o.funcIdx
+		case *opRefFunc:
+			funcIdx = o.funcIdx
+		default:
+			continue
+		}
+		if int(funcIdx) >= funcs {
+			return fmt.Errorf("instr %d: unknown func %d", pc, funcIdx)
+		}
+	}
+	return nil
+}
+
+// validateTableIdxs checks the table of table instrs and indirect calls exists,
+// as well as the type an indirect call expects. Indirect calls need a funcref table.
+func (m module) validateTableIdxs(body []instr) error {
+	tables := m.tableTypes()
+	for pc, instr := range body {
+		var tableIdx uint32
+		indirect := false
+		switch o := instr.(type) {
+		case *opCallIndirect:
+			if int(o.typeIdx) >= len(m.types) {
+				return fmt.Errorf("instr %d: unknown type %d", pc, o.typeIdx)
+			}
+			tableIdx = o.tableIdx
+			indirect = true
+		case *opReturnCallIndirect:
+			if int(o.typeIdx) >= len(m.types) {
+				return fmt.Errorf("instr %d: unknown type %d", pc, o.typeIdx)
+			}
+			tableIdx = o.tableIdx
+			indirect = true
+		case *opTableGet:
+			tableIdx = o.tableIdx
+		case *opTableSet:
+			tableIdx = o.tableIdx
+		case *opTableSize:
+			tableIdx = o.tableIdx
+		case *opTableGrow:
+			tableIdx = o.tableIdx
+		case *opTableFill:
+			tableIdx = o.tableIdx
+		default:
+			continue
+		}
+		if int(tableIdx) >= len(tables) {
+			return fmt.Errorf("instr %d: unknown table %d", pc, tableIdx)
+		}
+		if indirect && tables[tableIdx].elemType != FuncRef {
+			return fmt.Errorf("instr %d: indirect call through table %d of %s", pc, tableIdx, typeName(tables[tableIdx].elemType))
+		}
+	}
+	return nil
+}
+
+// operandCtrl is a block being validated by validateOperands.
+type operandCtrl struct {
+	block block
+	loop  bool
+	// height of the stack below the params of the block
+	height int
+	// set after an unconditional branch, the rest of the block can't underflow
+	unreachable bool
+}
+
+// labelArity returns the number of values a branch to the block takes,
+// branching to a loop starts it again with its params.
+func (c operandCtrl) labelArity() int {
+	if c.loop {
+		return c.block.paramArity()
+	}
+	return c.block.resultArity()
+}
+
+// https://webassembly.github.io/spec/core/valid/instructions.html
+// validateOperands checks every instr finds the operands it pops on the stack, the height
+// is tracked per block and a block can't pop the operands of the enclosing ones.
+// It runs once the indices are known to be valid.
+func (m module) validateOperands(f function) error {
+	results := m.types[f.typeIdx].results
+	// the function body is the outermost block
+	ctrls := []operandCtrl{{block: block{valType: results}}}
+	height := 0
+	for pc, instr := range f.body {
+		if len(ctrls) == 0 {
+			return fmt.Errorf("instr %d: after the end of the func", pc)
+		}
+		c := &ctrls[len(ctrls)-1]
+		pop := func(n int) error {
+			if height-n >= c.height {
+				height -= n
+				return nil
+			}
+			if c.unreachable {
+				height = c.height
+				return nil
+			}
+			return fmt.Errorf("instr %d: type mismatch: expected %d operands, got %d", pc, n, height-c.height)
+		}
+		// unconditional branches leave the stack empty until the end of the block
+		skip := func() {
+			height = c.height
+			c.unreachable = true
+		}
+		label := func(level int) operandCtrl {
+			return ctrls[len(ctrls)-1-level]
+		}
+
+		var popped, pushed int
+		switch o := instr.(type) {
+		case *opBlock, *opLoop, *opIf:
+			var b block
+			switch o := o.(type) {
+			case *opBlock:
+				b = o.block
+			case *opLoop:
+				b = o.block
+			case *opIf:
+				b = o.block
+				// the condition
+				if err := pop(1); err != nil {
+					return err
+				}
+			}
+			if err := pop(b.paramArity()); err != nil {
+				return err
+			}
+			_, loop := o.(*opLoop)
+			ctrls = append(ctrls, operandCtrl{block: b, loop: loop, height: height})
+			height += b.paramArity()
+			continue
+		case *opElse:
+			if err := pop(c.block.resultArity()); err != nil {
+				return err
+			}
+			height = c.height + c.block.paramArity()
+			c.unreachable = false
+			continue
+		case *opEnd:
+			if err := pop(c.block.resultArity()); err != nil {
+				return err
+			}
+			height = c.height + c.block.resultArity()
+			ctrls = ctrls[:len(ctrls)-1]
+			continue
+		case *opBr:
+			if err := pop(label(o.level).labelArity()); err != nil {
+				return err
+			}
+			skip()
+			continue
+		case *opBrIf:
+			popped = 1 + label(o.level).labelArity()
+			pushed = label(o.level).labelArity()
+		case *opBrTable:
+			if err := pop(1 + label(o.defaultIdx).labelArity()); err != nil {
+				return err
+			}
+			skip()
+			continue
+		case *opReturn:
+			if err := pop(len(results)); err != nil {
+				return err
+			}
+			skip()
+			continue
+		case *opUnreachable:
+			skip()
+			continue
+		case *opReturnCall:
+			t, _ := m.funcType(o.funcIdx)
+			if err := pop(len(t.params)); err != nil {
+				return err
+			}
+			skip()
+			continue
+		case *opReturnCallIndirect:
+			if err := pop(1 + len(m.types[o.typeIdx].params)); err != nil {
+				return err
+			}
+			skip()
+			continue
+		case *opCall:
+			t, _ := m.funcType(o.funcIdx)
+			popped, pushed = len(t.params), len(t.results)
+		case *opCallIndirect:
+			t := m.types[o.typeIdx]
+			popped, pushed = 1+len(t.params), len(t.results)
+		case *opNop, *opDataDrop:
+		case *opConst, *opLocalGet, *opGlobalGet, *opMemorySize, *opTableSize, *opRefNull, *opRefFunc:
+			pushed = 1
+		case *opUn, *opTest, *opCut, *opReinterpret, *opLoad, *opAtomicLoad, *opLocalTee,
+			*opMemoryGrow, *opTableGet, *opRefIsNull:
+			popped, pushed = 1, 1
+		case *opBin, *opRel, *opTableGrow:
+			popped, pushed = 2, 1
+		case *opLocalSet, *opGlobalSet, *opDrop:
+			popped = 1
+		case *opStore, *opAtomicStore, *opTableSet:
+			popped = 2
+		case *opSelect:
+			popped, pushed = 3, 1
+		case *opMemoryFill, *opMemoryCopy, *opMemoryInit, *opTableFill:
+			popped = 3
+		default:
+			return fmt.Errorf("instr %d: unknown instr %T", pc, instr)
+		}
+		if err := pop(popped); err != nil {
+			return err
+		}
+		height += pushed
+	}
+	return nil
+}
+
+// https://webassembly.github.io/spec/core/valid/instructions.html#constant-expressions
+// validateConstExpr checks initializers only use constants and global.get of immutable
+// imported globals, the defined globals aren't initialized yet when they are evaluated,
+// and that they produce a single value of type want.
+func (m module) validateConstExpr(e expr, want type_) error {
+	imported := m.indexSpaceLen(exportImportKindGlobal) - len(m.globals)
+	var results []type_
+	for pc, instr := range e {
+		switch o := instr.(type) {
+		case *opConst:
+			results = append(results, o.val.ValType)
+		case *opRefNull:
+			results = append(results, o.refType)
+		case *opRefFunc:
+			if int(o.funcIdx) >= m.indexSpaceLen(exportImportKindFunc) {
+				return fmt.Errorf("instr %d: unknown func %d", pc, o.funcIdx)
+			}
+			results = append(results, FuncRef)
+		case *opGlobalGet:
+			if o.globalIdx >= imported {
+				return fmt.Errorf("instr %d: global %d is not an imported global", pc, o.globalIdx)
+			}
+			t, _ := m.globalType(uint32(o.globalIdx))
+			if t.mut != const_ {
+				return fmt.Errorf("instr %d: global %d is mutable", pc, o.globalIdx)
+			}
+			results = append(results, t.valueType)
+		case *opEnd:
+		default:
+			return fmt.Errorf("instr %d: constant expression required", pc)
+		}
+	}
+	if len(results) != 1 {
+		return fmt.Errorf("type mismatch: expected a single %s, got %d values", typeName(want), len(results))
+	}
+	if results[0] != want {
+		return fmt.Errorf("type mismatch: expected %s, got %s", typeName(want), typeName(results[0]))
+	}
+	return nil
+}
@@ -0,0 +1,538 @@
+package wasm_go
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	m := parseWat(t, `
+		(module
+			(import "env" "f" (func))
+			(func $start)
+			(func (export "run") (param i32)
+				(block
+					(loop
+						local.get 0
+						br_table 0 1 2
+					)
+				)
+			)
+			(start $start)
+		)
+	`)
+	assert.NoError(t, m.validate())
+
+	dataCount := uint32(1)
+	cases := []struct {
+		name string
+		m    module
+		err  string
+	}{
+		{
+			name: "unknown func type",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{typeIdx: 0}, {typeIdx: 1}},
+			},
+			err: "func 1: unknown type 1",
+		},
+		{
+			name: "unknown export",
+			m: module{
+				types:   []funcType{{}},
+				imports: []import_{{module: "env", name: "f", kind: exportImportKindFunc}},
+				funcs:   []function{{}},
+				exports: []export{{name: "f", kind: exportImportKindFunc, idx: 2}},
+			},
+			err: "export f: unknown func 2",
+		},
+		{
+			name: "unknown exported memory",
+			m: module{
+				exports: []export{{name: "mem", kind: exportImportKindMem, idx: 0}},
+			},
+			err: "export mem: unknown memory 0",
+		},
+		{
+			name: "br depth",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{
+					&opBlock{}, &opBr{level: 2}, &opEnd{}, &opEnd{},
+				}}},
+			},
+			err: "func 0: instr 1: unknown label 2",
+		},
+		{
+			name: "br_table default depth",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{
+					&opBrTable{labelIdxArr: []int{0}, defaultIdx: 1}, &opEnd{},
+				}}},
+			},
+			err: "func 0: instr 0: unknown label 1",
+		},
+		{
+			name: "unknown memory",
+			m: module{
+				types: []funcType{{}},
+				mems:  []mem{{}},
+				funcs: []function{{body: []instr{
+					&opConst{val: ValueFromI32(0)}, &opLoad{memIdx: 1}, &opEnd{},
+				}}},
+			},
+			err: "func 0: instr 1: unknown memory 1",
+		},
+		{
+			name: "memory.size without memory",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opMemorySize{}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown memory 0",
+		},
+		{
+			name: "memory.grow without memory",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opConst{val: ValueFromI32(1)}, &opMemoryGrow{}, &opEnd{}}}},
+			},
+			err: "func 0: instr 1: unknown memory 0",
+		},
+		{
+			name: "memory.fill without memory",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opMemoryFill{}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown memory 0",
+		},
+		{
+			name: "memory.copy without memory",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opMemoryCopy{}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown memory 0",
+		},
+		{
+			name: "memory.init without memory",
+			m: module{
+				types:     []funcType{{}},
+				funcs:     []function{{body: []instr{&opMemoryInit{dataIdx: 0}, &opEnd{}}}},
+				datas:     []data{{mode: dataModePassive}},
+				dataCount: &dataCount,
+			},
+			err: "func 0: instr 0: unknown memory 0",
+		},
+		{
+			name: "data segment unknown memory",
+			m: module{
+				mems:  []mem{{}},
+				datas: []data{{mode: dataModeActive, memIdx: 1, offset: expr{&opConst{val: ValueFromI32(0)}, &opEnd{}}}},
+			},
+			err: "data 0: unknown memory 1",
+		},
+		{
+			name: "data segment without memory",
+			m: module{
+				datas: []data{{mode: dataModeActive, offset: expr{&opConst{val: ValueFromI32(0)}, &opEnd{}}}},
+			},
+			err: "data 0: unknown memory 0",
+		},
+		{
+			name: "set immutable imported global",
+			m: module{
+				types: []funcType{{}},
+				imports: []import_{{
+					module: "env", name: "g", kind: exportImportKindGlobal,
+					importDesc: importDesc{global: globalType{valueType: I32, mut: const_}},
+				}},
+				globals: []global{{type_: globalType{valueType: I32, mut: var_}}},
+				funcs: []function{{body: []instr{
+					&opConst{val: ValueFromI32(0)}, &opGlobalSet{globalIdx: 1},
+					&opConst{val: ValueFromI32(0)}, &opGlobalSet{globalIdx: 0}, &opEnd{},
+				}}},
+			},
+			err: "func 0: instr 3: global 0 is immutable",
+		},
+		{
+			name: "unknown global",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opGlobalGet{globalIdx: 0}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown global 0",
+		},
+		{
+			name: "unknown start func",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{}},
+				start: &start{funcIdx: 1},
+			},
+			err: "unknown start func 1",
+		},
+		{
+			name: "start func with params",
+			m: module{
+				types: []funcType{{params: []type_{I32}}},
+				funcs: []function{{}},
+				start: &start{funcIdx: 0},
+			},
+			err: "start func 0 must not have params or results",
+		},
+		{
+			name: "call unknown func",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opCall{funcIdx: 1}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown func 1",
+		},
+		{
+			name: "return_call unknown func",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opReturnCall{funcIdx: 1}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown func 1",
+		},
+		{
+			name: "ref.func unknown func",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opRefFunc{funcIdx: 1}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown func 1",
+		},
+		{
+			name: "ref.func initializer unknown func",
+			m: module{
+				globals: []global{{
+					type_:    globalType{valueType: FuncRef, mut: const_},
+					initExpr: expr{&opRefFunc{funcIdx: 0}, &opEnd{}},
+				}},
+			},
+			err: "global 0: instr 0: unknown func 0",
+		},
+		{
+			name: "elem unknown func",
+			m: module{
+				types:  []funcType{{}},
+				funcs:  []function{{}},
+				tables: []table{{}},
+				elems:  []elem{{mode: elemModePassive, init: []uint32{0, 1}}},
+			},
+			err: "elem 0: unknown func 1",
+		},
+		{
+			name: "elem unknown table",
+			m: module{
+				elems: []elem{{mode: elemModeActive, tableIdx: 0, offset: expr{&opConst{val: ValueFromI32(0)}, &opEnd{}}}},
+			},
+			err: "elem 0: unknown table 0",
+		},
+		{
+			name: "call_indirect unknown type",
+			m: module{
+				types:  []funcType{{}},
+				tables: []table{{}},
+				funcs: []function{{body: []instr{
+					&opConst{val: ValueFromI32(0)}, &opCallIndirect{typeIdx: 1}, &opEnd{},
+				}}},
+			},
+			err: "func 0: instr 1: unknown type 1",
+		},
+		{
+			name: "call_indirect unknown table",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{
+					&opConst{val: ValueFromI32(0)}, &opCallIndirect{typeIdx: 0}, &opEnd{},
+				}}},
+			},
+			err: "func 0: instr 1: unknown table 0",
+		},
+		{
+			name: "return_call_indirect unknown type",
+			m: module{
+				types:  []funcType{{}},
+				tables: []table{{}},
+				funcs: []function{{body: []instr{
+					&opConst{val: ValueFromI32(0)}, &opReturnCallIndirect{typeIdx: 2}, &opEnd{},
+				}}},
+			},
+			err: "func 0: instr 1: unknown type 2",
+		},
+		{
+			name: "table.get unknown table",
+			m: module{
+				types:  []funcType{{}},
+				tables: []table{{}},
+				funcs: []function{{body: []instr{
+					&opConst{val: ValueFromI32(0)}, &opTableGet{tableIdx: 1}, &opEnd{},
+				}}},
+			},
+			err: "func 0: instr 1: unknown table 1",
+		},
+		{
+			name: "table.set unknown table",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opTableSet{tableIdx: 0}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown table 0",
+		},
+		{
+			name: "table.size unknown table",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opTableSize{tableIdx: 0}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown table 0",
+		},
+		{
+			name: "table.grow unknown table",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opTableGrow{tableIdx: 0}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown table 0",
+		},
+		{
+			name: "table.fill unknown table",
+			m: module{
+				types: []funcType{{}},
+				funcs: []function{{body: []instr{&opTableFill{tableIdx: 0}, &opEnd{}}}},
+			},
+			err: "func 0: instr 0: unknown table 0",
+		},
+		{
+			name: "import unknown type",
+			m: module{
+				imports: []import_{{
+					module: "env", name: "f", kind: exportImportKindFunc,
+					importDesc: importDesc{typeIdx: 0},
+				}},
+			},
+			err: "import env.f: unknown type 0",
+		},
+	}
+	for _, c := range cases {
+		assert.EqualError(t, c.m.validate(), c.err, c.name)
+	}
+}
+
+func TestValidateDataIdx(t *testing.T) {
+	count := uint32(1)
+	m := module{
+		types: []funcType{{}},
+		funcs: []function{{body: []instr{&opDataDrop{dataIdx: 0}, &opEnd{}}}},
+	}
+	assert.EqualError(t, m.validate(), "func 0: instr 0: data count section required")
+
+	m.dataCount = &count
+	m.datas = []data{{mode: dataModePassive}}
+	assert.NoError(t, m.validate())
+
+	m.funcs[0].body[0] = &opMemoryInit{dataIdx: 1}
+	assert.EqualError(t, m.validate(), "func 0: instr 0: unknown data segment 1")
+}
+
+func TestValidateLocalIdx(t *testing.T) {
+	m := module{
+		types: []funcType{{params: []type_{I32}}},
+		funcs: []function{{
+			locals: []locals{{count: 1, valType: I64}},
+			body:   []instr{&opLocalGet{localIdx: 1}, &opLocalSet{localIdx: 1}, &opEnd{}},
+		}},
+	}
+	assert.NoError(t, m.validate())
+
+	// one param and one local
+	m.funcs[0].body[1] = &opLocalSet{localIdx: 2}
+	assert.EqualError(t, m.validate(), "func 0: instr 1: unknown local 2")
+	m.funcs[0].body[1] = &opLocalTee{localIdx: 2}
+	assert.EqualError(t, m.validate(), "func 0: instr 1: unknown local 2")
+	m.funcs[0].body[0] = &opLocalGet{localIdx: 2}
+	assert.EqualError(t, m.validate(), "func 0: instr 0: unknown local 2")
+}
+
+func TestValidateConstExpr(t *testing.T) {
+	m := parseWat(t, `
+		(module
+			(import "env" "base" (global $base i32))
+			(global i32 (i32.const 1))
+			(global i32 (global.get $base))
+			(global funcref (ref.null func))
+		)
+	`)
+	assert.NoError(t, m.validate())
+
+	m = parseWat(t, `
+		(module
+			(global i32 (i32.add (i32.const 1) (i32.const 2)))
+		)
+	`)
+	assert.EqualError(t, m.validate(), "global 0: instr 2: constant expression required")
+
+	m = parseWat(t, `
+		(module
+			(import "env" "counter" (global $counter (mut i32)))
+			(global $one i32 (i32.const 1))
+			(global i32 (global.get $one))
+			(global i32 (global.get $counter))
+		)
+	`)
+	assert.EqualError(t, m.validate(), "global 1: instr 0: global 1 is not an imported global")
+	m.globals = m.globals[2:]
+	assert.EqualError(t, m.validate(), "global 0: instr 0: global 0 is mutable")
+
+	m = parseWat(t, `
+		(module
+			(memory 1)
+			(data (offset (i32.const 1) (drop) (i32.const 2)) "a")
+		)
+	`)
+	assert.EqualError(t, m.validate(), "data 0: instr 1: constant expression required")
+}
+
+func TestValidateOffsetType(t *testing.T) {
+	m := parseWat(t, `
+		(module
+			(memory 1)
+			(table 1 funcref)
+			(func $f)
+			(data (i32.const 1) "a")
+			(elem (i32.const 0) $f)
+		)
+	`)
+	assert.NoError(t, m.validate())
+
+	m = parseWat(t, `
+		(module
+			(memory 1)
+			(data (f32.const 1) "a")
+		)
+	`)
+	assert.EqualError(t, m.validate(), "data 0: type mismatch: expected i32, got f32")
+
+	m = parseWat(t, `
+		(module
+			(table 1 funcref)
+			(func $f)
+			(elem (offset (i32.const 0) (i32.const 0)) $f)
+		)
+	`)
+	assert.EqualError(t, m.validate(), "elem 0: type mismatch: expected a single i32, got 2 values")
+
+	m = parseWat(t, `
+		(module
+			(global i64 (i32.const 0))
+		)
+	`)
+	assert.EqualError(t, m.validate(), "global 0: type mismatch: expected i64, got i32")
+}
+
+func TestValidateUnknownOpcode(t *testing.T) {
+	// 0x06 is unassigned and 0x1c, the typed select, isn't decoded
+	for _, op := range []byte{0x06, 0x1c} {
+		body := []byte{byte(OpcodeI32Const), 1, byte(OpcodeI32Const), 2, byte(OpcodeI32Const), 0, op, 1, byte(I32), byte(OpcodeDrop)}
+		_, err := NewModuleBuilder().AddFunc(nil, nil, body).Build()
+		assert.EqualError(t, err, fmt.Sprintf("invalid module: func 0: instr 3: unknown opcode 0x%02x", op))
+	}
+
+	// the interpreter refuses the module instead of panicking on the first call
+	b := NewModuleBuilder().AddFunc(nil, nil, []byte{0x06}).Export("run", 0)
+	wasm, err := b.m.encode()
+	assert.NoError(t, err)
+	_, err = NewInterpreter(wasm)
+	assert.EqualError(t, err, "func 0: instr 0: unknown opcode 0x06")
+}
+
+func TestValidateOperands(t *testing.T) {
+	m := parseWat(t, `
+		(module
+			(func $f (param i32) (result i32) local.get 0)
+			(func (param i32) (result i32)
+				(block (result i32)
+					local.get 0
+					local.get 0
+					br_if 0
+					i32.const 1
+					i32.add
+				)
+				(if (param i32) (result i32) (local.get 0)
+					(then i32.eqz)
+					(else call $f)
+				)
+				unreachable
+				i32.add
+			)
+		)
+	`)
+	assert.NoError(t, m.validate())
+
+	cases := []struct {
+		name   string
+		result string
+		body   string
+		err    string
+	}{
+		{
+			name: "if without condition",
+			body: `(if (then))`,
+			err:  "func 0: instr 0: type mismatch: expected 1 operands, got 0",
+		},
+		{
+			name: "br_if without condition",
+			body: `(block (br_if 0))`,
+			err:  "func 0: instr 1: type mismatch: expected 1 operands, got 0",
+		},
+		{
+			name: "br_if without label operand",
+			body: `(block (result i32) (br_if 0 (i32.const 1)) (drop (i32.const 0)))`,
+			err:  "func 0: instr 2: type mismatch: expected 2 operands, got 1",
+		},
+		{
+			name: "select",
+			body: `i32.const 1 i32.const 2 select drop`,
+			err:  "func 0: instr 2: type mismatch: expected 3 operands, got 2",
+		},
+		{
+			name: "binary",
+			body: `i32.const 1 i32.add drop`,
+			err:  "func 0: instr 1: type mismatch: expected 2 operands, got 1",
+		},
+		{
+			name: "unary",
+			body: `i32.eqz drop`,
+			err:  "func 0: instr 0: type mismatch: expected 1 operands, got 0",
+		},
+		{
+			name: "operands of the enclosing block",
+			body: `i32.const 1 (block i32.eqz drop) drop`,
+			err:  "func 0: instr 2: type mismatch: expected 1 operands, got 0",
+		},
+		{
+			name: "block result",
+			body: `(block (result i32)) drop`,
+			err:  "func 0: instr 1: type mismatch: expected 1 operands, got 0",
+		},
+		{
+			name:   "func result",
+			result: "(result i32)",
+			body:   `(block (result i32) (unreachable)) drop`,
+			err:    "func 0: instr 4: type mismatch: expected 1 operands, got 0",
+		},
+	}
+	for _, c := range cases {
+		m := parseWat(t, `(module (func `+c.result+` `+c.body+`))`)
+		assert.EqualError(t, m.validate(), c.err, c.name)
+	}
+}
@@ -0,0 +1,131 @@
+package wasm_go
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// https://github.com/WebAssembly/WASI/blob/main/legacy/preview1/docs.md
+const wasiModule = "wasi_snapshot_preview1"
+
+// https://github.com/WebAssembly/WASI/blob/main/legacy/preview1/docs.md#errno
+const (
+	wasiErrnoSuccess int32 = 0
+	wasiErrnoBadf    int32 = 8
+	wasiErrnoIo      int32 = 29
+)
+
+// WASI implements a subset of wasi_snapshot_preview1: fd_write on stdout and stderr,
+// environ_get, environ_sizes_get and proc_exit.
+type WASI struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	// "KEY=value" pairs
+	Env []string
+}
+
+// ExitError is returned by a call that ended with proc_exit.
+type ExitError struct {
+	Code int32
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Define registers the WASI functions with l, they use the default memory of the importing module.
+func (w *WASI) Define(l *Linker) {
+	l.defineMemFunc(wasiModule, "fd_write", []ValType{I32, I32, I32, I32}, []ValType{I32}, w.fdWrite)
+	l.defineMemFunc(wasiModule, "environ_get", []ValType{I32, I32}, []ValType{I32}, w.environGet)
+	l.defineMemFunc(wasiModule, "environ_sizes_get", []ValType{I32, I32}, []ValType{I32}, w.environSizesGet)
+	l.DefineFunc(wasiModule, "proc_exit", []ValType{I32}, nil, func(args []Value) ([]Value, error) {
+		return nil, &ExitError{Code: args[0].I32()}
+	})
+}
+
+// fd_write(fd, iovs, iovs_len, nwritten) writes the buffers listed by iovs,
+// each iovec is a u32 pointer followed by a u32 length.
+func (w *WASI) fdWrite(mem []byte, args []Value) ([]Value, error) {
+	fd, iovs, iovsLen, nwrittenPtr := args[0].I32(), uint32(args[1].I32()), uint32(args[2].I32()), uint32(args[3].I32())
+	var out io.Writer
+	switch fd {
+	case 1:
+		out = w.Stdout
+	case 2:
+		out = w.Stderr
+	}
+	if out == nil {
+		return []Value{ValueFromI32(wasiErrnoBadf)}, nil
+	}
+
+	nwritten := uint32(0)
+	for x := uint64(0); x < uint64(iovsLen); x++ {
+		iov, err := memSlice(mem, uint64(iovs)+x*8, 8)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := memSlice(mem, uint64(binary.LittleEndian.Uint32(iov)), uint64(binary.LittleEndian.Uint32(iov[4:])))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := out.Write(buf); err != nil {
+			return []Value{ValueFromI32(wasiErrnoIo)}, nil
+		}
+		nwritten += uint32(len(buf))
+	}
+	if err := putU32(mem, uint64(nwrittenPtr), nwritten); err != nil {
+		return nil, err
+	}
+	return []Value{ValueFromI32(wasiErrnoSuccess)}, nil
+}
+
+// environ_sizes_get(count, buf_size) stores the number of variables and the size of their NUL terminated strings.
+func (w *WASI) environSizesGet(mem []byte, args []Value) ([]Value, error) {
+	size := 0
+	for _, env := range w.Env {
+		size += len(env) + 1
+	}
+	if err := putU32(mem, uint64(uint32(args[0].I32())), uint32(len(w.Env))); err != nil {
+		return nil, err
+	}
+	if err := putU32(mem, uint64(uint32(args[1].I32())), uint32(size)); err != nil {
+		return nil, err
+	}
+	return []Value{ValueFromI32(wasiErrnoSuccess)}, nil
+}
+
+// environ_get(environ, environ_buf) copies the NUL terminated variables to environ_buf
+// and a pointer to each of them to environ.
+func (w *WASI) environGet(mem []byte, args []Value) ([]Value, error) {
+	environ, buf := uint32(args[0].I32()), uint32(args[1].I32())
+	for x, env := range w.Env {
+		if err := putU32(mem, uint64(environ)+uint64(x)*4, buf); err != nil {
+			return nil, err
+		}
+		dst, err := memSlice(mem, uint64(buf), uint64(len(env))+1)
+		if err != nil {
+			return nil, err
+		}
+		copy(dst, env)
+		dst[len(env)] = 0
+		buf += uint32(len(dst))
+	}
+	return []Value{ValueFromI32(wasiErrnoSuccess)}, nil
+}
+
+func memSlice(mem []byte, offset, length uint64) ([]byte, error) {
+	if offset+length > uint64(len(mem)) {
+		return nil, errOutOfBounds
+	}
+	return mem[offset : offset+length], nil
+}
+
+func putU32(mem []byte, offset uint64, v uint32) error {
+	b, err := memSlice(mem, offset, 4)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b, v)
+	return nil
+}
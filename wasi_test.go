@@ -0,0 +1,96 @@
+package wasm_go
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWASIHelloWorld(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+			(import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+			(memory (export "memory") 1)
+			;; iovec at 0 pointing to the string at 16
+			(data (i32.const 0) "\10\00\00\00\0c\00\00\00")
+			(data (i32.const 16) "hello world\n")
+			(func (export "_start")
+				i32.const 1
+				i32.const 0
+				i32.const 1
+				i32.const 8
+				call $fd_write
+				drop
+				i32.const 3
+				call $proc_exit
+			)
+			(func (export "bad_fd") (result i32)
+				i32.const 9
+				i32.const 0
+				i32.const 1
+				i32.const 8
+				call $fd_write
+			)
+		)
+	`)
+	assert.NoError(t, err)
+
+	var stdout bytes.Buffer
+	l := NewLinker()
+	(&WASI{Stdout: &stdout}).Define(l)
+	i, err := l.Instantiate(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = invokeExport(t, &i, "_start")
+	var exit *ExitError
+	if assert.True(t, errors.As(err, &exit)) {
+		assert.Equal(t, int32(3), exit.Code)
+	}
+	assert.Equal(t, "hello world\n", stdout.String())
+	nwritten, err := i.ReadMemory(8, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{12, 0, 0, 0}, nwritten)
+
+	ret, err := invokeExport(t, &i, "bad_fd")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(wasiErrnoBadf)}, ret)
+}
+
+func TestWASIEnviron(t *testing.T) {
+	wasm, err := wat2wasm(`
+		(module
+			(import "wasi_snapshot_preview1" "environ_sizes_get" (func $sizes (param i32 i32) (result i32)))
+			(import "wasi_snapshot_preview1" "environ_get" (func $get (param i32 i32) (result i32)))
+			(memory 1)
+			(func (export "environ") (result i32)
+				i32.const 0
+				i32.const 4
+				call $sizes
+				drop
+				i32.const 8
+				i32.const 16
+				call $get
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	l := NewLinker()
+	(&WASI{Env: []string{"A=1", "HOME=/"}}).Define(l)
+	i, err := l.Instantiate(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ret, err := invokeExport(t, &i, "environ")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(wasiErrnoSuccess)}, ret)
+
+	mem, err := i.ReadMemory(0, 27)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2, 0, 0, 0, 11, 0, 0, 0}, mem[:8])
+	assert.Equal(t, []byte{16, 0, 0, 0, 20, 0, 0, 0}, mem[8:16])
+	assert.Equal(t, []byte("A=1\x00HOME=/\x00"), mem[16:27])
+}
@@ -0,0 +1,1692 @@
+package wasm_go
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// wat2wasm assembles a module written in the text format, so the fixtures don't need an
+// external toolchain. It covers the fields and instructions the tests use, folded ones
+// included, and keeps the $ids in a name section.
+// https://webassembly.github.io/spec/core/text/modules.html
+func wat2wasm(text string) ([]byte, error) {
+	r := sexprReader{text: text}
+	root, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.skipSpace(); err != nil {
+		return nil, err
+	}
+	if r.pos < len(r.text) {
+		return nil, fmt.Errorf("unexpected text after the module")
+	}
+	a := watAssembler{sections: map[SectionID][][]byte{}}
+	if err := a.module(root); err != nil {
+		return nil, err
+	}
+	return a.encode(), nil
+}
+
+// sexpr is an atom, a string or a list of sexprs.
+type sexpr struct {
+	atom string
+	// str is set for string literals, atom holds the unquoted content
+	str    bool
+	isList bool
+	list   []*sexpr
+}
+
+// head returns the keyword a list starts with, like "func" for (func ...).
+func (e *sexpr) head() string {
+	if !e.isList || len(e.list) == 0 || e.list[0].isList || e.list[0].str {
+		return ""
+	}
+	return e.list[0].atom
+}
+
+func (e *sexpr) isAtom() bool {
+	return !e.isList && !e.str
+}
+
+func (e *sexpr) isID() bool {
+	return e.isAtom() && strings.HasPrefix(e.atom, "$")
+}
+
+// isIdx reports whether e is a $id or an index.
+func (e *sexpr) isIdx() bool {
+	if e.isID() {
+		return true
+	}
+	_, err := parseU32(e)
+	return err == nil
+}
+
+// https://webassembly.github.io/spec/core/text/lexical.html
+type sexprReader struct {
+	text string
+	pos  int
+}
+
+// skipSpace skips white space, line comments and block comments.
+func (r *sexprReader) skipSpace() error {
+	for r.pos < len(r.text) {
+		rest := r.text[r.pos:]
+		switch {
+		case strings.HasPrefix(rest, ";;"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				r.pos = len(r.text)
+			} else {
+				r.pos += end + 1
+			}
+		case strings.HasPrefix(rest, "(;"):
+			end := strings.Index(rest, ";)")
+			if end < 0 {
+				return fmt.Errorf("unterminated block comment")
+			}
+			r.pos += end + 2
+		case strings.IndexByte(" \t\n\r", rest[0]) >= 0:
+			r.pos++
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *sexprReader) next() (*sexpr, error) {
+	if err := r.skipSpace(); err != nil {
+		return nil, err
+	}
+	if r.pos >= len(r.text) {
+		return nil, fmt.Errorf("unexpected end of text")
+	}
+	switch r.text[r.pos] {
+	case '(':
+		r.pos++
+		e := &sexpr{isList: true}
+		for {
+			if err := r.skipSpace(); err != nil {
+				return nil, err
+			}
+			if r.pos < len(r.text) && r.text[r.pos] == ')' {
+				r.pos++
+				return e, nil
+			}
+			child, err := r.next()
+			if err != nil {
+				return nil, err
+			}
+			e.list = append(e.list, child)
+		}
+	case ')':
+		return nil, fmt.Errorf("unexpected )")
+	case '"':
+		return r.string()
+	}
+	start := r.pos
+	for r.pos < len(r.text) && strings.IndexByte(" \t\n\r()\";", r.text[r.pos]) < 0 {
+		r.pos++
+	}
+	return &sexpr{atom: r.text[start:r.pos]}, nil
+}
+
+// https://webassembly.github.io/spec/core/text/values.html#strings
+func (r *sexprReader) string() (*sexpr, error) {
+	r.pos++
+	var b []byte
+	for r.pos < len(r.text) {
+		c := r.text[r.pos]
+		r.pos++
+		if c == '"' {
+			return &sexpr{atom: string(b), str: true}, nil
+		}
+		if c != '\\' {
+			b = append(b, c)
+			continue
+		}
+		if r.pos >= len(r.text) {
+			break
+		}
+		switch esc := r.text[r.pos]; esc {
+		case 't':
+			b = append(b, '\t')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case '\\', '"', '\'':
+			b = append(b, esc)
+		default:
+			// two hex digits
+			if r.pos+2 > len(r.text) {
+				return nil, fmt.Errorf("invalid string escape")
+			}
+			v, err := strconv.ParseUint(r.text[r.pos:r.pos+2], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string escape \\%s", r.text[r.pos:r.pos+2])
+			}
+			b = append(b, byte(v))
+			r.pos++
+		}
+		r.pos++
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+// watSpace is an index space, imports come first in it.
+type watSpace struct {
+	ids map[string]uint32
+	// names of the indices that have an $id, for the name section
+	names map[uint32]string
+	len   uint32
+	// defined counts the entries assembled so far
+	defined uint32
+}
+
+func (s *watSpace) declare(id string) error {
+	if id != "" {
+		if s.ids == nil {
+			s.ids = map[string]uint32{}
+			s.names = map[uint32]string{}
+		}
+		if _, ok := s.ids[id]; ok {
+			return fmt.Errorf("duplicate name %s", id)
+		}
+		s.ids[id] = s.len
+		s.names[s.len] = strings.TrimPrefix(id, "$")
+	}
+	s.len++
+	return nil
+}
+
+// next returns the index of the entry being assembled, imports are assembled before
+// the definitions.
+func (s *watSpace) next() uint32 {
+	s.defined++
+	return s.defined - 1
+}
+
+// idx reads an index or a $id bound in the space.
+func (s *watSpace) idx(e *sexpr) (uint32, error) {
+	if e.isID() {
+		idx, ok := s.ids[e.atom]
+		if !ok {
+			return 0, fmt.Errorf("unknown name %s", e.atom)
+		}
+		return idx, nil
+	}
+	return parseU32(e)
+}
+
+type watAssembler struct {
+	types []funcType
+	// the index spaces, types only hold the explicit ones
+	typeSpace, funcs, tables, mems, globals, elems, datas watSpace
+	// encoded entries of the sections, but the type, start and data count ones
+	sections map[SectionID][][]byte
+	start    *uint32
+	// memory.init and data.drop need a data count section
+	dataCount bool
+
+	moduleName string
+	// names of the locals of each func
+	localNames map[uint32]map[uint32]string
+}
+
+func (a *watAssembler) module(root *sexpr) error {
+	if root.head() != "module" {
+		return fmt.Errorf("expected (module ...)")
+	}
+	fields := root.list[1:]
+	if len(fields) > 0 && fields[0].isID() {
+		a.moduleName = strings.TrimPrefix(fields[0].atom, "$")
+		fields = fields[1:]
+	}
+
+	// every index space is known before the fields, which may refer ahead
+	for _, f := range fields {
+		if err := a.declare(f); err != nil {
+			return err
+		}
+	}
+	for _, f := range fields {
+		var err error
+		switch f.head() {
+		case "type":
+		case "import":
+			err = a.importField(f)
+		case "func":
+			err = a.funcField(f)
+		case "table":
+			err = a.tableField(f)
+		case "memory":
+			err = a.memoryField(f)
+		case "global":
+			err = a.globalField(f)
+		case "export":
+			err = a.exportField(f)
+		case "start":
+			err = a.startField(f)
+		case "elem":
+			err = a.elemField(f)
+		case "data":
+			err = a.dataField(f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldID returns the $id a field starts with, if any.
+func fieldID(f *sexpr) string {
+	if len(f.list) > 1 && f.list[1].isID() {
+		return f.list[1].atom
+	}
+	return ""
+}
+
+// fieldRest returns the items of a field after its keyword and $id.
+func fieldRest(f *sexpr) []*sexpr {
+	if fieldID(f) != "" {
+		return f.list[2:]
+	}
+	return f.list[1:]
+}
+
+func (a *watAssembler) space(kind string) *watSpace {
+	switch kind {
+	case "func":
+		return &a.funcs
+	case "table":
+		return &a.tables
+	case "memory":
+		return &a.mems
+	case "global":
+		return &a.globals
+	case "elem":
+		return &a.elems
+	case "data":
+		return &a.datas
+	}
+	return nil
+}
+
+// declare binds the $id of a field in its index space.
+func (a *watAssembler) declare(f *sexpr) error {
+	switch kind := f.head(); kind {
+	case "type":
+		if err := a.typeSpace.declare(fieldID(f)); err != nil {
+			return err
+		}
+		return a.typeField(f)
+	case "import":
+		// (import "module" "name" (kind $id? ...))
+		if len(f.list) != 4 || !f.list[1].str || !f.list[2].str || a.space(f.list[3].head()) == nil {
+			return fmt.Errorf("expected (import \"module\" \"name\" (kind ...))")
+		}
+		return a.space(f.list[3].head()).declare(fieldID(f.list[3]))
+	case "func", "memory", "global", "elem", "data":
+		return a.space(kind).declare(fieldID(f))
+	case "table":
+		// (table reftype (elem ...)) declares an element segment too
+		rest := fieldRest(f)
+		if n := len(rest); n > 0 && rest[n-1].head() == "elem" {
+			if err := a.elems.declare(""); err != nil {
+				return err
+			}
+		}
+		return a.tables.declare(fieldID(f))
+	case "export", "start":
+		return nil
+	case "":
+		return fmt.Errorf("expected a module field")
+	default:
+		return fmt.Errorf("%s fields are not supported", kind)
+	}
+}
+
+// (type $id? (func (param ...)* (result ...)*))
+func (a *watAssembler) typeField(f *sexpr) error {
+	rest := fieldRest(f)
+	if len(rest) != 1 || rest[0].head() != "func" {
+		return fmt.Errorf("expected (type (func ...))")
+	}
+	t := funcType{}
+	for _, field := range rest[0].list[1:] {
+		_, types, err := valTypes(field)
+		if err != nil {
+			return err
+		}
+		switch field.head() {
+		case "param":
+			t.params = append(t.params, types...)
+		case "result":
+			t.results = append(t.results, types...)
+		default:
+			return fmt.Errorf("unexpected %s in type", field.head())
+		}
+	}
+	a.types = append(a.types, t)
+	return nil
+}
+
+// typeIdx returns the index of the first type equal to t, appending t when there is none.
+func (a *watAssembler) typeIdx(t funcType) uint32 {
+	for i, other := range a.types {
+		if other.equal(t) {
+			return uint32(i)
+		}
+	}
+	a.types = append(a.types, t)
+	return uint32(len(a.types) - 1)
+}
+
+// typeUse reads a (type idx) and the params and results following it. The ids of the params
+// are returned by their index.
+// https://webassembly.github.io/spec/core/text/modules.html#type-uses
+func (a *watAssembler) typeUse(items []*sexpr) (uint32, []string, []*sexpr, error) {
+	t := funcType{}
+	var typeUse *uint32
+	var ids []string
+	for len(items) > 0 {
+		item := items[0]
+		if item.head() == "type" {
+			if len(item.list) != 2 {
+				return 0, nil, nil, fmt.Errorf("expected (type idx)")
+			}
+			typeIdx, err := a.typeSpace.idx(item.list[1])
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			if int(typeIdx) >= len(a.types) {
+				return 0, nil, nil, fmt.Errorf("unknown type %d", typeIdx)
+			}
+			typeUse = &typeIdx
+		} else if item.head() == "param" || item.head() == "result" {
+			id, types, err := valTypes(item)
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			if item.head() == "param" {
+				for range types {
+					ids = append(ids, id)
+				}
+				t.params = append(t.params, types...)
+			} else {
+				t.results = append(t.results, types...)
+			}
+		} else {
+			break
+		}
+		items = items[1:]
+	}
+	if typeUse != nil {
+		return *typeUse, ids, items, nil
+	}
+	return a.typeIdx(t), ids, items, nil
+}
+
+// inlineExports adds the (export "name") fields of the entry idx.
+func (a *watAssembler) inlineExports(items []*sexpr, kind exportImportKind, idx uint32) ([]*sexpr, error) {
+	for len(items) > 0 && items[0].head() == "export" {
+		if len(items[0].list) != 2 || !items[0].list[1].str {
+			return nil, fmt.Errorf("expected (export \"name\")")
+		}
+		a.export(items[0].list[1].atom, kind, idx)
+		items = items[1:]
+	}
+	return items, nil
+}
+
+func (a *watAssembler) export(name string, kind exportImportKind, idx uint32) {
+	w := leb128Writer{}
+	w.writeU32(uint32(len(name)))
+	w.writeString(name)
+	w.writeU8(uint8(kind))
+	w.writeU32(idx)
+	a.sections[ExportSection] = append(a.sections[ExportSection], w.bytes)
+}
+
+// (import "module" "name" (func|table|memory|global $id? ...))
+func (a *watAssembler) importField(f *sexpr) error {
+	w := leb128Writer{}
+	for _, name := range f.list[1:3] {
+		w.writeU32(uint32(len(name.atom)))
+		w.writeString(name.atom)
+	}
+	desc := f.list[3]
+	a.space(desc.head()).next()
+	rest := fieldRest(desc)
+	switch desc.head() {
+	case "func":
+		typeIdx, _, rest, err := a.typeUse(rest)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return fmt.Errorf("unexpected items in an imported func")
+		}
+		w.writeU8(uint8(exportImportKindFunc))
+		w.writeU32(typeIdx)
+	case "table":
+		w.writeU8(uint8(exportImportKindTable))
+		if err := a.tableType(&w, rest); err != nil {
+			return err
+		}
+	case "memory":
+		w.writeU8(uint8(exportImportKindMem))
+		if err := writeWatLimits(&w, rest); err != nil {
+			return err
+		}
+	case "global":
+		w.writeU8(uint8(exportImportKindGlobal))
+		if len(rest) != 1 {
+			return fmt.Errorf("expected a global type")
+		}
+		if err := writeGlobalType(&w, rest[0]); err != nil {
+			return err
+		}
+	}
+	a.sections[ImportSection] = append(a.sections[ImportSection], w.bytes)
+	return nil
+}
+
+// (func $id? (export "name")* typeuse (local ...)* instr*)
+func (a *watAssembler) funcField(f *sexpr) error {
+	idx := a.funcs.next()
+	rest, err := a.inlineExports(fieldRest(f), exportImportKindFunc, idx)
+	if err != nil {
+		return err
+	}
+	typeIdx, paramIDs, rest, err := a.typeUse(rest)
+	if err != nil {
+		return err
+	}
+	t := leb128Writer{}
+	t.writeU32(typeIdx)
+	a.sections[FunctionSection] = append(a.sections[FunctionSection], t.bytes)
+
+	localIDs := map[string]uint32{}
+	names := map[uint32]string{}
+	bind := func(id string, localIdx uint32) {
+		if id != "" {
+			localIDs[id] = localIdx
+			names[localIdx] = strings.TrimPrefix(id, "$")
+		}
+	}
+	for i, id := range paramIDs {
+		bind(id, uint32(i))
+	}
+	n := uint32(len(a.types[typeIdx].params))
+	var localTypes []type_
+	for len(rest) > 0 && rest[0].head() == "local" {
+		id, types, err := valTypes(rest[0])
+		if err != nil {
+			return err
+		}
+		bind(id, n+uint32(len(localTypes)))
+		localTypes = append(localTypes, types...)
+		rest = rest[1:]
+	}
+	if len(names) > 0 {
+		if a.localNames == nil {
+			a.localNames = map[uint32]map[uint32]string{}
+		}
+		a.localNames[idx] = names
+	}
+
+	var fnLocals []locals
+	for _, lt := range localTypes {
+		if n := len(fnLocals); n > 0 && fnLocals[n-1].valType == lt {
+			fnLocals[n-1].count++
+		} else {
+			fnLocals = append(fnLocals, locals{count: 1, valType: lt})
+		}
+	}
+	code := leb128Writer{}
+	code.writeU32(uint32(len(fnLocals)))
+	for _, l := range fnLocals {
+		code.writeU32(l.count)
+		code.writeU8(uint8(l.valType))
+	}
+	body, err := a.body(rest, localIDs)
+	if err != nil {
+		return fmt.Errorf("func %d: %w", idx, err)
+	}
+	code.writeBytes(body)
+	// each entry is prefixed with its size
+	entry := leb128Writer{}
+	entry.writeU32(uint32(len(code.bytes)))
+	entry.writeBytes(code.bytes)
+	a.sections[CodeSection] = append(a.sections[CodeSection], entry.bytes)
+	return nil
+}
+
+// (table $id? (export "name")* min max? reftype) or (table $id? (export "name")* reftype (elem idx*))
+func (a *watAssembler) tableField(f *sexpr) error {
+	idx := a.tables.next()
+	rest, err := a.inlineExports(fieldRest(f), exportImportKindTable, idx)
+	if err != nil {
+		return err
+	}
+	w := leb128Writer{}
+	if len(rest) == 2 && rest[1].head() == "elem" {
+		// the table is sized to its elements
+		funcIdxs, err := a.funcIdxs(rest[1].list[1:])
+		if err != nil {
+			return err
+		}
+		n := &sexpr{atom: strconv.Itoa(len(funcIdxs))}
+		if err := a.tableType(&w, []*sexpr{n, n, rest[0]}); err != nil {
+			return err
+		}
+		a.elems.next()
+		a.elem(idx, []byte{uint8(OpcodeI32Const), 0x00, uint8(OpcodeEnd)}, funcIdxs)
+	} else if err := a.tableType(&w, rest); err != nil {
+		return err
+	}
+	a.sections[TableSection] = append(a.sections[TableSection], w.bytes)
+	return nil
+}
+
+// tableType encodes min max? reftype.
+func (a *watAssembler) tableType(w *leb128Writer, items []*sexpr) error {
+	if len(items) == 0 {
+		return fmt.Errorf("expected a table type")
+	}
+	refType, ok := watValTypes[items[len(items)-1].atom]
+	if !ok || (refType != FuncRef && refType != ExternRef) {
+		return fmt.Errorf("expected a reference type")
+	}
+	w.writeU8(uint8(refType))
+	return writeWatLimits(w, items[:len(items)-1])
+}
+
+// writeWatLimits encodes i64? min max? shared?.
+// https://webassembly.github.io/spec/core/binary/types.html#limits
+func writeWatLimits(w *leb128Writer, items []*sexpr) error {
+	flags := uint8(0x00)
+	if len(items) > 0 && items[0].atom == "i64" {
+		flags |= 0x04
+		items = items[1:]
+	}
+	if n := len(items); n > 0 && items[n-1].atom == "shared" {
+		flags |= 0x02
+		items = items[:n-1]
+	}
+	if len(items) < 1 || len(items) > 2 {
+		return fmt.Errorf("expected limits")
+	}
+	var bounds []uint64
+	for _, item := range items {
+		v, err := parseU64(item)
+		if err != nil {
+			return err
+		}
+		bounds = append(bounds, v)
+	}
+	if len(bounds) == 2 {
+		flags |= 0x01
+	}
+	w.writeU8(flags)
+	for _, v := range bounds {
+		w.writeU64(v)
+	}
+	return nil
+}
+
+// (memory $id? (export "name")* i64? min max? shared?)
+func (a *watAssembler) memoryField(f *sexpr) error {
+	idx := a.mems.next()
+	rest, err := a.inlineExports(fieldRest(f), exportImportKindMem, idx)
+	if err != nil {
+		return err
+	}
+	w := leb128Writer{}
+	if err := writeWatLimits(&w, rest); err != nil {
+		return err
+	}
+	a.sections[MemorySection] = append(a.sections[MemorySection], w.bytes)
+	return nil
+}
+
+// writeGlobalType encodes valtype or (mut valtype).
+func writeGlobalType(w *leb128Writer, e *sexpr) error {
+	mut := uint8(0x00)
+	if e.head() == "mut" && len(e.list) == 2 {
+		mut = 0x01
+		e = e.list[1]
+	}
+	t, ok := watValTypes[e.atom]
+	if !ok || !e.isAtom() {
+		return fmt.Errorf("expected a global type")
+	}
+	w.writeU8(uint8(t))
+	w.writeU8(mut)
+	return nil
+}
+
+// (global $id? (export "name")* globaltype expr)
+func (a *watAssembler) globalField(f *sexpr) error {
+	idx := a.globals.next()
+	rest, err := a.inlineExports(fieldRest(f), exportImportKindGlobal, idx)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("expected a global type")
+	}
+	w := leb128Writer{}
+	if err := writeGlobalType(&w, rest[0]); err != nil {
+		return err
+	}
+	init, err := a.body(rest[1:], nil)
+	if err != nil {
+		return fmt.Errorf("global %d: %w", idx, err)
+	}
+	w.writeBytes(init)
+	a.sections[GlobalSection] = append(a.sections[GlobalSection], w.bytes)
+	return nil
+}
+
+// (export "name" (func|table|memory|global idx))
+func (a *watAssembler) exportField(f *sexpr) error {
+	if len(f.list) != 3 || !f.list[1].str || len(f.list[2].list) != 2 {
+		return fmt.Errorf("expected (export \"name\" (kind idx))")
+	}
+	desc := f.list[2]
+	kinds := map[string]exportImportKind{
+		"func":   exportImportKindFunc,
+		"table":  exportImportKindTable,
+		"memory": exportImportKindMem,
+		"global": exportImportKindGlobal,
+	}
+	kind, ok := kinds[desc.head()]
+	if !ok {
+		return fmt.Errorf("%s exports are not supported", desc.head())
+	}
+	idx, err := a.space(desc.head()).idx(desc.list[1])
+	if err != nil {
+		return err
+	}
+	a.export(f.list[1].atom, kind, idx)
+	return nil
+}
+
+// (start funcidx)
+func (a *watAssembler) startField(f *sexpr) error {
+	if len(f.list) != 2 {
+		return fmt.Errorf("expected (start idx)")
+	}
+	idx, err := a.funcs.idx(f.list[1])
+	if err != nil {
+		return err
+	}
+	a.start = &idx
+	return nil
+}
+
+// offset reads (offset instr*) or a single folded instr.
+func (a *watAssembler) offset(e *sexpr) ([]byte, error) {
+	if e.head() == "offset" {
+		return a.body(e.list[1:], nil)
+	}
+	return a.body([]*sexpr{e}, nil)
+}
+
+func (a *watAssembler) funcIdxs(items []*sexpr) ([]uint32, error) {
+	idxs := make([]uint32, len(items))
+	for i, item := range items {
+		var err error
+		if idxs[i], err = a.funcs.idx(item); err != nil {
+			return nil, err
+		}
+	}
+	return idxs, nil
+}
+
+// elem encodes an active segment of func indices, offset nil makes it passive.
+// https://webassembly.github.io/spec/core/binary/modules.html#element-section
+func (a *watAssembler) elem(tableIdx uint32, offset []byte, funcIdxs []uint32) {
+	w := leb128Writer{}
+	switch {
+	case offset == nil:
+		w.writeU32(1)
+		w.writeU8(0x00)
+	case tableIdx == 0:
+		w.writeU32(0)
+		w.writeBytes(offset)
+	default:
+		w.writeU32(2)
+		w.writeU32(tableIdx)
+		w.writeBytes(offset)
+		w.writeU8(0x00)
+	}
+	w.writeU32(uint32(len(funcIdxs)))
+	for _, idx := range funcIdxs {
+		w.writeU32(idx)
+	}
+	a.sections[ElementSection] = append(a.sections[ElementSection], w.bytes)
+}
+
+// (elem $id? declare? (table idx)? offset? func? funcidx*)
+func (a *watAssembler) elemField(f *sexpr) error {
+	a.elems.next()
+	rest := fieldRest(f)
+	declare := len(rest) > 0 && rest[0].atom == "declare"
+	if declare {
+		rest = rest[1:]
+	}
+	tableIdx := uint32(0)
+	if len(rest) > 0 && rest[0].head() == "table" {
+		if len(rest[0].list) != 2 {
+			return fmt.Errorf("expected (table idx)")
+		}
+		var err error
+		if tableIdx, err = a.tables.idx(rest[0].list[1]); err != nil {
+			return err
+		}
+		rest = rest[1:]
+	}
+	var offset []byte
+	if len(rest) > 0 && rest[0].isList {
+		var err error
+		if offset, err = a.offset(rest[0]); err != nil {
+			return err
+		}
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0].atom == "func" {
+		rest = rest[1:]
+	} else if len(rest) > 0 && !rest[0].isIdx() {
+		return fmt.Errorf("element expressions are not supported")
+	}
+	funcIdxs, err := a.funcIdxs(rest)
+	if err != nil {
+		return err
+	}
+	if !declare {
+		a.elem(tableIdx, offset, funcIdxs)
+		return nil
+	}
+	w := leb128Writer{}
+	w.writeU32(3)
+	w.writeU8(0x00)
+	w.writeU32(uint32(len(funcIdxs)))
+	for _, idx := range funcIdxs {
+		w.writeU32(idx)
+	}
+	a.sections[ElementSection] = append(a.sections[ElementSection], w.bytes)
+	return nil
+}
+
+// (data $id? (memory idx)? offset? string*)
+// https://webassembly.github.io/spec/core/binary/modules.html#data-section
+func (a *watAssembler) dataField(f *sexpr) error {
+	a.datas.next()
+	rest := fieldRest(f)
+	memIdx := uint32(0)
+	if len(rest) > 0 && rest[0].head() == "memory" {
+		if len(rest[0].list) != 2 {
+			return fmt.Errorf("expected (memory idx)")
+		}
+		var err error
+		if memIdx, err = a.mems.idx(rest[0].list[1]); err != nil {
+			return err
+		}
+		rest = rest[1:]
+	}
+	w := leb128Writer{}
+	if len(rest) > 0 && rest[0].isList {
+		offset, err := a.offset(rest[0])
+		if err != nil {
+			return err
+		}
+		if memIdx == 0 {
+			w.writeU32(0)
+		} else {
+			w.writeU32(2)
+			w.writeU32(memIdx)
+		}
+		w.writeBytes(offset)
+		rest = rest[1:]
+	} else {
+		w.writeU32(1)
+	}
+	var init []byte
+	for _, s := range rest {
+		if !s.str {
+			return fmt.Errorf("expected a data string")
+		}
+		init = append(init, s.atom...)
+	}
+	w.writeU32(uint32(len(init)))
+	w.writeBytes(init)
+	a.sections[DataSection] = append(a.sections[DataSection], w.bytes)
+	return nil
+}
+
+// https://webassembly.github.io/spec/core/binary/modules.html#binary-module
+func (a *watAssembler) encode() []byte {
+	w := leb128Writer{}
+	w.writeBytes([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00})
+	section := func(id SectionID, entries [][]byte) {
+		if len(entries) == 0 {
+			return
+		}
+		s := leb128Writer{}
+		s.writeU32(uint32(len(entries)))
+		for _, entry := range entries {
+			s.writeBytes(entry)
+		}
+		w.writeSection(id, s.bytes)
+	}
+
+	var types [][]byte
+	for _, t := range a.types {
+		s := leb128Writer{}
+		s.writeU8(0x60)
+		s.writeU32(uint32(len(t.params)))
+		for _, param := range t.params {
+			s.writeU8(uint8(param))
+		}
+		s.writeU32(uint32(len(t.results)))
+		for _, result := range t.results {
+			s.writeU8(uint8(result))
+		}
+		types = append(types, s.bytes)
+	}
+	section(TypeSection, types)
+	for _, id := range []SectionID{ImportSection, FunctionSection, TableSection, MemorySection, GlobalSection, ExportSection} {
+		section(id, a.sections[id])
+	}
+	if a.start != nil {
+		s := leb128Writer{}
+		s.writeU32(*a.start)
+		w.writeSection(StartSection, s.bytes)
+	}
+	section(ElementSection, a.sections[ElementSection])
+	if a.dataCount {
+		s := leb128Writer{}
+		s.writeU32(uint32(len(a.sections[DataSection])))
+		w.writeSection(DataCountSection, s.bytes)
+	}
+	section(CodeSection, a.sections[CodeSection])
+	section(DataSection, a.sections[DataSection])
+	if names := a.nameSection(); names != nil {
+		w.writeSection(CustomSection, names)
+	}
+	return w.bytes
+}
+
+// nameSection encodes the module, func and local names, it is nil without any.
+// https://webassembly.github.io/spec/core/appendix/custom.html#name-section
+func (a *watAssembler) nameSection() []byte {
+	if a.moduleName == "" && len(a.funcs.names) == 0 && len(a.localNames) == 0 {
+		return nil
+	}
+	w := leb128Writer{}
+	w.writeU32(uint32(len("name")))
+	w.writeString("name")
+	subsection := func(id uint8, content []byte) {
+		w.writeU8(id)
+		w.writeU32(uint32(len(content)))
+		w.writeBytes(content)
+	}
+	if a.moduleName != "" {
+		s := leb128Writer{}
+		s.writeU32(uint32(len(a.moduleName)))
+		s.writeString(a.moduleName)
+		subsection(0, s.bytes)
+	}
+	if len(a.funcs.names) > 0 {
+		s := leb128Writer{}
+		writeNameMap(&s, a.funcs.names)
+		subsection(1, s.bytes)
+	}
+	if len(a.localNames) > 0 {
+		s := leb128Writer{}
+		s.writeU32(uint32(len(a.localNames)))
+		for _, funcIdx := range sortedIdxs(a.localNames) {
+			s.writeU32(funcIdx)
+			writeNameMap(&s, a.localNames[funcIdx])
+		}
+		subsection(2, s.bytes)
+	}
+	return w.bytes
+}
+
+func writeNameMap(w *leb128Writer, names map[uint32]string) {
+	w.writeU32(uint32(len(names)))
+	for _, idx := range sortedIdxs(names) {
+		w.writeU32(idx)
+		w.writeU32(uint32(len(names[idx])))
+		w.writeString(names[idx])
+	}
+}
+
+func sortedIdxs[V any](m map[uint32]V) []uint32 {
+	idxs := make([]uint32, 0, len(m))
+	for idx := range m {
+		idxs = append(idxs, idx)
+	}
+	sort.Slice(idxs, func(i, j int) bool { return idxs[i] < idxs[j] })
+	return idxs
+}
+
+// unfold flattens folded instrs, the operands of a folded instr come before it.
+// https://webassembly.github.io/spec/core/text/instructions.html#folded-instructions
+func unfold(items []*sexpr) ([]*sexpr, error) {
+	var flat []*sexpr
+	for _, item := range items {
+		switch {
+		case !item.isList || isImmediateList(item):
+			flat = append(flat, item)
+		case item.head() == "":
+			return nil, fmt.Errorf("expected an instruction")
+		default:
+			instrs, err := unfoldInstr(item)
+			if err != nil {
+				return nil, err
+			}
+			flat = append(flat, instrs...)
+		}
+	}
+	return flat, nil
+}
+
+// isImmediateList reports whether e is a block type or type use following an instr.
+func isImmediateList(e *sexpr) bool {
+	switch e.head() {
+	case "type", "param", "result":
+		return true
+	}
+	return false
+}
+
+func unfoldInstr(e *sexpr) ([]*sexpr, error) {
+	op := e.list[0]
+	rest := e.list[1:]
+	switch op.atom {
+	case "block", "loop", "if":
+		// the label and block type stay with the instr
+		header := []*sexpr{op}
+		if len(rest) > 0 && rest[0].isID() {
+			header = append(header, rest[0])
+			rest = rest[1:]
+		}
+		for len(rest) > 0 && isImmediateList(rest[0]) {
+			header = append(header, rest[0])
+			rest = rest[1:]
+		}
+		end := &sexpr{atom: "end"}
+		if op.atom != "if" {
+			body, err := unfold(rest)
+			if err != nil {
+				return nil, err
+			}
+			return append(append(header, body...), end), nil
+		}
+
+		// (if label blocktype folded* (then instr*) (else instr*)?)
+		var flat []*sexpr
+		for len(rest) > 0 && rest[0].head() != "then" {
+			cond, err := unfold(rest[:1])
+			if err != nil {
+				return nil, err
+			}
+			flat = append(flat, cond...)
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return nil, fmt.Errorf("if: missing then")
+		}
+		flat = append(flat, header...)
+		then, err := unfold(rest[0].list[1:])
+		if err != nil {
+			return nil, err
+		}
+		flat = append(flat, then...)
+		rest = rest[1:]
+		if len(rest) > 0 && rest[0].head() == "else" {
+			els, err := unfold(rest[0].list[1:])
+			if err != nil {
+				return nil, err
+			}
+			flat = append(append(flat, &sexpr{atom: "else"}), els...)
+			rest = rest[1:]
+		}
+		if len(rest) != 0 {
+			return nil, fmt.Errorf("if: unexpected items after else")
+		}
+		return append(flat, end), nil
+	}
+
+	var operands, instr []*sexpr
+	instr = append(instr, op)
+	for _, item := range rest {
+		if item.isList && !isImmediateList(item) {
+			operands = append(operands, item)
+		} else {
+			instr = append(instr, item)
+		}
+	}
+	flat, err := unfold(operands)
+	if err != nil {
+		return nil, err
+	}
+	return append(flat, instr...), nil
+}
+
+// body encodes instrs followed by an end, for a func body or a constant expression.
+// https://webassembly.github.io/spec/core/text/instructions.html
+func (a *watAssembler) body(items []*sexpr, localIDs map[string]uint32) ([]byte, error) {
+	instrs, err := unfold(items)
+	if err != nil {
+		return nil, err
+	}
+	w := leb128Writer{}
+	// names of the enclosing labels, innermost last
+	var labels []string
+	for x := 0; x < len(instrs); x++ {
+		in := instrs[x]
+		if !in.isAtom() {
+			return nil, fmt.Errorf("expected an instruction")
+		}
+		// next returns the following item when it is an atom
+		next := func() *sexpr {
+			if x+1 < len(instrs) && instrs[x+1].isAtom() {
+				return instrs[x+1]
+			}
+			return nil
+		}
+		// immediate returns the next atom, which the instr requires
+		immediate := func() (*sexpr, error) {
+			imm := next()
+			if imm == nil {
+				return nil, fmt.Errorf("%s: missing immediate", in.atom)
+			}
+			x++
+			return imm, nil
+		}
+		// optionalIdx returns the next atom when it is an index, nil otherwise
+		optionalIdx := func() *sexpr {
+			if imm := next(); imm != nil && imm.isIdx() {
+				x++
+				return imm
+			}
+			return nil
+		}
+		// idx resolves an immediate index in space
+		idx := func(space *watSpace) (uint32, error) {
+			imm, err := immediate()
+			if err != nil {
+				return 0, err
+			}
+			return space.idx(imm)
+		}
+		// label returns the depth of the label named by the next atom
+		label := func() (uint32, error) {
+			imm, err := immediate()
+			if err != nil {
+				return 0, err
+			}
+			if !imm.isID() {
+				return parseU32(imm)
+			}
+			for depth := 0; depth < len(labels); depth++ {
+				if labels[len(labels)-1-depth] == imm.atom {
+					return uint32(depth), nil
+				}
+			}
+			return 0, fmt.Errorf("unknown label %s", imm.atom)
+		}
+		// typeUse reads the type use of a block or an indirect call
+		typeUse := func() (*uint32, funcType, error) {
+			t := funcType{}
+			var typeIdx *uint32
+			for x+1 < len(instrs) && isImmediateList(instrs[x+1]) {
+				x++
+				field := instrs[x]
+				if field.head() == "type" {
+					if len(field.list) != 2 {
+						return nil, t, fmt.Errorf("expected (type idx)")
+					}
+					i, err := a.typeSpace.idx(field.list[1])
+					if err != nil {
+						return nil, t, err
+					}
+					typeIdx = &i
+					continue
+				}
+				_, types, err := valTypes(field)
+				if err != nil {
+					return nil, t, err
+				}
+				if field.head() == "param" {
+					t.params = append(t.params, types...)
+				} else {
+					t.results = append(t.results, types...)
+				}
+			}
+			return typeIdx, t, nil
+		}
+		// memArg encodes the optional memory index, offset and align of a memory access
+		memArg := func(natural uint32) error {
+			memIdx := uint32(0)
+			if imm := optionalIdx(); imm != nil {
+				var err error
+				if memIdx, err = a.mems.idx(imm); err != nil {
+					return err
+				}
+			}
+			offset, align := uint64(0), natural
+			for imm := next(); imm != nil; imm = next() {
+				key, value, found := strings.Cut(imm.atom, "=")
+				if !found || (key != "offset" && key != "align") {
+					break
+				}
+				v, err := parseU64(&sexpr{atom: value})
+				if err != nil {
+					return err
+				}
+				if key == "offset" {
+					offset = v
+				} else {
+					if v == 0 || v&(v-1) != 0 {
+						return fmt.Errorf("alignment must be a power of two")
+					}
+					align = 0
+					for ; v > 1; v >>= 1 {
+						align++
+					}
+				}
+				x++
+			}
+			if memIdx != 0 {
+				w.writeU32(align | memArgMemIdxFlag)
+				w.writeU32(memIdx)
+			} else {
+				w.writeU32(align)
+			}
+			w.writeU64(offset)
+			return nil
+		}
+		// optionalSpaceIdx encodes the optional index of a table or memory, 0 by default
+		optionalSpaceIdx := func(space *watSpace) error {
+			i := uint32(0)
+			if imm := optionalIdx(); imm != nil {
+				var err error
+				if i, err = space.idx(imm); err != nil {
+					return err
+				}
+			}
+			w.writeU32(i)
+			return nil
+		}
+
+		if prefix, kind, ok := watPrefixedOp(in.atom); ok {
+			w.writeU8(uint8(prefix))
+			w.writeU32(kind)
+			switch {
+			case in.atom == "memory.init":
+				// (memory.init memidx? dataidx), encoded as the data index first
+				imms := []*sexpr{optionalIdx(), optionalIdx()}
+				if imms[0] == nil {
+					return nil, fmt.Errorf("%s: missing immediate", in.atom)
+				}
+				memIdx, dataImm := uint32(0), imms[0]
+				if imms[1] != nil {
+					var err error
+					if memIdx, err = a.mems.idx(imms[0]); err != nil {
+						return nil, err
+					}
+					dataImm = imms[1]
+				}
+				dataIdx, err := a.datas.idx(dataImm)
+				if err != nil {
+					return nil, err
+				}
+				w.writeU32(dataIdx)
+				w.writeU32(memIdx)
+				a.dataCount = true
+			case in.atom == "data.drop":
+				dataIdx, err := idx(&a.datas)
+				if err != nil {
+					return nil, err
+				}
+				w.writeU32(dataIdx)
+				a.dataCount = true
+			case in.atom == "memory.copy":
+				// the destination and source memories
+				if err := optionalSpaceIdx(&a.mems); err != nil {
+					return nil, err
+				}
+				if err := optionalSpaceIdx(&a.mems); err != nil {
+					return nil, err
+				}
+			case in.atom == "memory.fill":
+				if err := optionalSpaceIdx(&a.mems); err != nil {
+					return nil, err
+				}
+			case strings.HasPrefix(in.atom, "table."):
+				if err := optionalSpaceIdx(&a.tables); err != nil {
+					return nil, err
+				}
+			case in.atom == "v128.const":
+				if err := a.v128Const(&w, instrs, &x); err != nil {
+					return nil, err
+				}
+			default:
+				if natural, ok := naturalAlignment(in.atom); ok {
+					if err := memArg(natural); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+
+		op, ok := watOpCodes[in.atom]
+		if !ok {
+			return nil, fmt.Errorf("unknown instruction %s", in.atom)
+		}
+		w.writeU8(uint8(op))
+		switch op {
+		case OpcodeBlock, OpcodeLoop, OpcodeIf:
+			name := ""
+			if imm := next(); imm != nil && imm.isID() {
+				x++
+				name = imm.atom
+			}
+			labels = append(labels, name)
+			typeIdx, t, err := typeUse()
+			if err != nil {
+				return nil, err
+			}
+			// https://webassembly.github.io/spec/core/binary/instructions.html#binary-blocktype
+			switch {
+			case typeIdx != nil:
+				w.writeI64(int64(*typeIdx))
+			case len(t.params) == 0 && len(t.results) == 0:
+				w.writeU8(0x40)
+			case len(t.params) == 0 && len(t.results) == 1:
+				w.writeU8(uint8(t.results[0]))
+			default:
+				w.writeI64(int64(a.typeIdx(t)))
+			}
+		case OpcodeElse, OpcodeEnd:
+			if len(labels) == 0 {
+				return nil, fmt.Errorf("%s outside of a block", in.atom)
+			}
+			// the label may be repeated after else and end
+			if imm := next(); imm != nil && imm.isID() {
+				x++
+			}
+			if op == OpcodeEnd {
+				labels = labels[:len(labels)-1]
+			}
+		case OpcodeBr, OpcodeBrIf:
+			depth, err := label()
+			if err != nil {
+				return nil, err
+			}
+			w.writeU32(depth)
+		case OpcodeBrTable:
+			var depths []uint32
+			for imm := next(); imm != nil && imm.isIdx(); imm = next() {
+				depth, err := label()
+				if err != nil {
+					return nil, err
+				}
+				depths = append(depths, depth)
+			}
+			if len(depths) == 0 {
+				return nil, fmt.Errorf("%s: missing immediate", in.atom)
+			}
+			// the last label is the default one
+			w.writeU32(uint32(len(depths) - 1))
+			for _, depth := range depths {
+				w.writeU32(depth)
+			}
+		case OpcodeCall, OpcodeReturnCall, OpcodeRefFunc:
+			funcIdx, err := idx(&a.funcs)
+			if err != nil {
+				return nil, err
+			}
+			w.writeU32(funcIdx)
+		case OpcodeCallIndirect, OpcodeReturnCallIndirect:
+			tableIdx := uint32(0)
+			if imm := optionalIdx(); imm != nil {
+				if tableIdx, err = a.tables.idx(imm); err != nil {
+					return nil, err
+				}
+			}
+			typeIdx, t, err := typeUse()
+			if err != nil {
+				return nil, err
+			}
+			if typeIdx == nil {
+				i := a.typeIdx(t)
+				typeIdx = &i
+			}
+			w.writeU32(*typeIdx)
+			w.writeU32(tableIdx)
+		case OpcodeLocalGet, OpcodeLocalSet, OpcodeLocalTee:
+			imm, err := immediate()
+			if err != nil {
+				return nil, err
+			}
+			localIdx, err := (&watSpace{ids: localIDs}).idx(imm)
+			if err != nil {
+				return nil, err
+			}
+			w.writeU32(localIdx)
+		case OpcodeGlobalGet, OpcodeGlobalSet:
+			globalIdx, err := idx(&a.globals)
+			if err != nil {
+				return nil, err
+			}
+			w.writeU32(globalIdx)
+		case OpcodeTableGet, OpcodeTableSet:
+			if err := optionalSpaceIdx(&a.tables); err != nil {
+				return nil, err
+			}
+		case OpcodeMemorySize, OpcodeMemoryGrow:
+			if err := optionalSpaceIdx(&a.mems); err != nil {
+				return nil, err
+			}
+		case OpcodeRefNull:
+			imm, err := immediate()
+			if err != nil {
+				return nil, err
+			}
+			switch imm.atom {
+			case "func":
+				w.writeU8(uint8(FuncRef))
+			case "extern":
+				w.writeU8(uint8(ExternRef))
+			default:
+				return nil, fmt.Errorf("unknown heap type %s", imm.atom)
+			}
+		case OpcodeI32Const, OpcodeI64Const, OpcodeF32Const, OpcodeF64Const:
+			imm, err := immediate()
+			if err != nil {
+				return nil, err
+			}
+			if err := writeConst(&w, op, imm.atom); err != nil {
+				return nil, err
+			}
+		default:
+			if natural, ok := naturalAlignment(in.atom); ok {
+				if err := memArg(natural); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if len(labels) != 0 {
+		return nil, fmt.Errorf("missing end")
+	}
+	w.writeU8(uint8(OpcodeEnd))
+	return w.bytes, nil
+}
+
+// v128Const encodes the shape and lanes following v128.const at instrs[*x].
+func (a *watAssembler) v128Const(w *leb128Writer, instrs []*sexpr, x *int) error {
+	shapes := map[string]struct {
+		lanes, bits int
+		float       bool
+	}{
+		"i8x16": {16, 8, false},
+		"i16x8": {8, 16, false},
+		"i32x4": {4, 32, false},
+		"i64x2": {2, 64, false},
+		"f32x4": {4, 32, true},
+		"f64x2": {2, 64, true},
+	}
+	if *x+1 >= len(instrs) {
+		return fmt.Errorf("v128.const: missing shape")
+	}
+	shape, ok := shapes[instrs[*x+1].atom]
+	if !ok {
+		return fmt.Errorf("v128.const: unknown shape %s", instrs[*x+1].atom)
+	}
+	if *x+1+shape.lanes >= len(instrs) {
+		return fmt.Errorf("v128.const: expected %d lanes", shape.lanes)
+	}
+	var b [16]byte
+	for lane := 0; lane < shape.lanes; lane++ {
+		text := instrs[*x+2+lane].atom
+		var v uint64
+		var err error
+		if shape.float {
+			v, err = parseFloatBits(text, shape.bits)
+		} else {
+			v, err = parseIntBits(text, shape.bits)
+		}
+		if err != nil {
+			return err
+		}
+		size := shape.bits / 8
+		for i := 0; i < size; i++ {
+			b[lane*size+i] = byte(v >> (8 * i))
+		}
+	}
+	*x += 1 + shape.lanes
+	w.writeBytes(b[:])
+	return nil
+}
+
+// watPrefixedOp returns the prefix and kind of a 0xFC, 0xFD or 0xFE prefixed instr.
+func watPrefixedOp(name string) (Opcode, uint32, bool) {
+	for _, ops := range []struct {
+		prefix Opcode
+		names  map[uint32]string
+	}{
+		{OpcodeMemoryCopyOrFill, prefixedOpNames},
+		{OpcodeSIMD, simdOpNames},
+		{OpcodeAtomic, atomicOpNames},
+	} {
+		for kind, n := range ops.names {
+			if n == name {
+				return ops.prefix, kind, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// valTypes reads a param, result or local field, a single type may be named.
+func valTypes(field *sexpr) (string, []type_, error) {
+	if !field.isList || len(field.list) == 0 {
+		return "", nil, fmt.Errorf("expected a param, result or local")
+	}
+	rest := field.list[1:]
+	id := ""
+	if len(rest) > 0 && rest[0].isID() {
+		if len(rest) != 2 {
+			return "", nil, fmt.Errorf("a named %s has a single type", field.head())
+		}
+		id, rest = rest[0].atom, rest[1:]
+	}
+	types := make([]type_, len(rest))
+	for i, t := range rest {
+		var ok bool
+		types[i], ok = watValTypes[t.atom]
+		if !ok || !t.isAtom() {
+			return "", nil, fmt.Errorf("unknown type %s", t.atom)
+		}
+	}
+	return id, types, nil
+}
+
+func parseU32(e *sexpr) (uint32, error) {
+	v, err := parseU64(e)
+	if err != nil || v > math.MaxUint32 {
+		return 0, fmt.Errorf("expected an unsigned integer, got %s", e.atom)
+	}
+	return uint32(v), nil
+}
+
+func parseU64(e *sexpr) (uint64, error) {
+	if !e.isAtom() || strings.HasPrefix(e.atom, "-") || strings.HasPrefix(e.atom, "+") {
+		return 0, fmt.Errorf("expected an unsigned integer, got %s", e.atom)
+	}
+	v, err := parseIntBits(e.atom, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected an unsigned integer, got %s", e.atom)
+	}
+	return v, nil
+}
+
+// parseIntBits parses a signed or unsigned integer to the two's complement bits of its size.
+// https://webassembly.github.io/spec/core/text/values.html#integers
+func parseIntBits(text string, bits int) (uint64, error) {
+	digits := strings.ReplaceAll(text, "_", "")
+	neg := strings.HasPrefix(digits, "-")
+	if neg || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	base := 10
+	if strings.HasPrefix(digits, "0x") {
+		base, digits = 16, digits[2:]
+	}
+	v, err := strconv.ParseUint(digits, base, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid i%d constant %s", bits, text)
+	}
+	if neg {
+		if v > 1<<(bits-1) {
+			return 0, fmt.Errorf("invalid i%d constant %s", bits, text)
+		}
+		v = -v
+	}
+	if bits < 64 {
+		v &= 1<<bits - 1
+	}
+	return v, nil
+}
+
+// parseFloatBits parses a float in decimal or hexadecimal notation, inf or a nan with an
+// optional payload to the bits of its size.
+// https://webassembly.github.io/spec/core/text/values.html#floating-point
+func parseFloatBits(text string, bits int) (uint64, error) {
+	digits := strings.ReplaceAll(text, "_", "")
+	sign := uint64(0)
+	if strings.HasPrefix(digits, "-") {
+		sign = 1 << (bits - 1)
+	}
+	if sign != 0 || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	// the exponent bits of an infinity or a nan, and the canonical nan payload
+	expBits, canonical := uint64(0xff)<<23, uint64(1)<<22
+	if bits == 64 {
+		expBits, canonical = uint64(0x7ff)<<52, uint64(1)<<51
+	}
+	switch {
+	case digits == "inf":
+		return sign | expBits, nil
+	case digits == "nan":
+		return sign | expBits | canonical, nil
+	case strings.HasPrefix(digits, "nan:0x"):
+		payload, err := strconv.ParseUint(digits[len("nan:0x"):], 16, bits)
+		if err != nil || payload == 0 || payload >= canonical<<1 {
+			return 0, fmt.Errorf("invalid f%d constant %s", bits, text)
+		}
+		return sign | expBits | payload, nil
+	}
+	if strings.HasPrefix(digits, "0x") && !strings.ContainsAny(digits, "pP") {
+		// Go requires the exponent of a hexadecimal float
+		digits += "p0"
+	}
+	v, err := strconv.ParseFloat(digits, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid f%d constant %s", bits, text)
+	}
+	if bits == 32 {
+		return sign | uint64(math.Float32bits(float32(v))), nil
+	}
+	return sign | math.Float64bits(v), nil
+}
+
+// writeConst encodes the immediate of a const instr.
+func writeConst(w *leb128Writer, op Opcode, text string) error {
+	switch op {
+	case OpcodeI32Const:
+		v, err := parseIntBits(text, 32)
+		if err != nil {
+			return err
+		}
+		w.writeI32(int32(v))
+	case OpcodeI64Const:
+		v, err := parseIntBits(text, 64)
+		if err != nil {
+			return err
+		}
+		w.writeI64(int64(v))
+	case OpcodeF32Const:
+		v, err := parseFloatBits(text, 32)
+		if err != nil {
+			return err
+		}
+		w.bytes = binary.LittleEndian.AppendUint32(w.bytes, uint32(v))
+	case OpcodeF64Const:
+		v, err := parseFloatBits(text, 64)
+		if err != nil {
+			return err
+		}
+		w.bytes = binary.LittleEndian.AppendUint64(w.bytes, v)
+	}
+	return nil
+}
+
+// naturalAlignment returns the log2 of the access size of a load or store.
+func naturalAlignment(name string) (uint32, bool) {
+	t, access, found := strings.Cut(name, ".")
+	access = strings.TrimPrefix(access, "atomic.")
+	if !found || (!strings.HasPrefix(access, "load") && !strings.HasPrefix(access, "store")) {
+		return 0, false
+	}
+	switch {
+	case t == "v128":
+		return 4, true
+	case strings.HasSuffix(access, "8"), strings.HasSuffix(access, "8_s"), strings.HasSuffix(access, "8_u"):
+		return 0, true
+	case strings.HasSuffix(access, "16"), strings.HasSuffix(access, "16_s"), strings.HasSuffix(access, "16_u"):
+		return 1, true
+	case strings.HasSuffix(access, "32"), strings.HasSuffix(access, "32_s"), strings.HasSuffix(access, "32_u"):
+		return 2, true
+	case t == "i64" || t == "f64":
+		return 3, true
+	}
+	return 2, true
+}
+
+var watValTypes = map[string]type_{
+	"i32":       I32,
+	"i64":       I64,
+	"f32":       F32,
+	"f64":       F64,
+	"v128":      V128,
+	"funcref":   FuncRef,
+	"externref": ExternRef,
+}
+
+// watOpCodes maps the names printed by Disassemble back to their opcodes.
+var watOpCodes = func() map[string]Opcode {
+	ops := make(map[string]Opcode, len(opNames))
+	for op, name := range opNames {
+		ops[name] = op
+	}
+	return ops
+}()
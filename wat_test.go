@@ -0,0 +1,250 @@
+package wasm_go
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWat2Wasm(t *testing.T) {
+	wat := `
+		(module
+			(func (param i32) (param i32) (result i32)
+				local.get 0
+				local.get 1
+				i32.add
+			)
+			(export "add" (func 0))
+		)
+	`
+	wasm, err := wat2wasm(wat)
+	if !assert.NoError(t, err) {
+		return
+	}
+	expected := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		// type section
+		0x01, 0x07, 0x01, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f,
+		// function section
+		0x03, 0x02, 0x01, 0x00,
+		// export section
+		0x07, 0x07, 0x01, 0x03, 'a', 'd', 'd', 0x00, 0x00,
+		// code section
+		0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
+	}
+	assert.Equal(t, expected, wasm)
+
+	i, err := NewInterpreter(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ret, err := invokeExport(t, &i, "add", ValueFromI32(1), ValueFromI32(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(3)}, ret)
+}
+
+func TestWat2WasmInstrs(t *testing.T) {
+	// ids are resolved to indices, they are kept in a name section Disassemble ignores
+	wat := `
+		(module
+			(type $binop (func (param i32 i32) (result i32)))
+			(memory $mem (export "memory") 1 2)
+			(func $sum (export "sum") (param $n i64) (result i64)
+				(local $acc i64) (local i32)
+				block $done
+					loop $next
+						local.get $n
+						i64.eqz
+						br_if $done
+						local.get $acc
+						local.get $n
+						i64.add
+						local.set $acc
+						local.get $n
+						i64.const -1
+						i64.add
+						local.set $n
+						br $next
+					end $next
+				end
+				local.get $acc
+			)
+			(func (export "store") (param i32 f64) ;; a line comment
+				local.get 0
+				local.get 1
+				f64.store offset=8 align=4
+				(; a block comment ;)
+				i32.const 0xff
+				i32.const 0
+				i32.store8 offset=3
+				memory.size
+				drop
+			)
+			(func (export "pick") (type $binop)
+				local.get 0
+				local.get 1
+				block (param i32 i32) (result i32)
+					i32.sub
+				end
+				if (result i32)
+					f32.const 1.5
+					drop
+					i32.const 1
+				else
+					i32.const 2
+				end
+				call $const
+			)
+			(func $const (param i32) (result i32)
+				block
+					block
+						local.get 0
+						br_table 1 0 0
+					end
+					local.get 0
+					i32.const 0xffff_ffff
+					i32.add
+					return
+				end
+				i32.const 0
+			)
+		)
+	`
+	wasm, err := wat2wasm(wat)
+	if !assert.NoError(t, err) {
+		return
+	}
+	text, err := Disassemble(wasm)
+	assert.NoError(t, err)
+	assert.Equal(t, `(module
+  (func (;0;) (type 1) (param i64) (result i64)
+    (local i64)
+    (local i32)
+    block
+      loop
+        local.get 0
+        i64.eqz
+        br_if 1
+        local.get 1
+        local.get 0
+        i64.add
+        local.set 1
+        local.get 0
+        i64.const -1
+        i64.add
+        local.set 0
+        br 0
+      end
+    end
+    local.get 1
+  )
+  (func (;1;) (type 2) (param i32 f64)
+    local.get 0
+    local.get 1
+    f64.store offset=8
+    i32.const 255
+    i32.const 0
+    i32.store8 offset=3
+    memory.size
+    drop
+  )
+  (func (;2;) (type 0) (param i32 i32) (result i32)
+    local.get 0
+    local.get 1
+    block (type 0)
+      i32.sub
+    end
+    if (result i32)
+      f32.const 1.5
+      drop
+      i32.const 1
+    else
+      i32.const 2
+    end
+    call 3
+  )
+  (func (;3;) (type 3) (param i32) (result i32)
+    block
+      block
+        local.get 0
+        br_table 1 0 0
+      end
+      local.get 0
+      i32.const -1
+      i32.add
+      return
+    end
+    i32.const 0
+  )
+)
+`, text)
+
+	i, err := NewInterpreter(wasm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ret, err := invokeExport(t, &i, "sum", ValueFromI64(4))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI64(10)}, ret)
+	// if takes the else branch, br_table the default label
+	ret, err = invokeExport(t, &i, "pick", ValueFromI32(3), ValueFromI32(3))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(1)}, ret)
+	ret, err = invokeExport(t, &i, "pick", ValueFromI32(4), ValueFromI32(3))
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{ValueFromI32(0)}, ret)
+}
+
+func TestWat2WasmFolded(t *testing.T) {
+	folded, err := wat2wasm(`
+		(module
+			(func (param i32) (result i32)
+				(if (result i32) (i32.eqz (local.get 0))
+					(then (i32.const 1))
+					(else (i32.add (local.get 0) (i32.const 2)))
+				)
+			)
+		)
+	`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	plain, err := wat2wasm(`
+		(module
+			(func (param i32) (result i32)
+				local.get 0
+				i32.eqz
+				if (result i32)
+					i32.const 1
+				else
+					local.get 0
+					i32.const 2
+					i32.add
+				end
+			)
+		)
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, folded)
+}
+
+func TestWat2WasmErrors(t *testing.T) {
+	cases := []struct {
+		wat string
+		err string
+	}{
+		{`(module (func i32.frob))`, "func 0: unknown instruction i32.frob"},
+		{`(module (func block br $out end))`, "func 0: unknown label $out"},
+		{`(module (func block))`, "func 0: missing end"},
+		{`(module (func call $f))`, "func 0: unknown name $f"},
+		{`(module (func (if (i32.const 1))))`, "func 0: if: missing then"},
+		{`(module (table 1 anyref))`, "expected a reference type"},
+		{`(module (rec))`, "rec fields are not supported"},
+		{`(module (func)`, "unexpected end of text"},
+		{`(func)`, "expected (module ...)"},
+	}
+	for _, c := range cases {
+		_, err := wat2wasm(c.wat)
+		assert.EqualError(t, err, c.err, c.wat)
+	}
+}
@@ -0,0 +1,52 @@
+package wasm_go
+
+// leb128Writer is the encoding counterpart of leb128Reader.
+type leb128Writer struct {
+	bytes []byte
+}
+
+func (w *leb128Writer) writeU8(v uint8) {
+	w.bytes = append(w.bytes, v)
+}
+
+func (w *leb128Writer) writeU64(v uint64) {
+	for {
+		b := uint8(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			w.writeU8(b)
+			return
+		}
+		w.writeU8(b | 0x80)
+	}
+}
+
+func (w *leb128Writer) writeU32(v uint32) {
+	w.writeU64(uint64(v))
+}
+
+func (w *leb128Writer) writeI64(v int64) {
+	for {
+		b := uint8(v & 0x7F)
+		// arithmetic shift keeps the sign
+		v >>= 7
+		// done once the rest is only sign bits and b carries the sign bit
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			w.writeU8(b)
+			return
+		}
+		w.writeU8(b | 0x80)
+	}
+}
+
+func (w *leb128Writer) writeI32(v int32) {
+	w.writeI64(int64(v))
+}
+
+func (w *leb128Writer) writeBytes(b []byte) {
+	w.bytes = append(w.bytes, b...)
+}
+
+func (w *leb128Writer) writeString(s string) {
+	w.bytes = append(w.bytes, s...)
+}
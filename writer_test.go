@@ -0,0 +1,95 @@
+package wasm_go
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteUnsigned(t *testing.T) {
+	cases := map[uint64]string{
+		0:                    "00000000",
+		0x7f:                 "01111111",
+		0x80:                 "00000001 10000000",
+		0xff:                 "00000001 11111111",
+		0x91d:                "00010010 10011101",
+		0xef17:               "00000011 11011110 10010111",
+		624485:               "00100110 10001110 11100101",
+		0xffff:               "00000011 11111111 11111111",
+		18446744073709551615: "00000001 11111111 11111111 11111111 11111111 11111111 11111111 11111111 11111111 11111111",
+	}
+
+	for v, binaryString := range cases {
+		w := leb128Writer{}
+		w.writeU64(v)
+		assert.Equal(t, binaryStringToBytes(binaryString), w.bytes, "%d", v)
+
+		r := leb128Reader{bytes: w.bytes}
+		decoded, err := r.eatU64()
+		assert.NoError(t, err)
+		assert.Equal(t, v, decoded)
+	}
+
+	for _, v := range []uint32{0, 1, 0x7f, 0x80, 624485, math.MaxUint32} {
+		w := leb128Writer{}
+		w.writeU32(v)
+		r := leb128Reader{bytes: w.bytes}
+		decoded, err := r.eatU32()
+		assert.NoError(t, err)
+		assert.Equal(t, v, decoded)
+	}
+}
+
+func TestWriteSigned(t *testing.T) {
+	cases := map[int64]string{
+		-9223372036854775808: "01111111 10000000 10000000 10000000 10000000 10000000 10000000 10000000 10000000 10000000",
+		-624485:              "01011001 11110001 10011011",
+		^0x40:                "01111111 10111111",
+		^0x3f:                "01000000",
+		-1:                   "01111111",
+		0:                    "00000000",
+		1:                    "00000001",
+		0x3f:                 "00111111",
+		0x40:                 "00000000 11000000",
+		0xef17:               "00000011 11011110 10010111",
+		9223372036854775807:  "00000000 11111111 11111111 11111111 11111111 11111111 11111111 11111111 11111111 11111111",
+	}
+
+	for v, binaryString := range cases {
+		w := leb128Writer{}
+		w.writeI64(v)
+		assert.Equal(t, binaryStringToBytes(binaryString), w.bytes, "%d", v)
+
+		r := leb128Reader{bytes: w.bytes}
+		decoded, err := r.eatI64()
+		assert.NoError(t, err)
+		assert.Equal(t, v, decoded)
+	}
+
+	for _, v := range []int32{math.MinInt32, -624485, -65, -64, -1, 0, 1, 63, 64, math.MaxInt32} {
+		w := leb128Writer{}
+		w.writeI32(v)
+		r := leb128Reader{bytes: w.bytes}
+		decoded, err := r.eatI32()
+		assert.NoError(t, err)
+		assert.Equal(t, v, decoded)
+	}
+}
+
+func TestWriteBytesAndString(t *testing.T) {
+	w := leb128Writer{}
+	w.writeU32(3)
+	w.writeString("add")
+	w.writeBytes([]byte{0x01, 0x02})
+
+	r := leb128Reader{bytes: w.bytes}
+	length, err := r.eatU32()
+	assert.NoError(t, err)
+	s, err := r.eatString(length)
+	assert.NoError(t, err)
+	assert.Equal(t, "add", s)
+	b, err := r.eatBytes(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x01, 0x02}, b)
+}